@@ -1282,7 +1282,7 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 			}
 
 			isValid, err := bc.forker.ValidateReorg(bc.CurrentSnapBlock(), headers)
-			if err != nil {
+			if err != nil && !errors.Is(err, ErrReorgRejected) {
 				log.Warn("Reorg failed", "err", err)
 				return false
 			} else if !isValid {
@@ -1935,13 +1935,17 @@ func (bc *BlockChain) insertChain(chain types.Blocks, setHead bool) (int, error)
 
 	// Check the validity of incoming chain
 	isValid, err1 := bc.forker.ValidateReorg(bc.CurrentBlock(), headers)
-	if err1 != nil {
+	if err1 != nil && !errors.Is(err1, ErrReorgRejected) {
 		return it.index, err1
 	}
 
 	if !isValid {
 		// The chain to be imported is invalid as the blocks doesn't match with
 		// the whitelisted block number.
+		if err1 != nil {
+			return it.index, fmt.Errorf("%w: %w", whitelist.ErrMismatch, err1)
+		}
+
 		return it.index, whitelist.ErrMismatch
 	}
 
@@ -2402,7 +2406,7 @@ func (bc *BlockChain) insertSideChain(block *types.Block, it *insertIterator) (i
 	}
 
 	isValid, err := bc.forker.ValidateReorg(current, headers)
-	if err != nil {
+	if err != nil && !errors.Is(err, ErrReorgRejected) {
 		return it.index, err
 	}
 
@@ -2410,7 +2414,7 @@ func (bc *BlockChain) insertSideChain(block *types.Block, it *insertIterator) (i
 		localTd := bc.GetTd(current.Hash(), current.Number.Uint64())
 		log.Info("Sidechain written to disk", "start", it.first().NumberU64(), "end", it.previous().Number, "sidetd", externTd, "localtd", localTd)
 
-		return it.index, err
+		return it.index, nil
 	}
 	// Gather all the sidechain hashes (full blocks may be memory heavy)
 	var (
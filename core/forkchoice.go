@@ -19,6 +19,7 @@ package core
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"math/big"
 
 	"github.com/maticnetwork/crand"
@@ -26,6 +27,8 @@ import (
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/downloader/whitelist"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/params"
 )
 
@@ -122,11 +125,50 @@ func (f *ForkChoice) ReorgNeeded(current *types.Header, extern *types.Header) (b
 	return reorg, nil
 }
 
-// ValidateReorg calls the chain validator service to check if the reorg is valid or not
+// ErrReorgRejected is returned by ValidateReorg for a chain rejected by the finality
+// rules of the chain validator, i.e. an invalid reorg rather than a failure
+var ErrReorgRejected = errors.New("reorg rejected by the chain validator")
+
+// finalityRejections are the errors of the chains rejected by the finality rules of
+// the chain validator, as opposed to the failures of the validation
+var finalityRejections = []error{
+	whitelist.ErrFinalityMismatch,
+	whitelist.ErrReorgNotAllowed,
+	whitelist.ErrFutureMilestoneMismatch,
+	whitelist.ErrFutureCheckpointMismatch,
+	whitelist.ErrUnrelatedChain,
+	whitelist.ErrMissingAncestors,
+	whitelist.ErrReorgTooDeep,
+	whitelist.ErrLaggingHead,
+}
+
+// isFinalityRejection checks whether the error is the cause of a chain rejected by
+// the finality rules of the chain validator
+func isFinalityRejection(err error) bool {
+	for _, rejection := range finalityRejections {
+		if errors.Is(err, rejection) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateReorg calls the chain validator service to check if the reorg is valid or not.
+// A chain rejected by the finality rules is returned as invalid along with an error
+// wrapping both ErrReorgRejected and the rejection of the validator, so that the
+// callers can tell it apart from a failure of the validation.
 func (f *ForkChoice) ValidateReorg(current *types.Header, chain []*types.Header) (bool, error) {
 	// Call the bor chain validator service
 	if f.validator != nil {
-		return f.validator.IsValidChain(current, chain)
+		isValid, err := f.validator.IsValidChain(current, chain)
+
+		if !isValid && isFinalityRejection(err) {
+			log.Debug("Reorg rejected by the chain validator", "err", err)
+			return false, fmt.Errorf("%w: %w", ErrReorgRejected, err)
+		}
+
+		return isValid, err
 	}
 
 	return true, nil
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"errors"
+	"fmt"
 	"math/big"
 	"testing"
 
@@ -286,6 +288,39 @@ func (c *chainReaderFake) GetTd(hash common.Hash, number uint64) *big.Int {
 	return c.getTd(hash, number)
 }
 
+// TestValidateReorgRejection checks that the chains rejected by the finality rules are
+// invalid reorgs returned along with the wrapped rejection, while the other validation
+// errors are returned as is
+func TestValidateReorgRejection(t *testing.T) {
+	t.Parallel()
+
+	header := &types.Header{Number: big.NewInt(1)}
+
+	for _, rejection := range finalityRejections {
+		err := fmt.Errorf("%w: details", rejection)
+
+		forker := NewForkChoice(nil, nil, newChainValidatorFake(func(*types.Header, []*types.Header) (bool, error) {
+			return false, err
+		}))
+
+		valid, err := forker.ValidateReorg(header, []*types.Header{header})
+		require.ErrorIs(t, err, ErrReorgRejected, rejection)
+		require.ErrorIs(t, err, rejection)
+		require.False(t, valid, rejection)
+	}
+
+	failure := errors.New("validation failure")
+
+	forker := NewForkChoice(nil, nil, newChainValidatorFake(func(*types.Header, []*types.Header) (bool, error) {
+		return false, failure
+	}))
+
+	valid, err := forker.ValidateReorg(header, []*types.Header{header})
+	require.ErrorIs(t, err, failure)
+	require.NotErrorIs(t, err, ErrReorgRejected)
+	require.False(t, valid)
+}
+
 // Mock chain validator functions
 func (w *chainValidatorFake) IsValidPeer(fetchHeadersByNumber func(number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (bool, error) {
 	return true, nil
@@ -294,9 +329,13 @@ func (w *chainValidatorFake) IsValidChain(current *types.Header, headers []*type
 	return w.validate(current, headers)
 }
 func (w *chainValidatorFake) ProcessCheckpoint(endBlockNum uint64, endBlockHash common.Hash) {}
+func (w *chainValidatorFake) ProcessFutureCheckpoint(num uint64, hash common.Hash)           {}
 func (w *chainValidatorFake) ProcessMilestone(endBlockNum uint64, endBlockHash common.Hash)  {}
 func (w *chainValidatorFake) ProcessFutureMilestone(num uint64, hash common.Hash) {
 }
+func (w *chainValidatorFake) GetFutureMilestone(number uint64) (common.Hash, bool) {
+	return common.Hash{}, false
+}
 func (w *chainValidatorFake) GetWhitelistedCheckpoint() (bool, uint64, common.Hash) {
 	return false, 0, common.Hash{}
 }
@@ -314,7 +353,8 @@ func (w *chainValidatorFake) LockMutex(endBlockNum uint64) bool {
 }
 func (w *chainValidatorFake) UnlockMutex(doLock bool, milestoneId string, endBlockNum uint64, endBlockHash common.Hash) {
 }
-func (w *chainValidatorFake) UnlockSprint(endBlockNum uint64) {
+func (w *chainValidatorFake) UnlockSprint(endBlockNum uint64) bool {
+	return false
 }
 func (w *chainValidatorFake) RemoveMilestoneID(milestoneId string) {
 }
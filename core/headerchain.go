@@ -313,7 +313,7 @@ func (hc *HeaderChain) writeHeadersAndSetHead(headers []*types.Header, forker *F
 	}
 
 	isValid, err := forker.ValidateReorg(hc.CurrentHeader(), headers)
-	if err != nil {
+	if err != nil && !errors.Is(err, ErrReorgRejected) {
 		return nil, err
 	} else if !isValid {
 		if inserted != 0 {
@@ -18,6 +18,7 @@ var (
 	ErrIncorrectLockField                   = errors.New("lock field in the DB is incorrect")
 	ErrIncorrectFutureMilestoneFieldToStore = errors.New("failed to marshal the future milestone field struct ")
 	ErrIncorrectFutureMilestoneField        = errors.New("future milestone field  in the DB is incorrect")
+	ErrChecksumMismatch                     = errors.New("checksum of the stored data doesn't match")
 )
 
 type Checkpoint struct {
@@ -2,12 +2,16 @@
 package rawdb
 
 import (
+	"encoding/binary"
 	"fmt"
+	"sort"
+	"time"
 
 	json "github.com/json-iterator/go"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/generics"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/log"
 )
@@ -16,6 +20,7 @@ var (
 	lastMilestone      = []byte("LastMilestone")
 	lockFieldKey       = []byte("LockField")
 	futureMilestoneKey = []byte("FutureMilestoneField")
+	milestoneWALPrefix = []byte("MilestoneWAL-") // milestoneWALPrefix + seq (uint64 big endian) -> intent record
 )
 
 type Finality struct {
@@ -23,16 +28,83 @@ type Finality struct {
 	Hash  common.Hash
 }
 
+// LockField and FutureMilestoneField carry a checksum of their content to detect
+// a silent corruption of the stored data. A zero checksum is treated as missing
+// (i.e. data written before the checksum was introduced) and isn't verified.
 type LockField struct {
-	Val    bool
-	Block  uint64
-	Hash   common.Hash
-	IdList map[string]struct{}
+	Val      bool
+	Block    uint64
+	Hash     common.Hash
+	IdList   map[string]MilestoneID
+	Checksum common.Hash
+}
+
+// MilestoneID is the value of a milestone id of the lock field. AddedAt is the time
+// at which the id was added, zero for the ids stored before it was recorded.
+type MilestoneID struct {
+	AddedAt time.Time
 }
 
 type FutureMilestoneField struct {
-	Order []uint64
-	List  map[uint64]common.Hash
+	Order    []uint64
+	List     map[uint64]common.Hash
+	Checksum common.Hash
+}
+
+// checksum computes the checksum over the canonical (sorted) content of the lock field
+func (l *LockField) checksum() common.Hash {
+	ids := make([]string, 0, len(l.IdList))
+	for id := range l.IdList {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	data := make([][]byte, 0, len(ids)+3)
+
+	if l.Val {
+		data = append(data, []byte{1})
+	} else {
+		data = append(data, []byte{0})
+	}
+
+	data = append(data, binary.BigEndian.AppendUint64(nil, l.Block), l.Hash.Bytes())
+
+	for _, id := range ids {
+		// Length prefix the ids, so that the concatenation is unambiguous
+		data = append(data, binary.BigEndian.AppendUint64(nil, uint64(len(id))), []byte(id))
+
+		// The unknown times are left out, keeping the checksums of the older records valid
+		if addedAt := l.IdList[id].AddedAt; !addedAt.IsZero() {
+			data = append(data, binary.BigEndian.AppendUint64(nil, uint64(addedAt.UnixNano())))
+		}
+	}
+
+	return crypto.Keccak256Hash(data...)
+}
+
+// checksum computes the checksum over the canonical (sorted) content of the future milestone field
+func (f *FutureMilestoneField) checksum() common.Hash {
+	keys := make([]uint64, 0, len(f.List))
+	for key := range f.List {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	data := make([][]byte, 0, len(f.Order)+2*len(keys)+1)
+
+	data = append(data, binary.BigEndian.AppendUint64(nil, uint64(len(f.Order))))
+	for _, number := range f.Order {
+		data = append(data, binary.BigEndian.AppendUint64(nil, number))
+	}
+
+	for _, key := range keys {
+		hash := f.List[key]
+		data = append(data, binary.BigEndian.AppendUint64(nil, key), hash.Bytes())
+	}
+
+	return crypto.Keccak256Hash(data...)
 }
 
 func (f *Finality) set(block uint64, hash common.Hash) {
@@ -97,6 +169,19 @@ func WriteLastFinality[T BlockFinality[T]](db ethdb.KeyValueWriter, block uint64
 	return nil
 }
 
+// DeleteLastFinality removes the stored whitelisted finality
+func DeleteLastFinality[T BlockFinality[T]](db ethdb.KeyValueWriter) error {
+	_, key := getKey[T]()
+
+	if err := db.Delete(key); err != nil {
+		log.Error(fmt.Sprintf("Failed to delete the %s struct", string(key)), "err", err)
+
+		return fmt.Errorf("%w: %v for %s struct", ErrDBNotResponding, err, string(key))
+	}
+
+	return nil
+}
+
 type BlockFinality[T any] interface {
 	set(block uint64, hash common.Hash)
 	clone() T
@@ -118,7 +203,7 @@ func getKey[T BlockFinality[T]]() (T, []byte) {
 	return lastT, key
 }
 
-func WriteLockField(db ethdb.KeyValueWriter, val bool, block uint64, hash common.Hash, idListMap map[string]struct{}) error {
+func WriteLockField(db ethdb.KeyValueWriter, val bool, block uint64, hash common.Hash, idListMap map[string]MilestoneID) error {
 
 	lockField := LockField{
 		Val:    val,
@@ -127,6 +212,8 @@ func WriteLockField(db ethdb.KeyValueWriter, val bool, block uint64, hash common
 		IdList: idListMap,
 	}
 
+	lockField.Checksum = lockField.checksum()
+
 	key := lockFieldKey
 
 	enc, err := json.Marshal(lockField)
@@ -145,7 +232,18 @@ func WriteLockField(db ethdb.KeyValueWriter, val bool, block uint64, hash common
 	return nil
 }
 
-func ReadLockField(db ethdb.KeyValueReader) (bool, uint64, common.Hash, map[string]struct{}, error) {
+// DeleteLockField removes the stored lock field
+func DeleteLockField(db ethdb.KeyValueWriter) error {
+	if err := db.Delete(lockFieldKey); err != nil {
+		log.Error("Failed to delete the lock field struct", "err", err)
+
+		return fmt.Errorf("%w: %v for lock field struct", ErrDBNotResponding, err)
+	}
+
+	return nil
+}
+
+func ReadLockField(db ethdb.KeyValueReader) (bool, uint64, common.Hash, map[string]MilestoneID, error) {
 	key := lockFieldKey
 	lockField := LockField{}
 
@@ -165,6 +263,12 @@ func ReadLockField(db ethdb.KeyValueReader) (bool, uint64, common.Hash, map[stri
 			ErrIncorrectLockField, err, data, string(data))
 	}
 
+	if lockField.Checksum != (common.Hash{}) && lockField.Checksum != lockField.checksum() {
+		log.Error("Checksum mismatch of the lock field in database", "stored", lockField.Checksum, "computed", lockField.checksum())
+
+		return false, 0, common.Hash{}, nil, fmt.Errorf("%w for lock field", ErrChecksumMismatch)
+	}
+
 	val, block, hash, idList := lockField.Val, lockField.Block, lockField.Hash, lockField.IdList
 
 	return val, block, hash, idList, nil
@@ -177,6 +281,8 @@ func WriteFutureMilestoneList(db ethdb.KeyValueWriter, order []uint64, list map[
 		List:  list,
 	}
 
+	futureMilestoneField.Checksum = futureMilestoneField.checksum()
+
 	key := futureMilestoneKey
 
 	enc, err := json.Marshal(futureMilestoneField)
@@ -195,6 +301,17 @@ func WriteFutureMilestoneList(db ethdb.KeyValueWriter, order []uint64, list map[
 	return nil
 }
 
+// DeleteFutureMilestoneList removes the stored future milestone field
+func DeleteFutureMilestoneList(db ethdb.KeyValueWriter) error {
+	if err := db.Delete(futureMilestoneKey); err != nil {
+		log.Error("Failed to delete the future milestone field struct", "err", err)
+
+		return fmt.Errorf("%w: %v for future milestone field struct", ErrDBNotResponding, err)
+	}
+
+	return nil
+}
+
 func ReadFutureMilestoneList(db ethdb.KeyValueReader) ([]uint64, map[uint64]common.Hash, error) {
 	key := futureMilestoneKey
 	futureMilestoneField := FutureMilestoneField{}
@@ -215,7 +332,64 @@ func ReadFutureMilestoneList(db ethdb.KeyValueReader) ([]uint64, map[uint64]comm
 			ErrIncorrectFutureMilestoneField, err, data, string(data))
 	}
 
+	if futureMilestoneField.Checksum != (common.Hash{}) && futureMilestoneField.Checksum != futureMilestoneField.checksum() {
+		log.Error("Checksum mismatch of the future milestone field in database", "stored", futureMilestoneField.Checksum, "computed", futureMilestoneField.checksum())
+
+		return nil, nil, fmt.Errorf("%w for future milestone field", ErrChecksumMismatch)
+	}
+
 	order, list := futureMilestoneField.Order, futureMilestoneField.List
 
 	return order, list, nil
 }
+
+// milestoneWALKey = milestoneWALPrefix + seq (uint64 big endian)
+func milestoneWALKey(seq uint64) []byte {
+	return binary.BigEndian.AppendUint64(append([]byte{}, milestoneWALPrefix...), seq)
+}
+
+// WriteMilestoneWALEntry stores an intent record of the milestone write-ahead log
+func WriteMilestoneWALEntry(db ethdb.KeyValueWriter, seq uint64, data []byte) error {
+	if err := db.Put(milestoneWALKey(seq), data); err != nil {
+		log.Error("Failed to store the milestone WAL entry", "seq", seq, "err", err)
+
+		return fmt.Errorf("%w: %v for milestone WAL entry %d", ErrDBNotResponding, err, seq)
+	}
+
+	return nil
+}
+
+// DeleteMilestoneWALEntry removes an applied intent record of the milestone write-ahead log
+func DeleteMilestoneWALEntry(db ethdb.KeyValueWriter, seq uint64) error {
+	if err := db.Delete(milestoneWALKey(seq)); err != nil {
+		log.Error("Failed to delete the milestone WAL entry", "seq", seq, "err", err)
+
+		return fmt.Errorf("%w: %v for milestone WAL entry %d", ErrDBNotResponding, err, seq)
+	}
+
+	return nil
+}
+
+// ReadMilestoneWALEntries returns the intent records of the milestone write-ahead log
+// along with their sequence numbers, in ascending sequence order
+func ReadMilestoneWALEntries(db ethdb.Iteratee) ([]uint64, [][]byte, error) {
+	it := db.NewIterator(milestoneWALPrefix, nil)
+	defer it.Release()
+
+	var (
+		seqs    []uint64
+		entries [][]byte
+	)
+
+	for it.Next() {
+		key := it.Key()
+		if len(key) != len(milestoneWALPrefix)+8 {
+			continue
+		}
+
+		seqs = append(seqs, binary.BigEndian.Uint64(key[len(milestoneWALPrefix):]))
+		entries = append(entries, common.CopyBytes(it.Value()))
+	}
+
+	return seqs, entries, it.Error()
+}
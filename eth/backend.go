@@ -231,7 +231,22 @@ func New(stack *node.Node, config *ethconfig.Config) (*Ethereum, error) {
 		}
 	)
 
-	checker := whitelist.NewService(chainDb)
+	checkerOpts := []whitelist.ServiceOption{whitelist.WithFetchRetries(whitelist.DefaultFetchRetries, whitelist.DefaultFetchBackoff)}
+	if config.FutureMilestoneEvictLowest {
+		checkerOpts = append(checkerOpts, whitelist.WithEvictLowestOnFull())
+	}
+
+	checker := whitelist.NewService(chainDb, checkerOpts...)
+
+	if config.FutureMilestoneMaxCapacity != 0 {
+		if err := checker.SetFutureMilestoneCapacity(config.FutureMilestoneMaxCapacity); err != nil {
+			return nil, err
+		}
+
+		if err := checker.SetFutureCheckpointCapacity(config.FutureMilestoneMaxCapacity); err != nil {
+			return nil, err
+		}
+	}
 
 	// check if Parallel EVM is enabled
 	// if enabled, use parallel state processor
@@ -383,6 +398,9 @@ func (s *Ethereum) APIs() []rpc.API {
 		}, {
 			Namespace: "net",
 			Service:   s.netRPCService,
+		}, {
+			Namespace: "bor",
+			Service:   NewBorWhitelistAPI(s),
 		},
 	}...)
 }
@@ -729,6 +747,16 @@ func (s *Ethereum) handleWhitelistCheckpoint(ctx context.Context, ethHandler *et
 	verifier := newBorVerifier()
 
 	blockNum, blockHash, err := ethHandler.fetchWhitelistCheckpoint(ctx, bor, s, verifier)
+
+	// If the current chain head is behind the received checkpoint, add it to the future
+	// checkpoint list. The checkpoints only carry the root hash of their blocks, hence
+	// the hash of the end block is taken from the future milestone pinning it, if any,
+	// and left unknown otherwise until a later round provides it.
+	if errors.Is(err, errMissingBlocks) {
+		hash, _ := ethHandler.downloader.GetFutureMilestone(blockNum)
+		ethHandler.downloader.ProcessFutureCheckpoint(blockNum, hash)
+	}
+
 	// If the array is empty, we're bound to receive an error. Non-nill error and non-empty array
 	// means that array has partial elements and it failed for some block. We'll add those partial
 	// elements anyway.
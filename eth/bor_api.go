@@ -0,0 +1,45 @@
+package eth
+
+import (
+	"errors"
+
+	"github.com/ethereum/go-ethereum/eth/downloader/whitelist"
+)
+
+var errWhitelistServiceNotAvailable = errors.New("whitelist service not available")
+
+// BorWhitelistAPI provides an API to inspect the whitelisted milestone and checkpoint
+// state of the node.
+type BorWhitelistAPI struct {
+	e *Ethereum
+}
+
+// NewBorWhitelistAPI creates a new BorWhitelistAPI instance.
+func NewBorWhitelistAPI(e *Ethereum) *BorWhitelistAPI {
+	return &BorWhitelistAPI{e}
+}
+
+// GetWhitelistStatus returns the in-memory whitelisted milestone, lock, future
+// milestones and checkpoint state, to compare the node's finality view with heimdall.
+func (api *BorWhitelistAPI) GetWhitelistStatus() (*whitelist.WhitelistStatus, error) {
+	service, ok := api.e.Downloader().ChainValidator.(*whitelist.Service)
+	if !ok {
+		return nil, errWhitelistServiceNotAvailable
+	}
+
+	status := service.Status()
+
+	return &status, nil
+}
+
+// PurgeMilestones wipes the whitelisted milestone, the lock and the future milestones,
+// in memory and in the db, to recover from a bad milestone state without deleting the
+// chain db.
+func (api *DebugAPI) PurgeMilestones() error {
+	service, ok := api.eth.Downloader().ChainValidator.(*whitelist.Service)
+	if !ok {
+		return errWhitelistServiceNotAvailable
+	}
+
+	return service.PurgeAll()
+}
@@ -1911,7 +1911,8 @@ func (w *whitelistFake) IsValidPeer(_ func(number uint64, amount int, skip int,
 func (w *whitelistFake) IsValidChain(current *types.Header, headers []*types.Header) (bool, error) {
 	return true, nil
 }
-func (w *whitelistFake) ProcessCheckpoint(_ uint64, _ common.Hash) {}
+func (w *whitelistFake) ProcessCheckpoint(_ uint64, _ common.Hash)       {}
+func (w *whitelistFake) ProcessFutureCheckpoint(_ uint64, _ common.Hash) {}
 
 func (w *whitelistFake) GetWhitelistedCheckpoint() (bool, uint64, common.Hash) {
 	return false, 0, common.Hash{}
@@ -1920,6 +1921,9 @@ func (w *whitelistFake) PurgeWhitelistedCheckpoint() {}
 
 func (w *whitelistFake) ProcessMilestone(_ uint64, _ common.Hash)       {}
 func (w *whitelistFake) ProcessFutureMilestone(_ uint64, _ common.Hash) {}
+func (w *whitelistFake) GetFutureMilestone(_ uint64) (common.Hash, bool) {
+	return common.Hash{}, false
+}
 func (w *whitelistFake) GetWhitelistedMilestone() (bool, uint64, common.Hash) {
 	return false, 0, common.Hash{}
 }
@@ -1933,7 +1937,8 @@ func (w *whitelistFake) LockMutex(endBlockNum uint64) bool {
 }
 func (w *whitelistFake) UnlockMutex(doLock bool, milestoneId string, endBlockNum uint64, endBlockHash common.Hash) {
 }
-func (w *whitelistFake) UnlockSprint(endBlockNum uint64) {
+func (w *whitelistFake) UnlockSprint(endBlockNum uint64) bool {
+	return false
 }
 func (w *whitelistFake) RemoveMilestoneID(milestoneId string) {
 }
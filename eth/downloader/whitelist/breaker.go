@@ -0,0 +1,113 @@
+package whitelist
+
+import (
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+var (
+	// Metrics for collecting the number of times the persistence breaker tripped
+	PersistenceBreakerTripCounter = metrics.NewRegisteredCounter("chain/milestone/db/breaker", nil)
+
+	// Metrics for collecting the number of failed writes of the milestone state
+	MilestoneDBWriteErrorCounter = metrics.NewRegisteredCounter("chain/milestone/db/writeerrors", nil)
+)
+
+// defaultUnhealthyFailures is the number of consecutive persistence failures after
+// which Healthy reports the persistence as broken, if PersistenceFailureThreshold
+// isn't set
+const defaultUnhealthyFailures = 3
+
+// persistenceBreaker tracks the consecutive failures of the milestone persistence.
+// Once tripped, the mutating methods are refused so that the in-memory state can't
+// diverge any further from the last persisted one.
+type persistenceBreaker struct {
+	failures int // Number of consecutive failed writes
+	tripped  bool
+}
+
+// recordPersistence records the outcome of a write, tripping the breaker once the
+// consecutive failures reach PersistenceFailureThreshold.
+// It should be called with the finality lock held.
+func (m *milestone) recordPersistence(err error) {
+	if err == nil {
+		m.breaker.failures = 0
+		return
+	}
+
+	m.breaker.failures++
+
+	MilestoneDBWriteErrorCounter.Inc(1)
+
+	if m.PersistenceFailureThreshold > 0 && m.breaker.failures >= m.PersistenceFailureThreshold && !m.breaker.tripped {
+		m.logger().Error("Tripping the milestone persistence breaker", "failures", m.breaker.failures, "err", err)
+
+		m.breaker.tripped = true
+		PersistenceBreakerTripCounter.Inc(1)
+	}
+}
+
+// checkPersistence returns ErrPersistenceDegraded if the breaker is tripped.
+// It should be called with the finality lock held.
+func (m *milestone) checkPersistence() error {
+	if m.breaker.tripped {
+		return ErrPersistenceDegraded
+	}
+
+	return nil
+}
+
+// IsPersistenceDegraded reports whether the persistence breaker is tripped
+func (m *milestone) IsPersistenceDegraded() bool {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	return m.breaker.tripped
+}
+
+// Healthy reports whether the milestone persistence works, i.e. the breaker isn't
+// tripped and the last writes didn't fail PersistenceFailureThreshold times in a row
+// (defaultUnhealthyFailures if unset). A successful write makes it healthy again,
+// unless the breaker tripped. It's meant for the health checks.
+func (m *milestone) Healthy() bool {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	threshold := m.PersistenceFailureThreshold
+	if threshold <= 0 {
+		threshold = defaultUnhealthyFailures
+	}
+
+	return !m.breaker.tripped && m.breaker.failures < threshold
+}
+
+// ResetPersistenceBreaker closes the breaker once the db has recovered, allowing
+// the mutating methods again
+func (m *milestone) ResetPersistenceBreaker() {
+	m.finality.Lock()
+	defer m.unlock()
+
+	if m.breaker.tripped {
+		m.logger().Info("Resetting the milestone persistence breaker")
+	}
+
+	m.breaker = persistenceBreaker{}
+}
+
+// writeLockField persists the lock state. It should be called with the finality lock held.
+func (m *milestone) writeLockField() {
+	record := stateRecord{Kind: stateRecordLock, Locked: m.Locked, Number: m.LockedMilestoneNumber, Hash: m.LockedMilestoneHash, IDs: m.LockedMilestoneIDs}
+
+	if err := m.persist(record); err != nil {
+		m.logger().Error("Error in writing lock data of milestone to db", "err", err)
+	}
+}
+
+// writeFutureMilestoneList persists the future milestones. It should be called with
+// the finality lock held.
+func (m *milestone) writeFutureMilestoneList() {
+	record := stateRecord{Kind: stateRecordFuture, Order: m.FutureMilestoneOrder, List: m.FutureMilestoneList}
+
+	if err := m.persist(record); err != nil {
+		m.logger().Error("Error in writing future milestone data to db", "err", err)
+	}
+}
@@ -0,0 +1,132 @@
+package whitelist
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// failingDB is a database whose writes fail while fail is set
+type failingDB struct {
+	ethdb.Database
+	fail atomic.Bool
+}
+
+func (db *failingDB) Put(key []byte, value []byte) error {
+	if db.fail.Load() {
+		return errors.New("disk failure")
+	}
+
+	return db.Database.Put(key, value)
+}
+
+// TestPersistenceBreaker checks the tripping and the reset of the persistence breaker
+func TestPersistenceBreaker(t *testing.T) {
+	t.Parallel()
+
+	db := &failingDB{Database: rawdb.NewMemoryDatabase()}
+	s, milestone := newMockMilestone(db)
+	milestone.PersistenceFailureThreshold = 3
+
+	s.ProcessMilestone(10, common.Hash{0x1})
+	s.ProcessFutureMilestone(30, common.Hash{0x3})
+
+	// Non consecutive failures don't trip the breaker
+	db.fail.Store(true)
+	s.RemoveMilestoneID("milestoneID1")
+	s.RemoveMilestoneID("milestoneID1")
+	db.fail.Store(false)
+	s.RemoveMilestoneID("milestoneID1")
+	require.False(t, s.IsPersistenceDegraded())
+
+	db.fail.Store(true)
+	s.RemoveMilestoneID("milestoneID1")
+	s.RemoveMilestoneID("milestoneID1")
+	s.RemoveMilestoneID("milestoneID1")
+	require.True(t, s.IsPersistenceDegraded())
+
+	// Mutations are refused while the reads keep serving the last good state
+	s.ProcessMilestone(20, common.Hash{0x2})
+	require.ErrorIs(t, s.PromoteFutureMilestone(30), ErrPersistenceDegraded)
+	require.False(t, s.CompareAndSetMilestone(10, 20, common.Hash{0x2}))
+	require.False(t, s.LockMutex(20))
+	s.UnlockMutex(true, "milestoneID2", 20, common.Hash{0x2})
+	require.Nil(t, s.DrainFutureMilestones())
+
+	doExist, number, hash := s.milestoneService.Get()
+	require.True(t, doExist)
+	require.Equal(t, uint64(10), number)
+	require.Equal(t, common.Hash{0x1}, hash)
+	require.False(t, milestone.Locked)
+	require.Equal(t, []uint64{30}, milestone.FutureMilestoneOrder)
+
+	// Resetting the breaker once the db recovered allows the mutations again
+	db.fail.Store(false)
+	s.ResetPersistenceBreaker()
+	require.False(t, s.IsPersistenceDegraded())
+
+	s.ProcessMilestone(20, common.Hash{0x2})
+
+	_, number, hash = s.milestoneService.Get()
+	require.Equal(t, uint64(20), number)
+	require.Equal(t, common.Hash{0x2}, hash)
+
+	number, hash, err := rawdb.ReadFinality[*rawdb.Milestone](db)
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), number)
+	require.Equal(t, common.Hash{0x2}, hash)
+}
+
+// TestMilestoneHealthy checks that repeated write failures are reported by Healthy
+// and counted, and that a successful write makes the persistence healthy again
+func TestMilestoneHealthy(t *testing.T) {
+	counter := MilestoneDBWriteErrorCounter
+	MilestoneDBWriteErrorCounter = metrics.NewCounterForced()
+
+	defer func() {
+		MilestoneDBWriteErrorCounter = counter
+	}()
+
+	db := &failingDB{Database: rawdb.NewMemoryDatabase()}
+	s, milestone := newMockMilestone(db)
+
+	s.ProcessMilestone(10, common.Hash{0x1})
+	require.True(t, s.Healthy())
+
+	db.fail.Store(true)
+	for i := 0; i < defaultUnhealthyFailures-1; i++ {
+		s.RemoveMilestoneID("milestoneID1")
+	}
+	require.True(t, s.Healthy())
+
+	s.RemoveMilestoneID("milestoneID1")
+	require.False(t, s.Healthy())
+	require.Equal(t, int64(defaultUnhealthyFailures), MilestoneDBWriteErrorCounter.Snapshot().Count())
+
+	// Without a breaker threshold, the next successful write recovers
+	require.False(t, s.IsPersistenceDegraded())
+	db.fail.Store(false)
+	s.RemoveMilestoneID("milestoneID1")
+	require.True(t, s.Healthy())
+
+	// Once the breaker trips, it stays unhealthy until it's reset
+	milestone.PersistenceFailureThreshold = 2
+
+	db.fail.Store(true)
+	s.RemoveMilestoneID("milestoneID1")
+	s.RemoveMilestoneID("milestoneID1")
+	require.False(t, s.Healthy())
+	require.True(t, s.IsPersistenceDegraded())
+	require.Equal(t, int64(defaultUnhealthyFailures+2), MilestoneDBWriteErrorCounter.Snapshot().Count())
+
+	db.fail.Store(false)
+	s.ResetPersistenceBreaker()
+	require.True(t, s.Healthy())
+}
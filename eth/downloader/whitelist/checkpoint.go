@@ -1,18 +1,32 @@
 package whitelist
 
 import (
+	"context"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 )
 
 type checkpoint struct {
 	finality[*rawdb.Checkpoint]
+
+	// Future checkpoints buffered during catch-up, kept in memory only. They follow
+	// the capacity and eviction mechanics of the future milestones.
+	FutureCheckpointList  map[uint64]common.Hash
+	FutureCheckpointOrder []uint64 // Sorted ascending
+	MaxCapacity           int
+	EvictLowestOnFull     bool
 }
 
 type checkpointService interface {
 	finalityService
+
+	ProcessFutureCheckpoint(num uint64, hash common.Hash)
+	SetFutureCheckpointCapacity(capacity int) error
+	IsFutureCheckpointCompatible(chain []*types.Header) bool
 }
 
 var (
@@ -34,6 +48,10 @@ func (w *checkpoint) IsValidChain(currentHeader *types.Header, chain []*types.He
 
 	res, err := w.finality.IsValidChain(currentHeader, chain)
 
+	if res && !w.isFutureCheckpointCompatible(chain) {
+		res, err = false, ErrFutureCheckpointMismatch
+	}
+
 	if res {
 		CheckpointChainMeter.Mark(int64(1))
 	} else {
@@ -46,7 +64,13 @@ func (w *checkpoint) IsValidChain(currentHeader *types.Header, chain []*types.He
 // IsValidPeer checks if the chain we're about to receive from a peer is valid or not
 // in terms of reorgs. We won't reorg beyond the last bor finality submitted to mainchain.
 func (w *checkpoint) IsValidPeer(fetchHeadersByNumber func(number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (bool, error) {
-	res, err := w.finality.IsValidPeer(fetchHeadersByNumber)
+	return w.IsValidPeerCtx(context.Background(), withoutContext(fetchHeadersByNumber))
+}
+
+// IsValidPeerCtx is IsValidPeer with a fetch of the peer's header honouring the
+// context, the check returning the context error once it's cancelled
+func (w *checkpoint) IsValidPeerCtx(ctx context.Context, fetchHeadersByNumber func(ctx context.Context, number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (bool, error) {
+	res, err := w.finality.IsValidPeerCtx(ctx, fetchHeadersByNumber)
 
 	if res {
 		CheckpointPeerMeter.Mark(int64(1))
@@ -63,5 +87,112 @@ func (w *checkpoint) Process(block uint64, hash common.Hash) {
 
 	w.finality.Process(block, hash)
 
+	// The future checkpoints reached by the whitelisted one aren't needed anymore
+	for len(w.FutureCheckpointOrder) > 0 && w.FutureCheckpointOrder[0] <= block {
+		w.dequeueFutureCheckpoint()
+	}
+
 	whitelistedCheckpointNumberMeter.Update(int64(block))
 }
+
+// ProcessFutureCheckpoint buffers the checkpoint ending beyond the current chain,
+// like ProcessFutureMilestone does for the milestones. The checkpoints only carry the
+// root hash of their blocks, hence the hash of the end block may be unknown, i.e.
+// empty, until a later call provides it.
+func (w *checkpoint) ProcessFutureCheckpoint(num uint64, hash common.Hash) {
+	w.finality.Lock()
+	defer w.finality.Unlock()
+
+	if w.FutureCheckpointList == nil {
+		w.FutureCheckpointList = make(map[uint64]common.Hash)
+	}
+
+	q := futureQueue{
+		kind:        "checkpoint",
+		capacity:    w.MaxCapacity,
+		evictLowest: w.EvictLowestOnFull,
+		logger:      log.Root(),
+		order:       func() []uint64 { return w.FutureCheckpointOrder },
+		enqueue:     w.enqueueFutureCheckpoint,
+		dequeue:     w.dequeueFutureCheckpoint,
+	}
+
+	q.queue(num, hash)
+}
+
+// SetFutureCheckpointCapacity sets the capacity of the future checkpoint list, like
+// SetFutureMilestoneCapacity. Lowering it evicts the lowest future checkpoints
+// beyond it.
+func (w *checkpoint) SetFutureCheckpointCapacity(capacity int) error {
+	if capacity < 1 {
+		return ErrInvalidFutureMilestoneCapacity
+	}
+
+	w.finality.Lock()
+	defer w.finality.Unlock()
+
+	w.MaxCapacity = capacity
+
+	for len(w.FutureCheckpointOrder) > w.MaxCapacity {
+		w.dequeueFutureCheckpoint()
+	}
+
+	return nil
+}
+
+// enqueueFutureCheckpoint adds the future checkpoint to the list, keeping the order
+// sorted ascending, and reports whether the list changed. The unknown hash of a queued
+// checkpoint is filled in. It should be called with the finality lock held.
+func (w *checkpoint) enqueueFutureCheckpoint(num uint64, hash common.Hash) bool {
+	if known, ok := w.FutureCheckpointList[num]; ok {
+		if known != (common.Hash{}) || hash == (common.Hash{}) {
+			log.Debug("Future checkpoint already exist", "endBlockNumber", num, "futureCheckpointHash", hash)
+			return false
+		}
+
+		w.FutureCheckpointList[num] = hash
+
+		return true
+	}
+
+	w.FutureCheckpointList[num] = hash
+	w.FutureCheckpointOrder = insertSorted(w.FutureCheckpointOrder, num)
+
+	return true
+}
+
+// dequeueFutureCheckpoint removes the lowest future checkpoint.
+// It should be called with the finality lock held.
+func (w *checkpoint) dequeueFutureCheckpoint() {
+	delete(w.FutureCheckpointList, w.FutureCheckpointOrder[0])
+	w.FutureCheckpointOrder = w.FutureCheckpointOrder[1:]
+}
+
+// IsFutureCheckpointCompatible checks the chain against the highest future checkpoint
+// it reaches, like IsFutureMilestoneCompatible
+func (w *checkpoint) IsFutureCheckpointCompatible(chain []*types.Header) bool {
+	w.finality.RLock()
+	defer w.finality.RUnlock()
+
+	return w.isFutureCheckpointCompatible(chain)
+}
+
+// isFutureCheckpointCompatible is IsFutureCheckpointCompatible without the locking.
+// It should be called with the finality lock held.
+func (w *checkpoint) isFutureCheckpointCompatible(chain []*types.Header) bool {
+	if len(chain) == 0 {
+		return true
+	}
+
+	// The checkpoints with an unknown end block hash can't be checked yet
+	order := make([]uint64, 0, len(w.FutureCheckpointOrder))
+	for _, num := range w.FutureCheckpointOrder {
+		if w.FutureCheckpointList[num] != (common.Hash{}) {
+			order = append(order, num)
+		}
+	}
+
+	compatible, _ := futureCompatibility(chain, order, w.FutureCheckpointList, false)
+
+	return compatible
+}
@@ -0,0 +1,137 @@
+package whitelist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// TestProcessFutureCheckpoint checks the buffering of the future checkpoints
+func TestProcessFutureCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	s := NewMockService(rawdb.NewMemoryDatabase())
+
+	checkpoint := s.checkpointService.(*checkpoint)
+	checkpoint.MaxCapacity = 3
+
+	s.ProcessFutureCheckpoint(512, common.Hash{0x2})
+	s.ProcessFutureCheckpoint(256, common.Hash{0x1})
+	s.ProcessFutureCheckpoint(768, common.Hash{0x3})
+	s.ProcessFutureCheckpoint(256, common.Hash{0x1})
+
+	require.Equal(t, []uint64{256, 512, 768}, checkpoint.FutureCheckpointOrder)
+	require.Equal(t, common.Hash{0x2}, checkpoint.FutureCheckpointList[512])
+
+	// Dropped when full
+	s.ProcessFutureCheckpoint(1024, common.Hash{0x4})
+	require.Equal(t, []uint64{256, 512, 768}, checkpoint.FutureCheckpointOrder)
+
+	// Unless the lowest one is evicted for a higher one
+	checkpoint.EvictLowestOnFull = true
+
+	s.ProcessFutureCheckpoint(1024, common.Hash{0x4})
+	require.Equal(t, []uint64{512, 768, 1024}, checkpoint.FutureCheckpointOrder)
+	require.Len(t, checkpoint.FutureCheckpointList, 3)
+
+	// The lowest ones are evicted beyond a lowered capacity
+	require.ErrorIs(t, s.SetFutureCheckpointCapacity(0), ErrInvalidFutureMilestoneCapacity)
+	require.NoError(t, s.SetFutureCheckpointCapacity(1))
+
+	checkpoint.EvictLowestOnFull = false
+
+	s.ProcessFutureCheckpoint(1024, common.Hash{0x4})
+	require.Equal(t, []uint64{1024}, checkpoint.FutureCheckpointOrder)
+
+	// The whitelisted checkpoint dequeues the future ones it reaches
+	checkpoint.MaxCapacity = 3
+
+	s.ProcessFutureCheckpoint(1280, common.Hash{0x5})
+	s.ProcessCheckpoint(1024, common.Hash{0x4})
+
+	require.Equal(t, []uint64{1280}, checkpoint.FutureCheckpointOrder)
+	require.Equal(t, map[uint64]common.Hash{1280: {0x5}}, checkpoint.FutureCheckpointList)
+}
+
+// TestIsFutureCheckpointCompatible checks the chains against the future checkpoints
+func TestIsFutureCheckpointCompatible(t *testing.T) {
+	t.Parallel()
+
+	s := NewMockService(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 40)
+	chainB := createMockChain(1, 40)
+
+	require.True(t, s.IsFutureCheckpointCompatible(chainB))
+	require.True(t, s.IsFutureCheckpointCompatible(nil))
+
+	s.ProcessFutureCheckpoint(20, chainA[19].Hash())
+	s.ProcessFutureCheckpoint(30, chainA[29].Hash())
+
+	require.True(t, s.IsFutureCheckpointCompatible(chainA))
+	require.False(t, s.IsFutureCheckpointCompatible(chainB))
+
+	// Checked against the highest future checkpoint the chain reaches
+	require.True(t, s.IsFutureCheckpointCompatible(chainA[:25]))
+	require.False(t, s.IsFutureCheckpointCompatible(chainB[:25]))
+
+	// Chains below the future checkpoints aren't affected
+	require.True(t, s.IsFutureCheckpointCompatible(chainB[:15]))
+
+	// Through the chain validation
+	res, err := s.IsValidChain(chainA[9], chainA[10:])
+	require.NoError(t, err)
+	require.True(t, res)
+
+	res, err = s.IsValidChain(chainB[9], chainB[10:])
+	require.ErrorIs(t, err, ErrFutureCheckpointMismatch)
+	require.False(t, res)
+}
+
+// TestFutureCheckpointUnknownHash checks the buffering of the future checkpoints whose
+// end block hash isn't known yet
+func TestFutureCheckpointUnknownHash(t *testing.T) {
+	t.Parallel()
+
+	s := NewMockService(rawdb.NewMemoryDatabase())
+	checkpoint := s.checkpointService.(*checkpoint)
+
+	chainA := createMockChain(1, 40)
+	chainB := createMockChain(1, 40)
+
+	s.ProcessFutureCheckpoint(20, common.Hash{})
+	require.Equal(t, []uint64{20}, checkpoint.FutureCheckpointOrder)
+
+	// Not checked until the hash is known
+	require.True(t, s.IsFutureCheckpointCompatible(chainA))
+	require.True(t, s.IsFutureCheckpointCompatible(chainB))
+
+	s.ProcessFutureCheckpoint(20, chainA[19].Hash())
+	require.Equal(t, []uint64{20}, checkpoint.FutureCheckpointOrder)
+
+	require.True(t, s.IsFutureCheckpointCompatible(chainA))
+	require.False(t, s.IsFutureCheckpointCompatible(chainB))
+
+	// A known hash isn't overwritten
+	s.ProcessFutureCheckpoint(20, common.Hash{})
+	s.ProcessFutureCheckpoint(20, chainB[19].Hash())
+	require.Equal(t, chainA[19].Hash(), checkpoint.FutureCheckpointList[20])
+}
+
+// TestFutureCheckpointEvictLowestOption checks the eviction policy of the future
+// checkpoints set through the service options
+func TestFutureCheckpointEvictLowestOption(t *testing.T) {
+	t.Parallel()
+
+	s := NewService(rawdb.NewMemoryDatabase(), WithEvictLowestOnFull())
+	require.NoError(t, s.SetFutureCheckpointCapacity(2))
+
+	s.ProcessFutureCheckpoint(256, common.Hash{0x1})
+	s.ProcessFutureCheckpoint(512, common.Hash{0x2})
+	s.ProcessFutureCheckpoint(768, common.Hash{0x3})
+
+	require.Equal(t, []uint64{512, 768}, s.checkpointService.(*checkpoint).FutureCheckpointOrder)
+}
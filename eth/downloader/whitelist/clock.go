@@ -0,0 +1,24 @@
+package whitelist
+
+import "time"
+
+// Clock is the source of the current time of the milestone service
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the Clock reading the system time
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// timeNow returns the current time of the service's clock
+func (m *milestone) timeNow() time.Time {
+	if m.Clock != nil {
+		return m.Clock.Now()
+	}
+
+	return realClock{}.Now()
+}
@@ -0,0 +1,82 @@
+package whitelist
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// fakeClock is a Clock advanced manually by the tests
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
+
+// TestClock checks that the time based methods read the injected clock
+func TestClock(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	genesis := time.Unix(1_700_000_000, 0)
+	clock := newFakeClock(genesis)
+
+	milestone.Clock = clock
+
+	s.ProcessMilestone(16, common.Hash{16})
+
+	require.Equal(t, genesis, milestone.lastProcessedAt)
+
+	records := milestone.history.list()
+	require.Len(t, records, 1)
+	require.Equal(t, genesis, records[0].Timestamp)
+
+	clock.Advance(time.Minute)
+
+	_, _, age, ok := s.LatestMilestoneWithAge(clock.Now())
+	require.True(t, ok)
+	require.Equal(t, time.Minute, age)
+	require.Equal(t, time.Minute, s.CurrentMilestoneStaleness(clock.Now()))
+
+	// The lock lifecycle reads the clock as well
+	require.True(t, s.LockMilestone(32, common.Hash{32}, "milestoneID1"))
+
+	clock.Advance(time.Second)
+	s.ProcessMilestone(32, common.Hash{32})
+
+	lifecycle, ok := s.LastLockLifecycle()
+	require.True(t, ok)
+	require.Equal(t, genesis.Add(time.Minute), lifecycle.EngagedAt)
+	require.Equal(t, genesis.Add(time.Minute+time.Second), lifecycle.UnlockedAt)
+	require.Equal(t, genesis.Add(time.Minute+time.Second), milestone.lastProcessedAt)
+}
@@ -0,0 +1,61 @@
+package whitelist
+
+import "time"
+
+const (
+	// defaultConfidenceMaxDistance is the distance of the head above the whitelisted
+	// milestone at which the canonical confidence drops to zero
+	defaultConfidenceMaxDistance = 256
+
+	// defaultConfidenceMaxAge is the age of the whitelisted milestone at which the
+	// canonical confidence drops to zero
+	defaultConfidenceMaxAge = 5 * time.Minute
+)
+
+// CanonicalConfidence returns a score between 0 and 1 of the confidence that the node
+// is on the canonical chain, combining the distance of the head to the whitelisted
+// milestone and the freshness of the milestone:
+//
+//	distance  = |head - milestone|
+//	age       = now - time at which a milestone was last processed
+//	score     = max(0, 1 - distance/ConfidenceMaxDistance) * max(0, 1 - age/ConfidenceMaxAge)
+//
+// A head behind the milestone is lagging, so it's penalized like one ahead of it. The
+// score is zero without a whitelisted milestone, or if no milestone was processed since
+// the start (e.g. it was loaded from the db), as its freshness is unknown.
+func (m *milestone) CanonicalConfidence(head uint64, now time.Time) float64 {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	if !m.doExist || m.lastProcessedAt.IsZero() {
+		return 0
+	}
+
+	maxDistance, maxAge := m.ConfidenceMaxDistance, m.ConfidenceMaxAge
+	if maxDistance == 0 {
+		maxDistance = defaultConfidenceMaxDistance
+	}
+
+	if maxAge == 0 {
+		maxAge = defaultConfidenceMaxAge
+	}
+
+	distance := head - m.Number
+	if head < m.Number {
+		distance = m.Number - head
+	}
+
+	var age time.Duration
+	if now.After(m.lastProcessedAt) {
+		age = now.Sub(m.lastProcessedAt)
+	}
+
+	distanceScore := 1 - float64(distance)/float64(maxDistance)
+	ageScore := 1 - float64(age)/float64(maxAge)
+
+	if distanceScore <= 0 || ageScore <= 0 {
+		return 0
+	}
+
+	return distanceScore * ageScore
+}
@@ -0,0 +1,59 @@
+package whitelist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// TestCanonicalConfidence checks the canonical confidence score across the head distance and milestone freshness
+func TestCanonicalConfidence(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+
+	// No confidence without a milestone
+	require.Zero(t, s.CanonicalConfidence(100, time.Now()))
+
+	s.ProcessMilestone(1000, common.Hash{1})
+
+	processedAt := milestone.lastProcessedAt
+
+	// Fresh milestone at the head
+	require.InDelta(t, 1, s.CanonicalConfidence(1000, processedAt), 1e-9)
+
+	// Head ahead of the milestone, and a stale milestone, lower the score
+	require.InDelta(t, 0.5, s.CanonicalConfidence(1128, processedAt), 1e-9)
+	require.InDelta(t, 0.5, s.CanonicalConfidence(1000, processedAt.Add(150*time.Second)), 1e-9)
+	require.InDelta(t, 0.25, s.CanonicalConfidence(1128, processedAt.Add(150*time.Second)), 1e-9)
+
+	// The score decreases with the distance and the age
+	require.Greater(t, s.CanonicalConfidence(1010, processedAt), s.CanonicalConfidence(1100, processedAt))
+	require.Greater(t, s.CanonicalConfidence(1010, processedAt.Add(time.Second)), s.CanonicalConfidence(1010, processedAt.Add(time.Minute)))
+
+	// A head behind the milestone is penalized like one ahead of it
+	require.InDelta(t, 0.5, s.CanonicalConfidence(872, processedAt), 1e-9)
+
+	// Too far or too stale
+	require.Zero(t, s.CanonicalConfidence(1256, processedAt))
+	require.Zero(t, s.CanonicalConfidence(500, processedAt))
+	require.Zero(t, s.CanonicalConfidence(1000, processedAt.Add(5*time.Minute)))
+
+	// A time before the processing counts as fresh
+	require.InDelta(t, 1, s.CanonicalConfidence(1000, processedAt.Add(-time.Minute)), 1e-9)
+
+	// Configurable parameters
+	milestone.ConfidenceMaxDistance = 1000
+	milestone.ConfidenceMaxAge = time.Hour
+
+	require.InDelta(t, 0.75, s.CanonicalConfidence(1250, processedAt), 1e-9)
+	require.InDelta(t, 0.5, s.CanonicalConfidence(1000, processedAt.Add(30*time.Minute)), 1e-9)
+
+	// Unknown freshness of a milestone loaded from the db
+	require.Zero(t, NewMockService(db).CanonicalConfidence(1000, time.Now()))
+}
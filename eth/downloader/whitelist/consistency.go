@@ -0,0 +1,88 @@
+package whitelist
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// CheckPersistenceConsistency compares the persisted lock field and future milestone
+// list against the in-memory state, describing any drift without modifying anything.
+// A missing record is considered empty, while an undecodable or corrupted one is
+// returned as an error. Through the write-ahead log, the records not applied yet are
+// reported as a drift.
+func (m *milestone) CheckPersistenceConsistency() (consistent bool, details string, err error) {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	locked, lockedNumber, lockedHash, lockedIDs, err := rawdb.ReadLockField(m.db)
+	if err != nil {
+		if isCorrupted(err) {
+			return false, "", err
+		}
+
+		locked, lockedNumber, lockedHash, lockedIDs = false, 0, common.Hash{}, nil
+	}
+
+	order, list, err := rawdb.ReadFutureMilestoneList(m.db)
+	if err != nil {
+		if isCorrupted(err) {
+			return false, "", err
+		}
+
+		order, list = nil, nil
+	}
+
+	var drifts []string
+
+	if locked != m.Locked {
+		drifts = append(drifts, fmt.Sprintf("locked: persisted %v, in-memory %v", locked, m.Locked))
+	}
+
+	if lockedNumber != m.LockedMilestoneNumber {
+		drifts = append(drifts, fmt.Sprintf("locked number: persisted %d, in-memory %d", lockedNumber, m.LockedMilestoneNumber))
+	}
+
+	if lockedHash != m.LockedMilestoneHash {
+		drifts = append(drifts, fmt.Sprintf("locked hash: persisted %s, in-memory %s", lockedHash, m.LockedMilestoneHash))
+	}
+
+	if persisted, inMemory := sortedIDs(lockedIDs), sortedIDs(m.LockedMilestoneIDs); !reflect.DeepEqual(persisted, inMemory) {
+		drifts = append(drifts, fmt.Sprintf("milestone ids: persisted %v, in-memory %v", persisted, inMemory))
+	}
+
+	if len(order) != len(m.FutureMilestoneOrder) || (len(order) > 0 && !reflect.DeepEqual(order, m.FutureMilestoneOrder)) {
+		drifts = append(drifts, fmt.Sprintf("future order: persisted %v, in-memory %v", order, m.FutureMilestoneOrder))
+	}
+
+	if len(list) != len(m.FutureMilestoneList) || (len(list) > 0 && !reflect.DeepEqual(list, m.FutureMilestoneList)) {
+		drifts = append(drifts, fmt.Sprintf("future list: persisted %v, in-memory %v", list, m.FutureMilestoneList))
+	}
+
+	return len(drifts) == 0, strings.Join(drifts, "; "), nil
+}
+
+// sortedIDs returns the sorted milestone ids of the set
+func sortedIDs[V any](ids map[string]V) []string {
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+
+	sort.Strings(sorted)
+
+	return sorted
+}
+
+// isCorrupted checks whether the read error is due to an undecodable or corrupted
+// record, as opposed to a missing one
+func isCorrupted(err error) bool {
+	return errors.Is(err, rawdb.ErrChecksumMismatch) ||
+		errors.Is(err, rawdb.ErrIncorrectLockField) ||
+		errors.Is(err, rawdb.ErrIncorrectFutureMilestoneField)
+}
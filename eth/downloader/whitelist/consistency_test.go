@@ -0,0 +1,59 @@
+package whitelist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// TestCheckPersistenceConsistency checks the reporting of the drift between the persisted and in-memory state
+func TestCheckPersistenceConsistency(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+
+	// Nothing persisted yet
+	consistent, details, err := s.CheckPersistenceConsistency()
+	require.NoError(t, err)
+	require.True(t, consistent, details)
+
+	s.ProcessMilestone(10, common.Hash{0x1})
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID1", 20, common.Hash{0x2})
+	s.ProcessFutureMilestone(16, common.Hash{0x3})
+
+	milestone.LockMutex(30)
+	milestone.UnlockMutex(true, "milestoneID2", 30, common.Hash{0x4})
+
+	consistent, details, err = s.CheckPersistenceConsistency()
+	require.NoError(t, err)
+	require.True(t, consistent, details)
+	require.Empty(t, details)
+
+	// Desync the store
+	require.NoError(t, rawdb.WriteLockField(db, true, 25, common.Hash{0x5}, map[string]rawdb.MilestoneID{"milestoneID3": {}}))
+	require.NoError(t, rawdb.WriteFutureMilestoneList(db, []uint64{16, 24}, map[uint64]common.Hash{16: {0x3}, 24: {0x6}}))
+
+	fingerprint := s.StateFingerprint()
+
+	consistent, details, err = s.CheckPersistenceConsistency()
+	require.NoError(t, err)
+	require.False(t, consistent)
+
+	for _, drift := range []string{"locked number", "locked hash", "milestone ids", "future order", "future list"} {
+		require.Contains(t, details, drift)
+	}
+
+	require.NotContains(t, details, "locked:")
+	require.Equal(t, fingerprint, s.StateFingerprint(), "the probe shouldn't modify the state")
+
+	// Corrupted records are reported as errors
+	require.NoError(t, db.Put([]byte("LockField"), []byte(`{"Val":true,"Block":30,"Checksum":"0x0100000000000000000000000000000000000000000000000000000000000000"}`)))
+
+	_, _, err = s.CheckPersistenceConsistency()
+	require.ErrorIs(t, err, rawdb.ErrChecksumMismatch)
+}
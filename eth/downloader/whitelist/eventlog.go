@@ -0,0 +1,107 @@
+package whitelist
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// defaultEventLogSize is the number of state change events kept in the event log
+const defaultEventLogSize = 1024
+
+// MilestoneEventKind is the kind of a state change recorded in the event log
+type MilestoneEventKind int
+
+const (
+	MilestoneCommitted MilestoneEventKind = iota // A milestone got whitelisted
+	LockEngaged                                  // A sprint got locked
+	LockReleased                                 // The locked sprint got released
+	FutureEnqueued                               // A future milestone got queued
+	FutureDequeued                               // A future milestone left the queue
+)
+
+func (k MilestoneEventKind) String() string {
+	switch k {
+	case MilestoneCommitted:
+		return "milestone committed"
+	case LockEngaged:
+		return "lock engaged"
+	case LockReleased:
+		return "lock released"
+	case FutureEnqueued:
+		return "future enqueued"
+	case FutureDequeued:
+		return "future dequeued"
+	default:
+		return "unknown"
+	}
+}
+
+// MilestoneEvent is a state change of the milestone service. Seq numbers the events
+// from 1, without gaps, so that a consumer can resume from the last one it applied.
+type MilestoneEvent struct {
+	Seq    uint64
+	Kind   MilestoneEventKind
+	Number uint64
+	Hash   common.Hash
+}
+
+// milestoneEventLog is an append-only log of the state changes, bounded to its
+// most recent events. The zero value is ready to use and holds up to
+// defaultEventLogSize events.
+type milestoneEventLog struct {
+	events []MilestoneEvent
+	next   int
+	full   bool
+	seq    uint64 // Sequence number of the last event
+}
+
+// append records a state change, overwriting the oldest event when the log is full
+func (l *milestoneEventLog) append(kind MilestoneEventKind, number uint64, hash common.Hash) {
+	if l.events == nil {
+		l.events = make([]MilestoneEvent, defaultEventLogSize)
+	}
+
+	l.seq++
+
+	l.events[l.next] = MilestoneEvent{Seq: l.seq, Kind: kind, Number: number, Hash: hash}
+	l.next = (l.next + 1) % len(l.events)
+
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// since returns a copy of the events after the given sequence number, oldest first
+func (l *milestoneEventLog) since(seq uint64) []MilestoneEvent {
+	var events []MilestoneEvent
+
+	if l.full {
+		events = append(events, l.events[l.next:]...)
+	}
+
+	events = append(events, l.events[:l.next]...)
+
+	for i, event := range events {
+		if event.Seq > seq {
+			return events[i:]
+		}
+	}
+
+	return nil
+}
+
+// EventsSince returns the recorded state changes with a sequence number above seq,
+// oldest first. Only the most recent events are kept, so a consumer whose first
+// returned event isn't seq+1 missed some and should resync from the full state.
+// Passing zero returns every kept event.
+func (m *milestone) EventsSince(seq uint64) []MilestoneEvent {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	return m.events.since(seq)
+}
+
+// recordEvent appends a state change to the event log.
+// It should be called with the finality lock held.
+func (m *milestone) recordEvent(kind MilestoneEventKind, number uint64, hash common.Hash) {
+	m.events.append(kind, number, hash)
+}
@@ -0,0 +1,64 @@
+package whitelist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// TestEventsSince checks the recording of the state changes in the event log
+func TestEventsSince(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	require.Empty(t, s.EventsSince(0))
+
+	s.ProcessMilestone(10, common.Hash{0x1})
+
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID1", 20, common.Hash{0x2})
+
+	s.ProcessFutureMilestone(16, common.Hash{0x3})
+	s.ProcessFutureMilestone(32, common.Hash{0x4}) // Releases the lock
+	s.ProcessMilestone(16, common.Hash{0x3})       // Dequeues the future milestone at 16
+
+	events := s.EventsSince(0)
+
+	kinds := make([]MilestoneEventKind, 0, len(events))
+	for i, event := range events {
+		require.Equal(t, uint64(i+1), event.Seq)
+
+		kinds = append(kinds, event.Kind)
+	}
+
+	require.Equal(t, []MilestoneEventKind{
+		MilestoneCommitted,
+		LockEngaged,
+		FutureEnqueued,
+		FutureEnqueued,
+		LockReleased,
+		MilestoneCommitted,
+		FutureDequeued,
+	}, kinds)
+
+	require.Equal(t, MilestoneEvent{Seq: 3, Kind: FutureEnqueued, Number: 16, Hash: common.Hash{0x3}}, events[2])
+	require.Equal(t, MilestoneEvent{Seq: 5, Kind: LockReleased, Number: 20, Hash: common.Hash{0x2}}, events[4])
+
+	// Catching up from the middle
+	require.Equal(t, events[5:], s.EventsSince(5))
+	require.Empty(t, s.EventsSince(7))
+
+	// Only the most recent events are kept, the milestones also dequeue the future one at 32
+	for i := uint64(0); i < defaultEventLogSize; i++ {
+		s.ProcessMilestone(20+i, common.Hash{0x5})
+	}
+
+	events = s.EventsSince(0)
+	require.Len(t, events, defaultEventLogSize)
+	require.Equal(t, uint64(9), events[0].Seq)
+	require.Equal(t, uint64(8+defaultEventLogSize), events[len(events)-1].Seq)
+}
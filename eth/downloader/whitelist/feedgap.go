@@ -0,0 +1,45 @@
+package whitelist
+
+// defaultFeedGapsSize is the number of milestone feed gaps kept, see FeedGaps
+const defaultFeedGapsSize = 32
+
+// FeedGap is a range of milestones skipped by the feed, from the expected next
+// milestone to the one preceding the processed milestone, both inclusive
+type FeedGap struct {
+	From uint64
+	To   uint64
+}
+
+// checkFeedGap records a gap if the processed milestone is beyond the expected next
+// one, i.e. the milestone following the whitelisted one by a sprint. It's a no-op
+// without a sprint length or a whitelisted milestone.
+// It should be called with the finality lock held, before whitelisting the block.
+func (m *milestone) checkFeedGap(block uint64) {
+	if m.SprintLength == 0 || !m.doExist {
+		return
+	}
+
+	expected := m.Number + m.SprintLength
+	if block <= expected {
+		return
+	}
+
+	gap := FeedGap{From: expected, To: block - m.SprintLength}
+
+	m.logger().Warn("Gap in the milestone feed", "from", gap.From, "to", gap.To, "endBlockNumber", block)
+	MilestoneFeedGapCounter.Inc(1)
+
+	if len(m.feedGaps) == defaultFeedGapsSize {
+		m.feedGaps = append(m.feedGaps[:0], m.feedGaps[1:]...)
+	}
+
+	m.feedGaps = append(m.feedGaps, gap)
+}
+
+// FeedGaps returns the last gaps detected in the milestone feed, oldest first
+func (m *milestone) FeedGaps() []FeedGap {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	return append([]FeedGap{}, m.feedGaps...)
+}
@@ -0,0 +1,63 @@
+package whitelist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// TestFeedGaps checks the detection of the gaps in the milestone feed
+func TestFeedGaps(t *testing.T) {
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	defer func(counter metrics.Counter) { MilestoneFeedGapCounter = counter }(MilestoneFeedGapCounter)
+	MilestoneFeedGapCounter = metrics.NewCounterForced()
+
+	// Detection disabled without a sprint length
+	s.ProcessMilestone(16, common.Hash{16})
+	s.ProcessMilestone(64, common.Hash{64})
+	require.Empty(t, s.FeedGaps())
+	require.Equal(t, int64(0), MilestoneFeedGapCounter.Snapshot().Count())
+
+	milestone.SprintLength = 16
+
+	// Contiguous milestones
+	s.ProcessMilestone(80, common.Hash{80})
+	s.ProcessMilestone(96, common.Hash{96})
+	require.Empty(t, s.FeedGaps())
+	require.Equal(t, int64(0), MilestoneFeedGapCounter.Snapshot().Count())
+
+	// A single skipped milestone
+	s.ProcessMilestone(128, common.Hash{128})
+	require.Equal(t, []FeedGap{{From: 112, To: 112}}, s.FeedGaps())
+	require.Equal(t, int64(1), MilestoneFeedGapCounter.Snapshot().Count())
+
+	// Several skipped milestones
+	s.ProcessMilestone(192, common.Hash{192})
+	require.Equal(t, []FeedGap{{From: 112, To: 112}, {From: 144, To: 176}}, s.FeedGaps())
+	require.Equal(t, int64(2), MilestoneFeedGapCounter.Snapshot().Count())
+
+	// A repeated or older milestone isn't a gap
+	s.ProcessMilestone(192, common.Hash{192})
+	s.ProcessMilestone(176, common.Hash{176})
+	require.Len(t, s.FeedGaps(), 2)
+
+	// The gaps are bounded, keeping the most recent ones
+	for i := 0; i < defaultFeedGapsSize; i++ {
+		s.ProcessMilestone(milestone.Number+32, common.Hash{})
+	}
+
+	gaps := s.FeedGaps()
+	require.Len(t, gaps, defaultFeedGapsSize)
+	require.Equal(t, milestone.Number-16, gaps[len(gaps)-1].From)
+	require.Equal(t, int64(2+defaultFeedGapsSize), MilestoneFeedGapCounter.Snapshot().Count())
+
+	// No detection without a whitelisted milestone
+	s.PurgeWhitelistedMilestone()
+	s.ProcessMilestone(milestone.Number+64, common.Hash{})
+	require.Equal(t, gaps, s.FeedGaps())
+}
@@ -1,8 +1,10 @@
 package whitelist
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/rawdb"
@@ -18,29 +20,56 @@ type finality[T rawdb.BlockFinality[T]] struct {
 	Number   uint64      // Number , populated by reaching out to heimdall
 	interval uint64      // Interval, until which we can allow importing
 	doExist  bool
+
+	// FetchRetries is the number of times a failed fetch of the peer's header is
+	// retried in IsValidPeer, waiting FetchBackoff (doubled on every attempt) in
+	// between. Only the fetch failures are retried, never a mismatch. See WithFetchRetries.
+	FetchRetries int
+	FetchBackoff time.Duration
 }
 
 type finalityService interface {
 	IsValidPeer(fetchHeadersByNumber func(number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (bool, error)
+	IsValidPeerCtx(ctx context.Context, fetchHeadersByNumber func(ctx context.Context, number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (bool, error)
 	IsValidChain(currentHeader *types.Header, chain []*types.Header) (bool, error)
 	Get() (bool, uint64, common.Hash)
 	Process(block uint64, hash common.Hash)
 	Purge()
+	Status() FinalityStatus
 }
 
 // IsValidPeer checks if the chain we're about to receive from a peer is valid or not
 // in terms of reorgs. We won't reorg beyond the last bor finality submitted to mainchain.
 func (f *finality[T]) IsValidPeer(fetchHeadersByNumber func(number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (bool, error) {
+	return f.IsValidPeerCtx(context.Background(), withoutContext(fetchHeadersByNumber))
+}
+
+// IsValidPeerCtx is IsValidPeer with a fetch of the peer's header honouring the
+// context, the check returning the context error once it's cancelled
+func (f *finality[T]) IsValidPeerCtx(ctx context.Context, fetchHeadersByNumber func(ctx context.Context, number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (bool, error) {
+	_, err := f.CheckPeerCtx(ctx, fetchHeadersByNumber)
+
+	return err == nil, err
+}
+
+// CheckPeer checks the peer like IsValidPeer, reporting the details of the comparison
+func (f *finality[T]) CheckPeer(fetchHeadersByNumber func(number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (PeerVerdict, error) {
+	return f.CheckPeerCtx(context.Background(), withoutContext(fetchHeadersByNumber))
+}
+
+// CheckPeerCtx is CheckPeer with a fetch of the peer's header honouring the context
+func (f *finality[T]) CheckPeerCtx(ctx context.Context, fetchHeadersByNumber func(ctx context.Context, number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (PeerVerdict, error) {
 	// We want to validate the chain by comparing the last finalized block
 	f.RLock()
 
 	doExist := f.doExist
 	number := f.Number
 	hash := f.Hash
+	retry := fetchRetryPolicy{retries: f.FetchRetries, backoff: f.FetchBackoff}
 
 	f.RUnlock()
 
-	return isValidPeer(fetchHeadersByNumber, doExist, number, hash)
+	return checkPeer(ctx, fetchHeadersByNumber, retry, doExist, number, hash)
 }
 
 // IsValidChain checks the validity of chain by comparing it
@@ -57,15 +86,23 @@ func (f *finality[T]) IsValidChain(currentHeader *types.Header, chain []*types.H
 	return res, err
 }
 
-func (f *finality[T]) Process(block uint64, hash common.Hash) {
-	f.doExist = true
-	f.Hash = hash
-	f.Number = block
+// Process whitelists the entry and persists it, returning the persistence error
+func (f *finality[T]) Process(block uint64, hash common.Hash) error {
+	f.set(block, hash)
 
 	err := rawdb.WriteLastFinality[T](f.db, block, hash)
 	if err != nil {
 		log.Error("Error in writing whitelist state to db", "err", err)
 	}
+
+	return err
+}
+
+// set whitelists the entry in memory only
+func (f *finality[T]) set(block uint64, hash common.Hash) {
+	f.doExist = true
+	f.Hash = hash
+	f.Number = block
 }
 
 // Get returns the existing whitelisted
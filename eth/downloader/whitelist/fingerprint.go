@@ -0,0 +1,58 @@
+package whitelist
+
+import (
+	"encoding/binary"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// StateFingerprint returns a hash of the canonical (sorted) milestone state, i.e. the
+// whitelisted milestone, the lock fields, the milestone ids and the future milestones.
+// Services with the same logical state have the same fingerprint, regardless of the
+// order in which the ids and the future milestones were added.
+func (m *milestone) StateFingerprint() [32]byte {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	ids := make([]string, 0, len(m.LockedMilestoneIDs))
+	for id := range m.LockedMilestoneIDs {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	keys := make([]uint64, 0, len(m.FutureMilestoneList))
+	for key := range m.FutureMilestoneList {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	data := make([][]byte, 0, 2*len(ids)+2*len(keys)+8)
+
+	data = append(data, boolByte(m.doExist), binary.BigEndian.AppendUint64(nil, m.Number), m.Hash.Bytes())
+	data = append(data, boolByte(m.Locked), binary.BigEndian.AppendUint64(nil, m.LockedMilestoneNumber), m.LockedMilestoneHash.Bytes())
+
+	// Length prefix the ids and the future milestones, so that the concatenation is unambiguous
+	data = append(data, binary.BigEndian.AppendUint64(nil, uint64(len(ids))))
+	for _, id := range ids {
+		data = append(data, binary.BigEndian.AppendUint64(nil, uint64(len(id))), []byte(id))
+	}
+
+	data = append(data, binary.BigEndian.AppendUint64(nil, uint64(len(keys))))
+	for _, key := range keys {
+		hash := m.FutureMilestoneList[key]
+		data = append(data, binary.BigEndian.AppendUint64(nil, key), hash.Bytes())
+	}
+
+	return crypto.Keccak256Hash(data...)
+}
+
+func boolByte(b bool) []byte {
+	if b {
+		return []byte{1}
+	}
+
+	return []byte{0}
+}
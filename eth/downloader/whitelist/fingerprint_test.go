@@ -0,0 +1,64 @@
+package whitelist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// TestStateFingerprint checks the stability of the state fingerprint and its sensitivity to each field
+func TestStateFingerprint(t *testing.T) {
+	t.Parallel()
+
+	populate := func(s *Service, ids []string, future []uint64) {
+		milestone := s.milestoneService.(*milestone)
+
+		s.ProcessMilestone(10, common.Hash{0x1})
+
+		milestone.LockMutex(20)
+		milestone.UnlockMutex(true, ids[0], 20, common.Hash{0x2})
+
+		// Locking again would purge the previous ids
+		for _, id := range ids[1:] {
+			milestone.LockedMilestoneIDs[id] = rawdb.MilestoneID{}
+		}
+
+		for _, num := range future {
+			s.ProcessFutureMilestone(num, common.Hash{byte(num)})
+		}
+	}
+
+	s1 := NewMockService(rawdb.NewMemoryDatabase())
+	s2 := NewMockService(rawdb.NewMemoryDatabase())
+
+	populate(s1, []string{"milestoneID1", "milestoneID2"}, []uint64{14, 16})
+	populate(s2, []string{"milestoneID2", "milestoneID1"}, []uint64{14, 16})
+
+	base := s1.StateFingerprint()
+	require.Equal(t, base, s1.StateFingerprint(), "fingerprint should be stable")
+	require.Equal(t, base, s2.StateFingerprint(), "same logical state should have the same fingerprint")
+
+	mutations := map[string]func(m *milestone){
+		"doExist":     func(m *milestone) { m.doExist = false },
+		"number":      func(m *milestone) { m.Number = 11 },
+		"hash":        func(m *milestone) { m.Hash = common.Hash{0xff} },
+		"locked":      func(m *milestone) { m.Locked = false },
+		"lockNumber":  func(m *milestone) { m.LockedMilestoneNumber = 21 },
+		"lockHash":    func(m *milestone) { m.LockedMilestoneHash = common.Hash{0xff} },
+		"ids":         func(m *milestone) { delete(m.LockedMilestoneIDs, "milestoneID2") },
+		"futureHash":  func(m *milestone) { m.FutureMilestoneList[16] = common.Hash{0xff} },
+		"futureEntry": func(m *milestone) { m.FutureMilestoneList[18] = common.Hash{0x12} },
+	}
+
+	for name, mutate := range mutations {
+		s := NewMockService(rawdb.NewMemoryDatabase())
+		populate(s, []string{"milestoneID1", "milestoneID2"}, []uint64{14, 16})
+		require.Equal(t, base, s.StateFingerprint())
+
+		mutate(s.milestoneService.(*milestone))
+		require.NotEqual(t, base, s.StateFingerprint(), "fingerprint should change with %s", name)
+	}
+}
@@ -0,0 +1,66 @@
+package whitelist
+
+import (
+	"golang.org/x/exp/slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// futureQueue applies the capacity and eviction policy shared by the future milestone
+// and checkpoint lists. The entries themselves are added and removed by the callbacks,
+// so that each list keeps its own bookkeeping.
+type futureQueue struct {
+	kind        string // Kind of the entries, for the logs
+	capacity    int    // Maximum number of entries
+	evictLowest bool   // Evict the lowest entry of a full list for a higher incoming one
+	logger      log.Logger
+
+	order   func() []uint64                         // Returns the entries, sorted ascending
+	enqueue func(num uint64, hash common.Hash) bool // Adds the entry, reporting whether the list changed
+	dequeue func()                                  // Removes the lowest entry
+}
+
+// queue queues the entry within the capacity, evicting the lowest entries if needed.
+// It reports whether the list changed and whether the entry was dropped because the
+// list is full. It should be called with the finality lock held.
+func (q futureQueue) queue(num uint64, hash common.Hash) (changed bool, dropped bool) {
+	// Evict the lowest entries beyond a lowered capacity
+	for len(q.order()) > q.capacity {
+		q.logger.Info("Evicting future "+q.kind+" beyond the capacity", "endBlockNumber", q.order()[0], "capacity", q.capacity)
+		q.dequeue()
+
+		changed = true
+	}
+
+	if q.evictLowest && evictsLowest(num, q.order(), q.capacity) {
+		q.logger.Info("Evicting the lowest future "+q.kind+" for a higher one", "evicted", q.order()[0], "endBlockNumber", num)
+		q.dequeue()
+
+		changed = true
+	}
+
+	if len(q.order()) >= q.capacity && !slices.Contains(q.order(), num) {
+		q.logger.Warn("Dropping future "+q.kind+", the list is full", "endBlockNumber", num, "hash", hash, "capacity", q.capacity)
+		return changed, true
+	}
+
+	if q.enqueue(num, hash) {
+		changed = true
+	}
+
+	return changed, false
+}
+
+// evictsLowest checks whether the lowest of the full sorted future entries makes room
+// for the incoming higher one
+func evictsLowest(num uint64, order []uint64, capacity int) bool {
+	return len(order) > 0 && len(order) >= capacity && num > order[0] && !slices.Contains(order, num)
+}
+
+// insertSorted inserts the number at its position in the ascending order
+func insertSorted(order []uint64, num uint64) []uint64 {
+	i, _ := slices.BinarySearch(order, num)
+
+	return slices.Insert(order, i, num)
+}
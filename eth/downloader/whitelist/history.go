@@ -0,0 +1,85 @@
+package whitelist
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// defaultHistorySize is the number of whitelisted milestones kept in the history
+const defaultHistorySize = 32
+
+// MilestoneRecord is an entry of the whitelisted milestone history
+type MilestoneRecord struct {
+	Number    uint64
+	Hash      common.Hash
+	Timestamp time.Time
+}
+
+// milestoneHistory is a ring buffer of the last whitelisted milestones. The zero
+// value is ready to use and holds up to defaultHistorySize entries.
+type milestoneHistory struct {
+	size    int // Number of entries held, defaultHistorySize if not positive
+	records []MilestoneRecord
+	next    int
+	full    bool
+}
+
+// WithHistorySize sets the number of whitelisted milestones kept in the history,
+// see RecentMilestones. Zero or less uses defaultHistorySize.
+func WithHistorySize(size int) ServiceOption {
+	return func(m *milestone) {
+		m.history.size = size
+	}
+}
+
+// RecentMilestones returns the last whitelisted milestones, oldest first, each
+// recorded when Process advanced the milestone. The history isn't persisted, hence
+// it's empty after a restart.
+func (m *milestone) RecentMilestones() []MilestoneRecord {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	return m.history.list()
+}
+
+// add records a milestone, overwriting the oldest entry when the buffer is full
+func (h *milestoneHistory) add(record MilestoneRecord) {
+	if h.records == nil {
+		size := h.size
+		if size <= 0 {
+			size = defaultHistorySize
+		}
+
+		h.records = make([]MilestoneRecord, size)
+	}
+
+	h.records[h.next] = record
+	h.next = (h.next + 1) % len(h.records)
+
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// list returns a copy of the recorded milestones, oldest first
+func (h *milestoneHistory) list() []MilestoneRecord {
+	if !h.full {
+		return append([]MilestoneRecord{}, h.records[:h.next]...)
+	}
+
+	records := make([]MilestoneRecord, 0, len(h.records))
+	records = append(records, h.records[h.next:]...)
+	records = append(records, h.records[:h.next]...)
+
+	return records
+}
+
+// len returns the number of recorded milestones
+func (h *milestoneHistory) len() int {
+	if h.full {
+		return len(h.records)
+	}
+
+	return h.next
+}
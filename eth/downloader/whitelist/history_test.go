@@ -0,0 +1,59 @@
+package whitelist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// TestRecentMilestones checks that the history keeps only the most recent
+// whitelisted milestones, in order
+func TestRecentMilestones(t *testing.T) {
+	t.Parallel()
+
+	s := NewService(rawdb.NewMemoryDatabase(), WithHistorySize(4))
+
+	milestone := s.milestoneService.(*milestone)
+
+	genesis := time.Unix(1_700_000_000, 0)
+	clock := newFakeClock(genesis)
+
+	milestone.Clock = clock
+
+	require.Empty(t, s.RecentMilestones())
+
+	for i := uint64(1); i <= 6; i++ {
+		s.ProcessMilestone(i*16, common.Hash{byte(i)})
+		clock.Advance(time.Second)
+	}
+
+	// Processing the same milestone again doesn't advance it
+	s.ProcessMilestone(96, common.Hash{6})
+
+	expected := make([]MilestoneRecord, 0, 4)
+	for i := uint64(3); i <= 6; i++ {
+		expected = append(expected, MilestoneRecord{Number: i * 16, Hash: common.Hash{byte(i)}, Timestamp: genesis.Add(time.Duration(i-1) * time.Second)})
+	}
+
+	require.Equal(t, expected, s.RecentMilestones())
+
+	// The returned records are a copy
+	s.RecentMilestones()[0].Number = 0
+	require.Equal(t, expected, s.RecentMilestones())
+
+	// The default size
+	s = NewMockService(rawdb.NewMemoryDatabase())
+
+	for i := uint64(1); i <= defaultHistorySize+2; i++ {
+		s.ProcessMilestone(i*16, common.Hash{byte(i)})
+	}
+
+	records := s.RecentMilestones()
+	require.Len(t, records, defaultHistorySize)
+	require.Equal(t, uint64(3*16), records[0].Number)
+	require.Equal(t, uint64((defaultHistorySize+2)*16), records[len(records)-1].Number)
+}
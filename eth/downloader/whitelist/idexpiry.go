@@ -0,0 +1,242 @@
+package whitelist
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// ServiceOption configures the milestone service built by NewService
+type ServiceOption func(*milestone)
+
+// WithMilestoneIDPruning prunes, every interval, the milestone ids older than maxAge
+// (see PruneStaleMilestoneIDs) in the background, until StopMilestoneIDPruning.
+func WithMilestoneIDPruning(interval time.Duration, maxAge time.Duration) ServiceOption {
+	return func(m *milestone) {
+		if interval > 0 && maxAge > 0 {
+			m.pruner = &milestoneIDPruner{interval: interval, maxAge: maxAge, quit: make(chan struct{})}
+		}
+	}
+}
+
+// DefaultMaxMilestoneIDs is the default bound of the milestone ids of the lock
+const DefaultMaxMilestoneIDs = 256
+
+// WithMaxMilestoneIDs sets the MaxMilestoneIDs bound of the milestone ids of the lock
+func WithMaxMilestoneIDs(max int) ServiceOption {
+	return func(m *milestone) {
+		m.MaxMilestoneIDs = max
+	}
+}
+
+// IDEvictionPolicy is the choice of the milestone ids dropped once the ids of the
+// lock exceed MaxMilestoneIDs
+type IDEvictionPolicy int
+
+const (
+	// IDEvictOldest evicts the least recently added ids
+	IDEvictOldest IDEvictionPolicy = iota
+	// IDRejectNew keeps the least recently added ids, dropping the newer ones
+	IDRejectNew
+)
+
+// WithIDEvictionPolicy sets the IDEvictionPolicy of the milestone ids beyond MaxMilestoneIDs
+func WithIDEvictionPolicy(policy IDEvictionPolicy) ServiceOption {
+	return func(m *milestone) {
+		m.IDEvictionPolicy = policy
+	}
+}
+
+// milestoneIDPruner periodically prunes the stale milestone ids
+type milestoneIDPruner struct {
+	interval time.Duration
+	maxAge   time.Duration
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (p *milestoneIDPruner) start(m *milestone) {
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.PruneStaleMilestoneIDs(p.maxAge)
+			case <-p.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (p *milestoneIDPruner) stop() {
+	close(p.quit)
+	p.wg.Wait()
+}
+
+// StopMilestoneIDPruning stops the background pruning of the milestone ids, if any
+func (m *milestone) StopMilestoneIDPruning() {
+	m.finality.Lock()
+	pruner := m.pruner
+	m.pruner = nil
+	m.unlock()
+
+	if pruner != nil {
+		pruner.stop()
+	}
+}
+
+// PruneStaleMilestoneIDs removes the milestone ids added more than maxAge ago and
+// returns them, unlocking the sprint if none is left. The ids of unknown age, i.e.
+// stored before their age was recorded, are never pruned.
+func (m *milestone) PruneStaleMilestoneIDs(maxAge time.Duration) []string {
+	m.finality.Lock()
+	defer m.unlock()
+
+	if err := m.checkPersistence(); err != nil {
+		m.logger().Warn("Refusing to prune the stale milestoneIDs", "err", err)
+		return nil
+	}
+
+	pruned := m.expireMilestoneIDs(m.timeNow(), maxAge)
+	if len(pruned) == 0 {
+		return nil
+	}
+
+	m.logger().Info("Pruned stale milestoneIDs", "pruned", pruned, "maxAge", maxAge)
+
+	if len(m.LockedMilestoneIDs) == 0 {
+		m.Locked = false
+		m.lockReleased(UnlockReasonIDsRemoved)
+	}
+
+	m.writeLockField()
+
+	MilestoneIdsLengthMeter.Update(int64(len(m.LockedMilestoneIDs)))
+
+	m.sendLockEvent()
+
+	return pruned
+}
+
+// expireMilestoneIDs deletes the milestone ids added more than maxAge before now,
+// and returns them sorted. It doesn't persist the change.
+// It should be called with the finality lock held.
+func (m *milestone) expireMilestoneIDs(now time.Time, maxAge time.Duration) []string {
+	var expired []string
+
+	for id, entry := range m.LockedMilestoneIDs {
+		if !entry.AddedAt.IsZero() && now.Sub(entry.AddedAt) > maxAge {
+			delete(m.LockedMilestoneIDs, id)
+			expired = append(expired, id)
+		}
+	}
+
+	sort.Strings(expired)
+	MilestoneIdsRemovedMeter.Mark(int64(len(expired)))
+
+	return expired
+}
+
+// activeMilestoneID returns the id which engaged the current lock, empty if not locked.
+// It's taken from the lock lifecycle if it tracks the locked sprint. Otherwise, e.g.
+// after a restart or a restore, it's derived from the persisted lock: the ids being
+// purged whenever a sprint is locked, the one which locked it is the most recently
+// added. None is found among ids of unknown age. It should be called with the
+// finality lock held.
+func (m *milestone) activeMilestoneID() string {
+	if !m.Locked {
+		return ""
+	}
+
+	if lc := m.lockLifecycle; lc != nil && lc.UnlockedAt.IsZero() && len(lc.IDs) > 0 &&
+		lc.Number == m.LockedMilestoneNumber && lc.Hash == m.LockedMilestoneHash {
+		if _, ok := m.LockedMilestoneIDs[lc.IDs[0].ID]; ok {
+			return lc.IDs[0].ID
+		}
+	}
+
+	var (
+		active  string
+		addedAt time.Time
+	)
+
+	for id, entry := range m.LockedMilestoneIDs {
+		if active == "" || entry.AddedAt.After(addedAt) || (entry.AddedAt.Equal(addedAt) && id > active) {
+			active, addedAt = id, entry.AddedAt
+		}
+	}
+
+	if addedAt.IsZero() {
+		return ""
+	}
+
+	return active
+}
+
+// evictMilestoneIDs evicts the milestone ids beyond MaxMilestoneIDs and returns them.
+// The least recently added ids are evicted first, the ids of unknown age being the
+// oldest ones in lexical order, unless IDEvictionPolicy rejects the newest ones.
+// The id which engaged the current lock (see activeMilestoneID) is never evicted,
+// including after a restart. It doesn't persist the
+// change. It should be called with the finality lock held.
+func (m *milestone) evictMilestoneIDs() []string {
+	limit := m.MaxMilestoneIDs
+	if limit <= 0 {
+		limit = DefaultMaxMilestoneIDs
+	}
+
+	if len(m.LockedMilestoneIDs) <= limit {
+		return nil
+	}
+
+	active := m.activeMilestoneID()
+
+	candidates := make([]string, 0, len(m.LockedMilestoneIDs))
+	for id := range m.LockedMilestoneIDs {
+		candidates = append(candidates, id)
+	}
+
+	// From the least recently added id, the ids of unknown age (zero time) first
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := m.LockedMilestoneIDs[candidates[i]].AddedAt, m.LockedMilestoneIDs[candidates[j]].AddedAt
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+
+		return candidates[i] < candidates[j]
+	})
+
+	if m.IDEvictionPolicy == IDRejectNew {
+		slices.Reverse(candidates)
+	}
+
+	var evicted []string
+
+	for _, id := range candidates {
+		if len(m.LockedMilestoneIDs) <= limit {
+			break
+		}
+
+		if id == active {
+			continue
+		}
+
+		delete(m.LockedMilestoneIDs, id)
+		evicted = append(evicted, id)
+	}
+
+	MilestoneIdsRemovedMeter.Mark(int64(len(evicted)))
+	m.logger().Warn("Evicted milestoneIDs beyond the bound", "evicted", evicted, "max", limit, "policy", m.IDEvictionPolicy)
+
+	return evicted
+}
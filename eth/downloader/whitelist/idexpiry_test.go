@@ -0,0 +1,214 @@
+package whitelist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// TestPruneStaleMilestoneIDs checks the pruning of the milestone ids by age
+func TestPruneStaleMilestoneIDs(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+
+	clock := newFakeClock(time.Unix(1_700_000_000, 0))
+	milestone.Clock = clock
+
+	// Nothing to prune without a lock
+	require.Empty(t, s.PruneStaleMilestoneIDs(time.Minute))
+
+	require.True(t, s.LockMilestone(16, common.Hash{16}, "milestoneID1"))
+
+	// Not stale yet
+	clock.Advance(time.Minute)
+	require.Empty(t, s.PruneStaleMilestoneIDs(time.Minute))
+	require.Equal(t, []string{"milestoneID1"}, s.GetMilestoneIDsList())
+	require.True(t, milestone.Locked)
+
+	// A new lock resets the age
+	require.True(t, s.LockMilestone(32, common.Hash{32}, "milestoneID2"))
+
+	clock.Advance(time.Minute + time.Second)
+	require.Equal(t, []string{"milestoneID2"}, s.PruneStaleMilestoneIDs(time.Minute))
+	require.Empty(t, s.GetMilestoneIDsList())
+	require.False(t, milestone.Locked)
+
+	lifecycle, ok := s.LastLockLifecycle()
+	require.True(t, ok)
+	require.Equal(t, UnlockReasonIDsRemoved, lifecycle.UnlockReason)
+	require.Equal(t, clock.Now(), lifecycle.UnlockedAt)
+
+	locked, _, _, lockedIDs, err := rawdb.ReadLockField(db)
+	require.NoError(t, err)
+	require.False(t, locked)
+	require.Empty(t, lockedIDs)
+
+	// The age of the ids is persisted along with the lock
+	require.True(t, s.LockMilestone(48, common.Hash{48}, "milestoneID3"))
+
+	// On the system clock of the loaded service, the id added at the fake time is long stale
+	loaded := NewService(db)
+	require.Equal(t, []string{"milestoneID3"}, loaded.PruneStaleMilestoneIDs(time.Minute))
+
+	// The ids stored without their age aren't pruned
+	require.NoError(t, rawdb.WriteLockField(db, true, 64, common.Hash{64}, map[string]rawdb.MilestoneID{"milestoneID4": {}}))
+
+	loaded = NewService(db)
+	require.Empty(t, loaded.PruneStaleMilestoneIDs(time.Minute))
+	require.Equal(t, []string{"milestoneID4"}, loaded.GetMilestoneIDsList())
+}
+
+// TestMilestoneIDPruning checks the background pruning of the milestone ids
+func TestMilestoneIDPruning(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewService(db, WithMilestoneIDPruning(5*time.Millisecond, time.Millisecond))
+
+	defer s.StopMilestoneIDPruning()
+
+	require.True(t, s.LockMilestone(16, common.Hash{16}, "milestoneID1"))
+
+	require.Eventually(t, func() bool {
+		return len(s.GetMilestoneIDsList()) == 0
+	}, time.Second, 5*time.Millisecond)
+
+	s.StopMilestoneIDPruning()
+	s.StopMilestoneIDPruning()
+
+	// No more pruning once stopped
+	require.True(t, s.LockMilestone(32, common.Hash{32}, "milestoneID2"))
+
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, []string{"milestoneID2"}, s.GetMilestoneIDsList())
+}
+
+// TestMaxMilestoneIDs checks the eviction of the milestone ids beyond the bound
+func TestMaxMilestoneIDs(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, m := newMockMilestone(db)
+	m.MaxMilestoneIDs = 3
+
+	clock := newFakeClock(time.Unix(1000, 0))
+	m.Clock = clock
+
+	// The ids of unknown age are evicted first
+	snapshot := s.Snapshot()
+	snapshot.Locked = true
+	snapshot.LockedMilestoneNumber = 32
+	snapshot.LockedMilestoneHash = common.Hash{0x2}
+	snapshot.LockedMilestoneIDs = map[string]rawdb.MilestoneID{"a": {}, "b": {}, "c": {}, "d": {}, "e": {}}
+
+	s.Restore(snapshot)
+	require.Equal(t, []string{"c", "d", "e"}, sortedIDs(s.Snapshot().LockedMilestoneIDs))
+
+	_, _, _, ids, err := rawdb.ReadLockField(db)
+	require.NoError(t, err)
+	require.Equal(t, []string{"c", "d", "e"}, sortedIDs(ids))
+
+	// The least recently added id is evicted, but never the one engaging the lock
+	require.True(t, s.LockMilestone(48, common.Hash{0x3}, "active"))
+
+	m.finality.Lock()
+
+	for _, id := range []string{"id1", "id2", "id3"} {
+		clock.Advance(time.Second)
+		m.LockedMilestoneIDs[id] = rawdb.MilestoneID{AddedAt: clock.Now()}
+	}
+
+	evicted := m.evictMilestoneIDs()
+
+	m.finality.Unlock()
+
+	require.Equal(t, []string{"id1"}, evicted)
+	require.Equal(t, []string{"active", "id2", "id3"}, sortedIDs(s.Snapshot().LockedMilestoneIDs))
+
+	// The ids persisted by the older versions are bounded on startup
+	require.NoError(t, rawdb.WriteLockField(db, true, 48, common.Hash{0x3}, map[string]rawdb.MilestoneID{"a": {}, "b": {}, "c": {}}))
+
+	restarted := NewService(db, WithMaxMilestoneIDs(2))
+	require.Equal(t, []string{"b", "c"}, sortedIDs(restarted.Snapshot().LockedMilestoneIDs))
+
+	_, _, _, ids, err = rawdb.ReadLockField(db)
+	require.NoError(t, err)
+	require.Equal(t, []string{"b", "c"}, sortedIDs(ids))
+
+	// The id which engaged the persisted lock is kept after a restart, even when the
+	// policy rejects the newest ids
+	require.NoError(t, rawdb.WriteLockField(db, true, 64, common.Hash{0x4}, map[string]rawdb.MilestoneID{
+		"id1":    {AddedAt: time.Unix(1000, 0).UTC()},
+		"id2":    {AddedAt: time.Unix(1001, 0).UTC()},
+		"active": {AddedAt: time.Unix(1002, 0).UTC()},
+	}))
+
+	restarted = NewService(db, WithMaxMilestoneIDs(2), WithIDEvictionPolicy(IDRejectNew))
+	require.Equal(t, []string{"active", "id1"}, sortedIDs(restarted.Snapshot().LockedMilestoneIDs))
+
+	// The default bound
+	require.Len(t, NewService(db).Snapshot().LockedMilestoneIDs, 2)
+	require.Nil(t, NewMockService(rawdb.NewMemoryDatabase()).milestoneService.(*milestone).evictMilestoneIDs())
+}
+
+// TestIDEvictionPolicy checks the choice of the milestone ids dropped at capacity under both policies
+func TestIDEvictionPolicy(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		policy   IDEvictionPolicy
+		evicted  []string
+		kept     []string
+		restored []string
+	}{
+		{IDEvictOldest, []string{"id1"}, []string{"active", "id2", "id3"}, []string{"c", "d", "e"}},
+		{IDRejectNew, []string{"id3"}, []string{"active", "id1", "id2"}, []string{"a", "b", "e"}},
+	} {
+		db := rawdb.NewMemoryDatabase()
+		s := NewService(db, WithMaxMilestoneIDs(3), WithIDEvictionPolicy(tc.policy))
+
+		m := s.milestoneService.(*milestone)
+		require.Equal(t, tc.policy, m.IDEvictionPolicy)
+
+		clock := newFakeClock(time.Unix(1000, 0))
+		m.Clock = clock
+
+		// The id which engaged the lock is kept regardless of the policy
+		require.True(t, s.LockMilestone(48, common.Hash{0x3}, "active"))
+
+		m.finality.Lock()
+
+		for _, id := range []string{"id1", "id2", "id3"} {
+			clock.Advance(time.Second)
+			m.LockedMilestoneIDs[id] = rawdb.MilestoneID{AddedAt: clock.Now()}
+		}
+
+		evicted := m.evictMilestoneIDs()
+
+		m.finality.Unlock()
+
+		require.Equal(t, tc.evicted, evicted, "policy %d", tc.policy)
+		require.Equal(t, tc.kept, sortedIDs(s.Snapshot().LockedMilestoneIDs), "policy %d", tc.policy)
+
+		// The ids left are persisted, the most recently added one of the restored lock
+		// being kept as the one which engaged it
+		snapshot := s.Snapshot()
+		snapshot.LockedMilestoneIDs = make(map[string]rawdb.MilestoneID)
+
+		for i, id := range []string{"a", "b", "c", "d", "e"} {
+			snapshot.LockedMilestoneIDs[id] = rawdb.MilestoneID{AddedAt: time.Unix(int64(2000+i), 0).UTC()}
+		}
+
+		s.Restore(snapshot)
+
+		_, _, _, ids, err := rawdb.ReadLockField(db)
+		require.NoError(t, err)
+		require.Equal(t, tc.restored, sortedIDs(ids), "policy %d", tc.policy)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !debug
+
+package whitelist
+
+// checkFutureMilestoneInvariant checks the consistency of the future milestone list,
+// which is only done in debug builds.
+func (m *milestone) checkFutureMilestoneInvariant() bool {
+	return true
+}
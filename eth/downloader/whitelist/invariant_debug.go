@@ -0,0 +1,47 @@
+//go:build debug
+
+package whitelist
+
+import (
+	"runtime/debug"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// checkFutureMilestoneInvariant checks that the future milestone order is sorted
+// strictly ascending, holds exactly the numbers of the list and doesn't exceed the
+// capacity, logging the stack trace of the faulty mutation otherwise.
+// It should be called with the finality lock held.
+func (m *milestone) checkFutureMilestoneInvariant() bool {
+	if len(m.FutureMilestoneList) == len(m.FutureMilestoneOrder) && len(m.FutureMilestoneOrder) <= m.MaxCapacity &&
+		isStrictlyAscending(m.FutureMilestoneOrder) && hasAllKeys(m.FutureMilestoneList, m.FutureMilestoneOrder) {
+		return true
+	}
+
+	m.logger().Error("Future milestone list invariant violated", "listLength", len(m.FutureMilestoneList),
+		"orderLength", len(m.FutureMilestoneOrder), "order", m.FutureMilestoneOrder, "capacity", m.MaxCapacity, "stack", string(debug.Stack()))
+
+	return false
+}
+
+// isStrictlyAscending checks that the numbers are sorted ascending, without duplicates
+func isStrictlyAscending(numbers []uint64) bool {
+	for i := 1; i < len(numbers); i++ {
+		if numbers[i] <= numbers[i-1] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasAllKeys checks that the list has a hash for every number
+func hasAllKeys(list map[uint64]common.Hash, numbers []uint64) bool {
+	for _, number := range numbers {
+		if _, ok := list[number]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
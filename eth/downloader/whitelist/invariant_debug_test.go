@@ -0,0 +1,83 @@
+//go:build debug
+
+package whitelist
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// TestFutureMilestoneInvariant checks the detection of an inconsistent future milestone list
+func TestFutureMilestoneInvariant(t *testing.T) {
+	var stacks []string
+
+	// The root handler is global, hence the test can't run in parallel
+	defer func(handler log.Handler) { log.Root().SetHandler(handler) }(log.Root().GetHandler())
+	log.Root().SetHandler(log.FuncHandler(func(r *log.Record) error {
+		if r.Msg != "Future milestone list invariant violated" {
+			return nil
+		}
+
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			if r.Ctx[i] == "stack" {
+				stacks = append(stacks, r.Ctx[i+1].(string))
+			}
+		}
+
+		return nil
+	}, log.LvlTrace))
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	milestone := s.milestoneService.(*milestone)
+
+	s.ProcessFutureMilestone(16, common.Hash{0x1})
+	require.True(t, milestone.checkFutureMilestoneInvariant())
+	require.Empty(t, stacks)
+
+	// Add an order entry without its hash behind the back of the service
+	milestone.FutureMilestoneOrder = append(milestone.FutureMilestoneOrder, 32)
+
+	s.ProcessFutureMilestone(48, common.Hash{0x3})
+
+	require.False(t, milestone.checkFutureMilestoneInvariant())
+	require.NotEmpty(t, stacks)
+	require.True(t, strings.Contains(stacks[0], "enqueueFutureMilestone"), "expected the stack to point to the faulty path")
+
+	// Same lengths, with an order entry other than the hashed one
+	milestone.FutureMilestoneOrder = []uint64{16, 32, 48}
+	milestone.FutureMilestoneList = map[uint64]common.Hash{16: {0x1}, 40: {0x2}, 48: {0x3}}
+
+	require.False(t, milestone.checkFutureMilestoneInvariant())
+}
+
+// TestFutureMilestoneCapacityInvariant checks the detection of a future milestone list
+// exceeding its capacity, and that lowering the capacity doesn't break the invariant
+func TestFutureMilestoneCapacityInvariant(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	for i := uint64(1); i <= 4; i++ {
+		s.ProcessFutureMilestone(i*16, common.Hash{byte(i)})
+	}
+
+	require.True(t, milestone.checkFutureMilestoneInvariant())
+
+	// Lowering the capacity evicts the lowest ones right away
+	require.NoError(t, s.SetFutureMilestoneCapacity(2))
+	require.Equal(t, []uint64{48, 64}, milestone.FutureMilestoneOrder)
+	require.True(t, milestone.checkFutureMilestoneInvariant())
+
+	// Lower the capacity behind the back of the service
+	milestone.MaxCapacity = 1
+
+	require.False(t, milestone.checkFutureMilestoneInvariant())
+}
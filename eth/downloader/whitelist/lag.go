@@ -0,0 +1,82 @@
+package whitelist
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// Metrics for collecting the lag of the future milestones behind the expected time of their block
+var FutureMilestoneLagTimer = metrics.NewRegisteredTimer("chain/milestone/future/lag", nil)
+
+// BlockTimeEstimator returns the expected time of the block of the given number,
+// or false if it can't be estimated
+type BlockTimeEstimator func(number uint64) (time.Time, bool)
+
+// futureMilestoneLag tracks the lag of the future milestones feed, i.e. how long
+// after the expected time of its block a future milestone arrives
+type futureMilestoneLag struct {
+	arrivals map[uint64]time.Time // Arrival time of the queued future milestones
+	total    time.Duration
+	count    int64
+}
+
+// recordFutureArrival records the arrival of the enqueued future milestone and its lag,
+// if the block time can be estimated. It should be called with the finality lock held.
+func (m *milestone) recordFutureArrival(num uint64) {
+	arrival := m.timeNow()
+
+	if m.lag.arrivals == nil {
+		m.lag.arrivals = make(map[uint64]time.Time)
+	}
+
+	m.lag.arrivals[num] = arrival
+
+	if m.BlockTimeEstimator == nil {
+		return
+	}
+
+	expected, ok := m.BlockTimeEstimator(num)
+	if !ok {
+		return
+	}
+
+	lag := arrival.Sub(expected)
+	if lag < 0 {
+		lag = 0
+	}
+
+	m.lag.total += lag
+	m.lag.count++
+
+	FutureMilestoneLagTimer.Update(lag)
+}
+
+// forgetFutureArrival drops the arrival time of a future milestone leaving the list.
+// It should be called with the finality lock held.
+func (m *milestone) forgetFutureArrival(num uint64) {
+	delete(m.lag.arrivals, num)
+}
+
+// FutureMilestoneArrival returns the arrival time of the queued future milestone
+func (m *milestone) FutureMilestoneArrival(num uint64) (time.Time, bool) {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	arrival, ok := m.lag.arrivals[num]
+
+	return arrival, ok
+}
+
+// AverageFutureMilestoneLag returns the average lag of the future milestones behind
+// the expected time of their block, or false if no lag was measured
+func (m *milestone) AverageFutureMilestoneLag() (time.Duration, bool) {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	if m.lag.count == 0 {
+		return 0, false
+	}
+
+	return m.lag.total / time.Duration(m.lag.count), true
+}
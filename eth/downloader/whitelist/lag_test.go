@@ -0,0 +1,65 @@
+package whitelist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// TestFutureMilestoneLag checks the recording of the arrival times and the feed lag
+func TestFutureMilestoneLag(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	genesis := time.Unix(1_700_000_000, 0)
+	clock := newFakeClock(genesis)
+
+	milestone.Clock = clock
+
+	_, ok := s.AverageFutureMilestoneLag()
+	require.False(t, ok)
+
+	// Without an estimator only the arrival is recorded
+	clock.Set(genesis.Add(10 * time.Second))
+	s.ProcessFutureMilestone(4, common.Hash{0x1})
+
+	arrival, ok := s.FutureMilestoneArrival(4)
+	require.True(t, ok)
+	require.Equal(t, clock.Now(), arrival)
+
+	_, ok = s.AverageFutureMilestoneLag()
+	require.False(t, ok)
+
+	// 2 seconds blocks
+	milestone.BlockTimeEstimator = func(number uint64) (time.Time, bool) {
+		return genesis.Add(time.Duration(number) * 2 * time.Second), true
+	}
+
+	clock.Set(genesis.Add(32*time.Second + 4*time.Second))
+	s.ProcessFutureMilestone(16, common.Hash{0x2})
+
+	clock.Set(genesis.Add(64*time.Second + 8*time.Second))
+	s.ProcessFutureMilestone(32, common.Hash{0x3})
+
+	// Duplicates aren't measured again
+	clock.Set(genesis.Add(time.Hour))
+	s.ProcessFutureMilestone(32, common.Hash{0x3})
+
+	lag, ok := s.AverageFutureMilestoneLag()
+	require.True(t, ok)
+	require.Equal(t, 6*time.Second, lag)
+
+	// The arrivals are dropped along with the future milestones
+	s.ProcessMilestone(16, common.Hash{0x2})
+
+	_, ok = s.FutureMilestoneArrival(16)
+	require.False(t, ok)
+
+	_, ok = s.FutureMilestoneArrival(32)
+	require.True(t, ok)
+}
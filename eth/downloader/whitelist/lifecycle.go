@@ -0,0 +1,120 @@
+package whitelist
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Reasons recorded when a lock gets released
+const (
+	UnlockReasonMilestoneProcessed = "milestone processed"
+	UnlockReasonFutureMilestone    = "future milestone processed"
+	UnlockReasonSuperseded         = "superseded by a new lock"
+	UnlockReasonSprintUnlocked     = "sprint unlocked"
+	UnlockReasonIDsRemoved         = "milestone ids removed"
+	UnlockReasonMaintenance        = "stale lock released by maintenance"
+	UnlockReasonPurged             = "milestone state purged"
+	UnlockReasonTimedOut           = "lock timed out"
+)
+
+// LockedMilestoneID is a milestone id voted during a lock lifecycle
+type LockedMilestoneID struct {
+	ID      string
+	AddedAt time.Time
+}
+
+// LockLifecycle is the timeline of a lock, from its engagement to its release
+type LockLifecycle struct {
+	Number       uint64
+	Hash         common.Hash
+	EngagedAt    time.Time
+	IDs          []LockedMilestoneID
+	UnlockedAt   time.Time // Zero while the lock is engaged
+	UnlockReason string
+}
+
+// LastLockLifecycle returns the timeline of the most recent lock, or false if
+// no lock was engaged since the start.
+func (m *milestone) LastLockLifecycle() (LockLifecycle, bool) {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	if m.lockLifecycle == nil {
+		return LockLifecycle{}, false
+	}
+
+	lifecycle := *m.lockLifecycle
+	lifecycle.IDs = append([]LockedMilestoneID{}, m.lockLifecycle.IDs...)
+
+	return lifecycle, true
+}
+
+// lockEngaged starts a new lock lifecycle.
+// It should be called with the finality lock held.
+func (m *milestone) lockEngaged(number uint64, hash common.Hash, milestoneId string) {
+	now := m.timeNow()
+
+	m.recordEvent(LockEngaged, number, hash)
+
+	m.lockLifecycle = &LockLifecycle{
+		Number:    number,
+		Hash:      hash,
+		EngagedAt: now,
+		IDs:       []LockedMilestoneID{{ID: milestoneId, AddedAt: now}},
+	}
+}
+
+// lockReleased ends the current lock lifecycle, if any.
+// It should be called with the finality lock held.
+func (m *milestone) lockReleased(reason string) {
+	if m.lockLifecycle == nil || !m.lockLifecycle.UnlockedAt.IsZero() {
+		return
+	}
+
+	m.lockLifecycle.UnlockedAt = m.timeNow()
+	m.lockLifecycle.UnlockReason = reason
+
+	m.recordEvent(LockReleased, m.lockLifecycle.Number, m.lockLifecycle.Hash)
+}
+
+// lockTimedOut checks whether the lock has been engaged for longer than LockTimeout.
+// A lock loaded from the db has no known age and never times out.
+// It should be called with the finality lock held.
+func (m *milestone) lockTimedOut() bool {
+	if m.LockTimeout <= 0 || !m.Locked || m.lockLifecycle == nil || !m.lockLifecycle.UnlockedAt.IsZero() {
+		return false
+	}
+
+	return m.timeNow().Sub(m.lockLifecycle.EngagedAt) > m.LockTimeout
+}
+
+// releaseTimedOutLock unlocks the sprint if the lock timed out, e.g. because the
+// voting round engaging it never completed, and returns whether it did.
+// It should be called with the finality lock held.
+func (m *milestone) releaseTimedOutLock() bool {
+	if !m.lockTimedOut() || m.checkPersistence() != nil {
+		return false
+	}
+
+	m.logger().Warn("Releasing the timed out milestone lock", "lockedMilestoneNumber", m.LockedMilestoneNumber,
+		"engagedAt", m.lockLifecycle.EngagedAt, "lockTimeout", m.LockTimeout)
+
+	return m.unlockSprint(m.LockedMilestoneNumber, UnlockReasonTimedOut)
+}
+
+// expireLock releases the timed out lock before a chain validation. The write lock
+// is only taken if the read lock found it timed out.
+func (m *milestone) expireLock() {
+	m.finality.RLock()
+	timedOut := m.lockTimedOut()
+	m.finality.RUnlock()
+
+	if !timedOut {
+		return
+	}
+
+	m.finality.Lock()
+	m.releaseTimedOutLock()
+	m.unlock()
+}
@@ -0,0 +1,207 @@
+package whitelist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// TestLastLockLifecycle checks the recorded timeline of the locks
+func TestLastLockLifecycle(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	_, ok := s.LastLockLifecycle()
+	require.False(t, ok, "expected no lifecycle before any lock")
+
+	start := time.Now()
+
+	// Vote and lock the sprint
+	milestone.LockMutex(10)
+	milestone.UnlockMutex(true, "milestoneID1", 10, common.Hash{1})
+
+	lifecycle, ok := s.LastLockLifecycle()
+	require.True(t, ok)
+	require.Equal(t, uint64(10), lifecycle.Number)
+	require.Equal(t, common.Hash{1}, lifecycle.Hash)
+	require.False(t, lifecycle.EngagedAt.Before(start))
+	require.Len(t, lifecycle.IDs, 1)
+	require.Equal(t, "milestoneID1", lifecycle.IDs[0].ID)
+	require.Equal(t, lifecycle.EngagedAt, lifecycle.IDs[0].AddedAt)
+	require.True(t, lifecycle.UnlockedAt.IsZero(), "expected the lock to be engaged")
+
+	// A vote without locking doesn't change the lifecycle
+	milestone.LockMutex(12)
+	milestone.UnlockMutex(false, "milestoneID2", 12, common.Hash{2})
+
+	lifecycle, _ = s.LastLockLifecycle()
+	require.Equal(t, uint64(10), lifecycle.Number)
+	require.True(t, lifecycle.UnlockedAt.IsZero())
+
+	// Whitelisting the milestone releases the lock
+	s.ProcessMilestone(10, common.Hash{1})
+
+	lifecycle, ok = s.LastLockLifecycle()
+	require.True(t, ok)
+	require.Equal(t, uint64(10), lifecycle.Number)
+	require.False(t, lifecycle.UnlockedAt.Before(lifecycle.EngagedAt))
+	require.Equal(t, UnlockReasonMilestoneProcessed, lifecycle.UnlockReason)
+
+	// Releasing again doesn't overwrite the timeline
+	milestone.UnlockSprint(10)
+
+	lifecycle, _ = s.LastLockLifecycle()
+	require.Equal(t, UnlockReasonMilestoneProcessed, lifecycle.UnlockReason)
+
+	// A new lock starts a new lifecycle, released by removing its ids
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID3", 20, common.Hash{3})
+	milestone.RemoveMilestoneID("milestoneID3")
+
+	lifecycle, _ = s.LastLockLifecycle()
+	require.Equal(t, uint64(20), lifecycle.Number)
+	require.Equal(t, "milestoneID3", lifecycle.IDs[0].ID)
+	require.Equal(t, UnlockReasonIDsRemoved, lifecycle.UnlockReason)
+}
+
+// TestMilestoneLockHeldTimer checks the timing of the voting window between LockMutex and UnlockMutex
+func TestMilestoneLockHeldTimer(t *testing.T) {
+	defer func(timer metrics.Timer, enabled bool) {
+		MilestoneLockHeldTimer, metrics.Enabled = timer, enabled
+	}(MilestoneLockHeldTimer, metrics.Enabled)
+
+	// The timers are no-op unless the metrics are enabled
+	metrics.Enabled = true
+	MilestoneLockHeldTimer = metrics.NewTimer()
+
+	s := NewMockService(rawdb.NewMemoryDatabase())
+
+	require.True(t, s.LockMutex(32))
+	time.Sleep(20 * time.Millisecond)
+	s.UnlockMutex(true, "milestoneID1", 32, common.Hash{0x2})
+
+	snapshot := MilestoneLockHeldTimer.Snapshot()
+	require.Equal(t, int64(1), snapshot.Count())
+	require.GreaterOrEqual(t, snapshot.Max(), int64(20*time.Millisecond))
+	require.Less(t, snapshot.Max(), int64(10*time.Second))
+
+	// A refused lock doesn't open a voting window
+	require.False(t, s.LockMutex(16))
+	s.UnlockMutex(false, "", 16, common.Hash{})
+
+	require.Equal(t, int64(1), MilestoneLockHeldTimer.Snapshot().Count())
+}
+
+// TestLockTimeout checks that a lock engaged for longer than LockTimeout is
+// released by the next chain validation or processed milestone
+func TestLockTimeout(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	milestone.Clock = clock
+
+	chainA := createMockChain(1, 20)
+	chainB := createMockChain(1, 20)
+
+	// Disabled by default
+	milestone.LockMutex(10)
+	milestone.UnlockMutex(true, "milestoneID1", 10, chainA[9].Hash())
+
+	clock.Advance(time.Hour)
+
+	res, err := s.IsValidChain(chainA[0], chainB[5:])
+	require.False(t, res)
+	require.ErrorIs(t, err, ErrReorgNotAllowed)
+	require.True(t, milestone.Locked)
+
+	// Released by the chain validation once timed out
+	milestone.LockTimeout = time.Minute
+
+	milestone.LockMutex(12)
+	milestone.UnlockMutex(true, "milestoneID2", 12, chainA[11].Hash())
+
+	clock.Advance(time.Minute)
+
+	res, _ = s.IsValidChain(chainA[0], chainB[5:])
+	require.False(t, res)
+	require.True(t, milestone.Locked)
+
+	clock.Advance(time.Second)
+
+	res, err = s.IsValidChain(chainA[0], chainB[5:])
+	require.True(t, res)
+	require.NoError(t, err)
+	require.False(t, milestone.Locked)
+	require.Empty(t, milestone.LockedMilestoneIDs)
+
+	lifecycle, ok := s.LastLockLifecycle()
+	require.True(t, ok)
+	require.Equal(t, UnlockReasonTimedOut, lifecycle.UnlockReason)
+
+	locked, number, hash, ids, err := rawdb.ReadLockField(db)
+	require.NoError(t, err)
+	require.False(t, locked)
+	require.Equal(t, uint64(12), number)
+	require.Equal(t, chainA[11].Hash(), hash)
+	require.Empty(t, ids)
+
+	// Released by a processed milestone below the lock once timed out
+	milestone.LockMutex(16)
+	milestone.UnlockMutex(true, "milestoneID3", 16, chainA[15].Hash())
+
+	clock.Advance(2 * time.Minute)
+
+	s.ProcessMilestone(8, chainA[7].Hash())
+	require.False(t, milestone.Locked)
+}
+
+// TestMilestoneIDLifetimeTimer checks that removing a milestone id records its
+// lifetime and counts it, only if it was present
+func TestMilestoneIDLifetimeTimer(t *testing.T) {
+	defer func(timer metrics.Timer, removed metrics.Meter, enabled bool) {
+		MilestoneIDLifetimeTimer, MilestoneIdsRemovedMeter, metrics.Enabled = timer, removed, enabled
+	}(MilestoneIDLifetimeTimer, MilestoneIdsRemovedMeter, metrics.Enabled)
+
+	// The timers are no-op unless the metrics are enabled
+	metrics.Enabled = true
+	MilestoneIDLifetimeTimer = metrics.NewTimer()
+	MilestoneIdsRemovedMeter = metrics.NewMeterForced()
+
+	defer MilestoneIdsRemovedMeter.Stop()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	clock := newFakeClock(time.Unix(1700000000, 0))
+	milestone.Clock = clock
+
+	require.True(t, s.LockMilestone(32, common.Hash{0x2}, "milestoneID1"))
+
+	clock.Advance(5 * time.Second)
+
+	// Absent id
+	s.RemoveMilestoneID("milestoneID2")
+	require.Equal(t, int64(0), MilestoneIdsRemovedMeter.Count())
+	require.Equal(t, int64(0), MilestoneIDLifetimeTimer.Snapshot().Count())
+
+	// Present id
+	s.RemoveMilestoneID("milestoneID1")
+	require.Equal(t, int64(1), MilestoneIdsRemovedMeter.Count())
+
+	snapshot := MilestoneIDLifetimeTimer.Snapshot()
+	require.Equal(t, int64(1), snapshot.Count())
+	require.Equal(t, int64(5*time.Second), snapshot.Max())
+
+	// Already removed
+	s.RemoveMilestoneID("milestoneID1")
+	require.Equal(t, int64(1), MilestoneIdsRemovedMeter.Count())
+	require.Equal(t, int64(1), MilestoneIDLifetimeTimer.Snapshot().Count())
+}
@@ -0,0 +1,124 @@
+package whitelist
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// MaintenanceReport lists what was cleaned by RunMaintenance
+type MaintenanceReport struct {
+	ExpiredIDs            []string      // Milestone ids older than MilestoneIDTTL
+	StaleFutureMilestones []uint64      // Future milestones at or below the whitelisted one
+	ReleasedLock          *MilestonePin // Stale lock released, if any
+}
+
+// IsEmpty checks whether nothing was cleaned
+func (r MaintenanceReport) IsEmpty() bool {
+	return len(r.ExpiredIDs) == 0 && len(r.StaleFutureMilestones) == 0 && r.ReleasedLock == nil
+}
+
+// RunMaintenance prunes the expired entries of the internal collections in a single
+// call, i.e. the milestone ids older than MilestoneIDTTL, the future milestones at
+// or below the whitelisted one and the stale lock, which is a lock at or below the
+// whitelisted milestone or engaged for longer than StaleLockAge. The changes are
+// persisted in a single batch.
+func (m *milestone) RunMaintenance(now time.Time) MaintenanceReport {
+	m.finality.Lock()
+	defer m.unlock()
+
+	var report MaintenanceReport
+
+	if err := m.checkPersistence(); err != nil {
+		m.logger().Warn("Refusing to run the maintenance", "err", err)
+		return report
+	}
+
+	// Expired milestone ids, the ids loaded from the db have no known age and never expire
+	if m.MilestoneIDTTL > 0 {
+		report.ExpiredIDs = m.expireMilestoneIDs(now, m.MilestoneIDTTL)
+	}
+
+	// Stale lock
+	if m.Locked {
+		stale := len(m.LockedMilestoneIDs) == 0 || (m.doExist && m.LockedMilestoneNumber <= m.Number)
+
+		if m.StaleLockAge > 0 && m.lockLifecycle != nil && m.lockLifecycle.UnlockedAt.IsZero() && now.Sub(m.lockLifecycle.EngagedAt) > m.StaleLockAge {
+			stale = true
+		}
+
+		if stale {
+			report.ReleasedLock = &MilestonePin{Number: m.LockedMilestoneNumber, Hash: m.LockedMilestoneHash}
+
+			m.Locked = false
+			m.purgeMilestoneIDsList()
+			m.lockReleased(UnlockReasonMaintenance)
+		}
+	}
+
+	// Stale future milestones
+	if m.doExist {
+		order := make([]uint64, 0, len(m.FutureMilestoneOrder))
+
+		for _, number := range m.FutureMilestoneOrder {
+			if number <= m.Number {
+				m.recordEvent(FutureDequeued, number, m.FutureMilestoneList[number])
+				delete(m.FutureMilestoneList, number)
+				m.forgetFutureArrival(number)
+				report.StaleFutureMilestones = append(report.StaleFutureMilestones, number)
+			} else {
+				order = append(order, number)
+			}
+		}
+
+		m.FutureMilestoneOrder = order
+		m.checkFutureMilestoneInvariant()
+		m.updateFutureOccupancy()
+	}
+
+	if report.IsEmpty() {
+		return report
+	}
+
+	m.logger().Info("Ran the milestone maintenance", "expiredIDs", report.ExpiredIDs,
+		"staleFutureMilestones", report.StaleFutureMilestones, "releasedLock", report.ReleasedLock != nil)
+
+	// The write-ahead log keeps the writes in order with the pending ones
+	if m.wal != nil {
+		m.writeLockField()
+		m.writeFutureMilestoneList()
+	} else {
+		m.writeMaintenanceBatch()
+	}
+
+	MilestoneIdsLengthMeter.Update(int64(len(m.LockedMilestoneIDs)))
+
+	if len(report.ExpiredIDs) > 0 || report.ReleasedLock != nil {
+		m.sendLockEvent()
+	}
+
+	return report
+}
+
+// writeMaintenanceBatch persists the lock state and the future milestones in a single
+// batch. It should be called with the finality lock held.
+func (m *milestone) writeMaintenanceBatch() {
+	m.version++
+
+	batch := m.db.NewBatch()
+
+	err := rawdb.WriteLockField(batch, m.Locked, m.LockedMilestoneNumber, m.LockedMilestoneHash, m.LockedMilestoneIDs)
+	if err == nil {
+		err = rawdb.WriteFutureMilestoneList(batch, m.FutureMilestoneOrder, m.FutureMilestoneList)
+	}
+
+	if err == nil {
+		err = batch.Write()
+	}
+
+	if err != nil {
+		m.logger().Error("Error in writing the maintained milestone data to db", "err", err)
+	}
+
+	m.recordPersistence(err)
+}
@@ -0,0 +1,89 @@
+package whitelist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// TestRunMaintenance checks the cleanup of the expired entries of every collection
+func TestRunMaintenance(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+	milestone.MilestoneIDTTL = time.Hour
+
+	require.True(t, s.RunMaintenance(now).IsEmpty(), "expected nothing to clean on an empty service")
+
+	s.ProcessMilestone(10, common.Hash{0x1})
+
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID1", 20, common.Hash{0x2})
+
+	// Age the first id and add a fresh one
+	milestone.LockedMilestoneIDs["milestoneID1"] = rawdb.MilestoneID{AddedAt: now.Add(-2 * time.Hour)}
+	milestone.LockedMilestoneIDs["milestoneID2"] = rawdb.MilestoneID{AddedAt: now}
+
+	// Seed future milestones below the whitelisted one
+	s.ProcessFutureMilestone(16, common.Hash{0x3})
+	milestone.FutureMilestoneOrder = []uint64{5, 8, 16}
+	milestone.FutureMilestoneList[5] = common.Hash{0x5}
+	milestone.FutureMilestoneList[8] = common.Hash{0x8}
+
+	report := s.RunMaintenance(now)
+	require.Equal(t, []string{"milestoneID1"}, report.ExpiredIDs)
+	require.Equal(t, []uint64{5, 8}, report.StaleFutureMilestones)
+	require.Nil(t, report.ReleasedLock, "expected the lock with a live id to be kept")
+
+	require.True(t, milestone.Locked)
+	require.Equal(t, []string{"milestoneID2"}, s.GetMilestoneIDsList())
+	require.Equal(t, []uint64{16}, milestone.FutureMilestoneOrder)
+
+	consistent, details, err := s.CheckPersistenceConsistency()
+	require.NoError(t, err)
+	require.True(t, consistent, details)
+
+	// A lock at or below the whitelisted milestone is stale, e.g. after loading both from the db
+	milestone.Number = 25
+	milestone.FutureMilestoneOrder = append(milestone.FutureMilestoneOrder, 24)
+	milestone.FutureMilestoneList[24] = common.Hash{0x24}
+
+	report = s.RunMaintenance(now)
+	require.Empty(t, report.ExpiredIDs)
+	require.Equal(t, []uint64{16, 24}, report.StaleFutureMilestones)
+	require.Equal(t, &MilestonePin{20, common.Hash{0x2}}, report.ReleasedLock)
+
+	require.False(t, milestone.Locked)
+	require.Empty(t, milestone.LockedMilestoneIDs)
+	require.Empty(t, milestone.FutureMilestoneOrder)
+	require.Empty(t, milestone.FutureMilestoneList)
+
+	lifecycle, ok := s.LastLockLifecycle()
+	require.True(t, ok)
+	require.Equal(t, UnlockReasonMaintenance, lifecycle.UnlockReason)
+
+	consistent, details, err = s.CheckPersistenceConsistency()
+	require.NoError(t, err)
+	require.True(t, consistent, details)
+
+	require.True(t, s.RunMaintenance(now).IsEmpty())
+
+	// A lock engaged for too long is stale
+	milestone.StaleLockAge = time.Minute
+
+	milestone.LockMutex(30)
+	milestone.UnlockMutex(true, "milestoneID3", 30, common.Hash{0x3})
+
+	require.True(t, s.RunMaintenance(now).IsEmpty())
+
+	report = s.RunMaintenance(now.Add(2 * time.Minute))
+	require.Equal(t, &MilestonePin{30, common.Hash{0x3}}, report.ReleasedLock)
+	require.False(t, milestone.Locked)
+}
@@ -1,10 +1,22 @@
 package whitelist
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/slices"
+
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/common/flags"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
 	"github.com/ethereum/go-ethereum/log"
 	"github.com/ethereum/go-ethereum/metrics"
 )
@@ -12,27 +24,344 @@ import (
 type milestone struct {
 	finality[*rawdb.Milestone]
 
-	LockedMilestoneNumber uint64              // Locked sprint number
-	LockedMilestoneHash   common.Hash         //Hash for the locked endBlock
-	Locked                bool                //
-	LockedMilestoneIDs    map[string]struct{} //list of milestone ids
+	LockedMilestoneNumber uint64                       // Locked sprint number
+	LockedMilestoneHash   common.Hash                  //Hash for the locked endBlock
+	Locked                bool                         //
+	LockedMilestoneIDs    map[string]rawdb.MilestoneID //list of milestone ids, along with when they were added
 
 	FutureMilestoneList  map[uint64]common.Hash // Future Milestone list
 	FutureMilestoneOrder []uint64               // Future Milestone Order
 	MaxCapacity          int                    //Capacity of future Milestone list
+
+	// EvictLowestOnFull makes a full future milestone list evict its lowest entry in
+	// favor of a higher incoming milestone, instead of dropping the incoming one
+	EvictLowestOnFull bool
+
+	// enabled toggles the milestone checks, a disabled milestone accepting every chain
+	// and peer. It's seeded from flags.Milestone at construction, see WithMilestoneEnabled.
+	enabled bool
+
+	// Defensive makes the chain validation work on copies of the numbers and hashes of
+	// the received headers, so that the caller mutating them can't affect the result
+	Defensive bool
+
+	// RequirePresentFutureMilestones rejects the chains spanning a future milestone
+	// without containing the block at its number (i.e. a sparse chain skipping it)
+	RequirePresentFutureMilestones bool
+
+	lockLifecycle *LockLifecycle // Timeline of the most recent lock
+
+	latestSource MilestoneSource // Source of the latest processed milestone
+
+	publisher *milestonePublisher // Publisher of the whitelisted milestones to an external queue
+
+	history              milestoneHistory  // History of the last whitelisted milestones
+	events               milestoneEventLog // Log of the state changes, see EventsSince
+	version              uint64            // Version of the state, see StateVersion
+	feedGaps             []FeedGap         // Last gaps detected in the milestone feed, see FeedGaps
+	numberUnchangedSince time.Time         // Time at which the whitelisted number last advanced
+	lastProcessedAt      time.Time         // Time at which a milestone was last processed
+	lockHeldSince        time.Time         // Time at which LockMutex opened the voting window, zero if none
+
+	// UnrelatedChainPolicy decides how to handle a chain starting beyond the
+	// block right after the current header, which is not an extension of it
+	UnrelatedChainPolicy UnrelatedChainPolicy
+
+	// LaggingHeadPolicy decides how to handle a chain while the current header is
+	// behind the whitelisted milestone, i.e. the node is lagging behind finality
+	LaggingHeadPolicy LaggingHeadPolicy
+
+	// SprintLength enables the check of the future milestones being aligned to a
+	// sprint boundary, while RejectMisalignedFutureMilestones rejects the misaligned
+	// ones instead of only warning. The sprint length also enables the detection of
+	// the gaps in the milestone feed, see FeedGaps. A zero sprint length disables both.
+	SprintLength                     uint64
+	RejectMisalignedFutureMilestones bool
+
+	// MilestoneIDTTL is the age after which RunMaintenance prunes a milestone id, while
+	// StaleLockAge is the age after which it releases a lock. Zero disables them.
+	MilestoneIDTTL time.Duration
+	StaleLockAge   time.Duration
+
+	// LockTimeout is the age after which a lock is released by the next chain
+	// validation or processed milestone, so that a voting round which never
+	// completed can't block the reorgs indefinitely. Zero disables it.
+	LockTimeout time.Duration
+
+	// BlockTimeEstimator enables the measurement of the future milestones feed lag
+	BlockTimeEstimator BlockTimeEstimator
+	lag                futureMilestoneLag
+
+	// PreferMilestoneOverTd skips the total difficulty comparison of any valid chain
+	// spanning a future milestone, even when the milestone block isn't part of it
+	PreferMilestoneOverTd bool
+
+	// RequireTipBeyondMilestone doesn't skip the total difficulty comparison for a
+	// future milestone at the tip of the chain, only for the ones strictly below it
+	RequireTipBeyondMilestone bool
+
+	// MinChainLenForFutureCheck skips the future milestone check for the chains shorter
+	// than it, e.g. single block announcements. It lets such a chain conflicting with a
+	// future milestone through, relying on the check of the longer chain importing it.
+	MinChainLenForFutureCheck int
+
+	// AllowLockedHashMismatch allows, with a warning, the chains containing the locked
+	// block with a different hash instead of rejecting them. It's meant for testnets.
+	AllowLockedHashMismatch bool
+
+	// ReorgGuardDepth is a safety margin below the locked sprint: the chains reorging
+	// the current chain from a fork point at most ReorgGuardDepth blocks below the
+	// locked number are refused by the validation, unless they contain the locked
+	// block with the locked hash. The chains extending the current chain, like the
+	// segments received during the sync, aren't affected. Zero disables it.
+	ReorgGuardDepth uint64
+
+	// MaxMilestoneIDs bounds the milestone ids of the lock, the least recently added
+	// ones beyond it being evicted (see evictMilestoneIDs). Zero or less uses
+	// DefaultMaxMilestoneIDs.
+	MaxMilestoneIDs int
+
+	// IDEvictionPolicy decides which milestone ids are dropped beyond MaxMilestoneIDs,
+	// e.g. when UnlockMutex adds one to a full lock. It evicts the oldest by default.
+	IDEvictionPolicy IDEvictionPolicy
+
+	// ReorgPolicy replaces the built-in locked sprint check (see IsReorgAllowed) of the
+	// chain validation, letting operators codify custom reorg rules. Nil keeps the
+	// built-in check.
+	ReorgPolicy ReorgPolicy
+
+	// ConfidenceMaxDistance and ConfidenceMaxAge are the distance of the head to the
+	// whitelisted milestone and the age of the milestone at which the canonical
+	// confidence drops to zero, see CanonicalConfidence. Zero uses the defaults.
+	ConfidenceMaxDistance uint64
+	ConfidenceMaxAge      time.Duration
+
+	// MaxReorgDepth is the maximum number of blocks of the current chain which can be
+	// reorged by a received chain, regardless of the milestones. Zero disables the cap.
+	MaxReorgDepth uint64
+
+	// PersistenceFailureThreshold is the number of consecutive persistence failures
+	// after which the mutating methods are refused with ErrPersistenceDegraded, until
+	// ResetPersistenceBreaker is called. Zero disables the breaker.
+	PersistenceFailureThreshold int
+	breaker                     persistenceBreaker
+
+	pruner *milestoneIDPruner // Background pruning of the milestone ids, see WithMilestoneIDPruning
+
+	wal *milestoneWAL // Write-ahead log of the persistence, nil if writing the main keys directly
+
+	scores peerScores // Finality-aware peer scores, see ValidateAndScore
+
+	// InstanceID tags the log lines and the latest milestone gauge of the service, to
+	// tell apart the services running in the same process. Empty keeps them untagged.
+	InstanceID string
+
+	futurePaused   atomic.Bool   // Whether the processing of future milestones is paused
+	suspendedUntil atomic.Uint64 // Block up to which the reorg protection is suspended, zero if not
+
+	validationHooks []ChainValidator // Custom validations run after the built-in checks
+
+	milestoneCallbacks []MilestoneCallback // Callbacks invoked on each processed milestone, see SubscribeMilestone
+
+	// Clock is the source of the current time of the time based logic, e.g. the ages
+	// of the milestones and of the milestone ids. Nil uses the system time. The
+	// durations of the validation steps are always measured with the system time.
+	Clock Clock
+
+	chainCopyHook func() // Testing hook invoked after the defensive copy of the chain
+
+	updateFeed    event.Feed    // Feed of whitelisted milestone updates
+	lockFeed      event.Feed    // Feed of lock state changes
+	pendingEvents []func()      // Feed events queued with the finality lock held, see unlock
+	lastEvents    chan struct{} // Closed once the last queued events are sent, see unlock
 }
 
-type milestoneService interface {
-	finalityService
+// ChainValidator is a custom validation of a chain, see RegisterValidationHook
+type ChainValidator func(currentHeader *types.Header, chain []*types.Header) (bool, error)
+
+// LockState is the locked sprint passed to a ReorgPolicy
+type LockState struct {
+	Locked bool
+	Number uint64
+	Hash   common.Hash
+	IDs    []string
+}
+
+// MilestoneState is the whitelisted milestone passed to a ReorgPolicy
+type MilestoneState struct {
+	DoExist bool
+	Number  uint64
+	Hash    common.Hash
+}
+
+// ReorgPolicy decides whether the chain is allowed to reorg the current one, along
+// with the reason of a refusal. It receives copies of the chain and of the state.
+type ReorgPolicy func(chain []*types.Header, locked LockState, whitelisted MilestoneState) (bool, string)
+
+// UnrelatedChainPolicy is the handling of a chain which isn't related to the current header
+type UnrelatedChainPolicy int
+
+const (
+	// UnrelatedChainAllow validates the chain as any other one
+	UnrelatedChainAllow UnrelatedChainPolicy = iota
+	// UnrelatedChainReject rejects the chain with ErrUnrelatedChain
+	UnrelatedChainReject
+	// UnrelatedChainRequestMore rejects the chain with ErrMissingAncestors, asking the
+	// caller to retry with the headers connecting the chain to the current header
+	UnrelatedChainRequestMore
+)
+
+// LaggingHeadPolicy is the handling of a chain while the current header is behind
+// the whitelisted milestone
+type LaggingHeadPolicy int
+
+const (
+	// LaggingHeadAllow validates the chain as any other one. Only the blocks up to the
+	// current header are checked against the whitelisted milestone, so a chain reaching
+	// it isn't checked against its hash.
+	LaggingHeadAllow LaggingHeadPolicy = iota
+	// LaggingHeadAdvanceOnly only accepts the chains moving toward the whitelisted
+	// milestone, i.e. going beyond the current header and carrying the whitelisted
+	// hash if they reach its number. The other ones are rejected with ErrLaggingHead.
+	LaggingHeadAdvanceOnly
+)
+
+// MilestoneSource is the origin of a processed milestone
+type MilestoneSource int
+
+const (
+	MilestoneSourceUnknown MilestoneSource = iota
+	MilestoneSourceHeimdall
+	MilestoneSourceP2P
+	MilestoneSourceManual
+)
+
+func (s MilestoneSource) String() string {
+	switch s {
+	case MilestoneSourceHeimdall:
+		return "heimdall"
+	case MilestoneSourceP2P:
+		return "p2p"
+	case MilestoneSourceManual:
+		return "manual"
+	default:
+		return "unknown"
+	}
+}
+
+// MilestonePin is a block number and hash pinned by a milestone
+type MilestonePin struct {
+	Number uint64
+	Hash   common.Hash
+}
+
+// MilestoneUpdateEvent is posted when a milestone gets whitelisted, or when the
+// whitelisted milestone is purged or replaced, e.g. by Restore
+type MilestoneUpdateEvent struct {
+	Number uint64
+	Hash   common.Hash
+	Purged bool // Whether no milestone is whitelisted anymore
+}
 
+// MilestoneLockEvent is posted whenever the lock state of the milestone changes
+type MilestoneLockEvent struct {
+	Locked bool
+	Number uint64
+	Hash   common.Hash
+	IDs    []string
+}
+
+// milestoneReader is the read-only view of the milestone service state
+type milestoneReader interface {
 	GetMilestoneIDsList() []string
+	MilestoneIDsForLockedNumber(num uint64) []string
+	PredictNextMilestoneNumber() (uint64, bool)
+	LastMilestoneGap() (uint64, bool)
+	FutureMilestonesSorted() []MilestonePin
+	ExtendsFinalizedChain(chain []*types.Header) bool
+	CurrentMilestoneStaleness(now time.Time) time.Duration
+	LatestMilestoneWithAge(now time.Time) (uint64, common.Hash, time.Duration, bool)
+	CanonicalConfidence(head uint64, now time.Time) float64
+	ReorgFloor() (uint64, bool)
+	CanPruneBelow(block uint64) bool
+	IsFinalized(number uint64, hash common.Hash) bool
+	CanRewind(toBlock uint64) (bool, string)
+	LatestMilestoneSource() MilestoneSource
+	LastLockLifecycle() (LockLifecycle, bool)
+	RecentMilestones() []MilestoneRecord
+	ProtectedBlocks() []MilestonePin
+	IsPersistenceDegraded() bool
+	StateFingerprint() [32]byte
+	MilestoneStatus() MilestoneStatus
+	EventsSince(seq uint64) []MilestoneEvent
+	StateVersion() uint64
+	FeedGaps() []FeedGap
+	FutureMilestoneArrival(num uint64) (time.Time, bool)
+	AverageFutureMilestoneLag() (time.Duration, bool)
+	Healthy() bool
+	GetFutureMilestone(number uint64) (common.Hash, bool)
+	FutureMilestoneCount() int
+}
+
+type milestoneService interface {
+	finalityService
+	milestoneReader
+
+	CheckPeer(fetchHeadersByNumber func(number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (PeerVerdict, error)
+	CheckPeerCtx(ctx context.Context, fetchHeadersByNumber func(ctx context.Context, number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (PeerVerdict, error)
+	DrainFutureMilestones() []MilestonePin
+	IsValidChainLockOnly(currentHeader *types.Header, chain []*types.Header) (bool, error)
+	RegisterValidationHook(hook ChainValidator)
+	SuspendReorgProtectionUntil(block uint64)
+	SetEventPublisher(publisher EventPublisher)
+	SubscribeMilestone(callback MilestoneCallback)
+	PromoteFutureMilestone(num uint64) error
+	ProcessFrom(block uint64, hash common.Hash, source MilestoneSource)
+	TryProcess(block uint64, hash common.Hash) bool
+	ReconcileMilestoneIDs(valid []string)
+	CompareAndSetMilestone(expectedNum uint64, newNum uint64, newHash common.Hash) bool
+	Fastforward(number uint64, hash common.Hash) bool
+	ValidateChainDetailed(currentHeader *types.Header, chain []*types.Header) (bool, bool, []CheckedPin, ReorgRejectReason, error)
+	VerifyChain(currentHeader *types.Header, chain []*types.Header) (ChainVerdict, error)
+	TraceValidation(currentHeader *types.Header, chain []*types.Header) (DecisionTrace, bool, bool, error)
+	ValidateChainVerbose(currentHeader *types.Header, chain []*types.Header) ([]string, error)
 	RemoveMilestoneID(milestoneId string)
+	PruneStaleMilestoneIDs(maxAge time.Duration) []string
+	StopMilestoneIDPruning()
+	ResetPersistenceBreaker()
+	EnableWriteAheadLog()
+	DisableWriteAheadLog()
+	SnapshotValidator() (*Validator, uint64)
+	Snapshot() MilestoneSnapshot
+	Restore(snapshot MilestoneSnapshot)
+	CheckPersistenceConsistency() (bool, string, error)
+	RunMaintenance(now time.Time) MaintenanceReport
 	LockMutex(endBlockNum uint64) bool
+	LockMilestone(endBlockNum uint64, endBlockHash common.Hash, milestoneId string) bool
 	UnlockMutex(doLock bool, milestoneId string, endBlockNum uint64, endBlockHash common.Hash)
-	UnlockSprint(endBlockNum uint64)
+	UnlockSprint(endBlockNum uint64) bool
 	ProcessFutureMilestone(num uint64, hash common.Hash)
+	ProcessFutureMilestones(milestones []MilestonePin)
+	PurgeAll() error
+	ExportState() MilestoneSnapshot
+	ImportState(state MilestoneSnapshot) error
+	PreviewProcessFutureMilestone(num uint64, hash common.Hash) (string, []uint64)
+	SetFutureMilestoneCapacity(capacity int) error
+	PauseFutureMilestones()
+	ResumeFutureMilestones()
+	CheckFutureMilestoneChainConsistency(headerByNumber func(uint64) (*types.Header, bool)) []uint64
+	CheckHeadersAgainstFutureMilestones(headers []*types.Header) []uint64
+	SubscribeMilestoneUpdates(ch chan<- MilestoneUpdateEvent) event.Subscription
+	SubscribeMilestoneLocks(ch chan<- MilestoneLockEvent) event.Subscription
 }
 
+// DefaultFutureMilestoneCapacity is the default capacity of the future milestone list
+const DefaultFutureMilestoneCapacity = 10
+
+// maxBlockNumber is the highest block number accepted for locking. Block numbers
+// are converted to int64 in several places, so anything above can't be legit.
+const maxBlockNumber = math.MaxInt64
+
 var (
 	//Metrics for collecting the whitelisted milestone number
 	whitelistedMilestoneMeter = metrics.NewRegisteredGauge("chain/milestone/latest", nil)
@@ -40,26 +369,86 @@ var (
 	//Metrics for collecting the future milestone number
 	FutureMilestoneMeter = metrics.NewRegisteredGauge("chain/milestone/future", nil)
 
+	//Metrics for collecting the number of queued future milestones, and their ratio to the capacity in basis points
+	FutureMilestoneOccupancyGauge      = metrics.NewRegisteredGauge("chain/milestone/future/occupancy", nil)
+	FutureMilestoneOccupancyRatioGauge = metrics.NewRegisteredGauge("chain/milestone/future/occupancy/ratio", nil)
+
 	//Metrics for collecting the length of the MilestoneIds map
 	MilestoneIdsLengthMeter = metrics.NewRegisteredGauge("chain/milestone/idslength", nil)
 
+	//Metrics for collecting the rate of milestoneIDs added to and removed from the map
+	MilestoneIdsAddedMeter   = metrics.NewRegisteredMeter("chain/milestone/ids/added", nil)
+	MilestoneIdsRemovedMeter = metrics.NewRegisteredMeter("chain/milestone/ids/removed", nil)
+
 	//Metrics for collecting the number of valid chains received
 	MilestoneChainMeter = metrics.NewRegisteredMeter("chain/milestone/isvalidchain", nil)
 
 	//Metrics for collecting the number of valid peers received
 	MilestonePeerMeter = metrics.NewRegisteredMeter("chain/milestone/isvalidpeer", nil)
+
+	//Metrics for collecting the number of future milestones dropped as the list is full
+	DroppedFutureMilestoneCounter = metrics.NewRegisteredCounter("chain/milestone/future/dropped", nil)
+
+	//Metrics for collecting the number of future milestones not aligned to a sprint boundary
+	MisalignedFutureMilestoneCounter = metrics.NewRegisteredCounter("chain/milestone/future/misaligned", nil)
+
+	//Metrics for collecting the number of processed milestones per source
+	MilestoneSourceCounters = map[MilestoneSource]metrics.Counter{
+		MilestoneSourceUnknown:  metrics.NewRegisteredCounter("chain/milestone/source/unknown", nil),
+		MilestoneSourceHeimdall: metrics.NewRegisteredCounter("chain/milestone/source/heimdall", nil),
+		MilestoneSourceP2P:      metrics.NewRegisteredCounter("chain/milestone/source/p2p", nil),
+		MilestoneSourceManual:   metrics.NewRegisteredCounter("chain/milestone/source/manual", nil),
+	}
+
+	//Metrics for collecting the number of milestones processed at or below the whitelisted one
+	OutOfOrderMilestoneCounter = metrics.NewRegisteredCounter("chain/milestone/process/outoforder", nil)
+
+	//Metrics for collecting the number of corrupted milestone records found in the db
+	MilestoneCorruptedDataCounter = metrics.NewRegisteredCounter("chain/milestone/db/corrupted", nil)
+
+	//Metrics for collecting the number of chains validated with the current header behind the whitelisted milestone
+	LaggingHeadCounter = metrics.NewRegisteredCounter("chain/milestone/lagginghead", nil)
+
+	//Metrics for collecting the number of gaps detected in the milestone feed
+	MilestoneFeedGapCounter = metrics.NewRegisteredCounter("chain/milestone/feedgaps", nil)
+
+	//Metrics for collecting the number of milestone ids received again with a conflicting hash
+	MilestoneIDConflictCounter = metrics.NewRegisteredCounter("chain/milestone/conflict", nil)
+
+	//Metrics for collecting how long the lock is held between LockMutex and UnlockMutex during voting
+	MilestoneLockHeldTimer = metrics.NewRegisteredTimer("chain/milestone/lock/held", nil)
+
+	//Metrics for collecting how long the milestone ids live, from their addition to their removal
+	MilestoneIDLifetimeTimer = metrics.NewRegisteredTimer("chain/milestone/ids/lifetime", nil)
+
+	//Metrics for collecting the number of blocks the current header is ahead of the whitelisted milestone
+	MilestoneFinalityLagGauge = metrics.NewRegisteredGauge("chain/milestone/lag", nil)
 )
 
+// logger returns the logger of the service, tagged with the instance id if set
+func (m *milestone) logger() log.Logger {
+	if m.InstanceID == "" {
+		return log.Root()
+	}
+
+	return log.New("instance", m.InstanceID)
+}
+
 // IsValidChain checks the validity of chain by comparing it
 // against the local milestone entries
+//
+// The headers are owned by the caller and are read throughout the call, hence
+// they must not be mutated concurrently unless the Defensive mode is enabled.
 func (m *milestone) IsValidChain(currentHeader *types.Header, chain []*types.Header) (bool, error) {
 	//Checking for the milestone flag
-	if !flags.Milestone {
+	if !m.enabled {
 		return true, nil
 	}
 
-	m.finality.RLock()
-	defer m.finality.RUnlock()
+	// An empty chain is trivially valid
+	if len(chain) == 0 {
+		return true, nil
+	}
 
 	var isValid bool = false
 
@@ -71,245 +460,1794 @@ func (m *milestone) IsValidChain(currentHeader *types.Header, chain []*types.Hea
 		}
 	}()
 
-	res, err := m.finality.IsValidChain(currentHeader, chain)
+	m.expireLock()
 
-	if !res {
-		isValid = false
-		return isValid, err
+	m.finality.RLock()
+
+	currentHeader, chain, release := m.prepareChain(currentHeader, chain)
+	defer release()
+
+	isValid, _, err := m.validateChain(currentHeader, chain, nil)
+	hooks := m.validationHooks
+
+	if m.doExist && currentHeader != nil && currentHeader.Number.Uint64() < m.Number {
+		LaggingHeadCounter.Inc(1)
 	}
 
-	if m.Locked && !m.IsReorgAllowed(chain, m.LockedMilestoneNumber, m.LockedMilestoneHash) {
-		isValid = false
-		return isValid, nil
+	m.updateFinalityLag(currentHeader)
+
+	m.finality.RUnlock()
+
+	// The hooks run without the lock, so that they can call back into the service
+	for i := 0; isValid && i < len(hooks); i++ {
+		isValid, err = hooks[i](currentHeader, chain)
+		isValid = isValid && err == nil
 	}
 
-	if !m.IsFutureMilestoneCompatible(chain) {
-		isValid = false
-		return isValid, nil
+	return isValid, err
+}
+
+// updateFinalityLag updates the number of blocks the current header is ahead of the
+// whitelisted milestone, which is zero without a milestone or when the milestone is
+// ahead. It should be called with the finality lock held.
+func (m *milestone) updateFinalityLag(currentHeader *types.Header) {
+	var lag uint64
+
+	if m.doExist && currentHeader != nil && currentHeader.Number.Uint64() > m.Number {
+		lag = currentHeader.Number.Uint64() - m.Number
 	}
 
-	isValid = true
+	MilestoneFinalityLagGauge.Update(int64(min(lag, maxBlockNumber)))
+}
+
+// RegisterValidationHook registers a custom validation run by IsValidChain after
+// the built-in checks, for the chains passing them. A hook returning false or an
+// error rejects the chain. The hooks are run in the order of their registration,
+// without holding the lock of the service.
+func (m *milestone) RegisterValidationHook(hook ChainValidator) {
+	m.finality.Lock()
+	defer m.unlock()
 
-	return isValid, nil
+	// Copy on write, as the hooks are run outside of the lock
+	hooks := make([]ChainValidator, 0, len(m.validationHooks)+1)
+	hooks = append(hooks, m.validationHooks...)
+	m.validationHooks = append(hooks, hook)
 }
 
-// IsValidPeer checks if the chain we're about to receive from a peer is valid or not
-// in terms of reorgs. We won't reorg beyond the last bor finality submitted to mainchain.
-func (m *milestone) IsValidPeer(fetchHeadersByNumber func(number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (bool, error) {
-	if !flags.Milestone {
-		return true, nil
+// validateChain runs the checks of IsValidChain and returns the reason of the
+// rejection, if any. Each check is recorded in the trace, if not nil.
+// It should be called with the finality lock held.
+func (m *milestone) validateChain(currentHeader *types.Header, chain []*types.Header, trace *DecisionTrace) (bool, ReorgRejectReason, error) {
+	// An empty chain is trivially valid
+	if len(chain) == 0 {
+		return true, ReorgRejectNone, nil
 	}
 
-	res, err := m.finality.IsValidPeer(fetchHeadersByNumber)
+	start := time.Now()
+	err := m.checkUnrelatedChain(currentHeader, chain)
+	trace.record(TraceCheckUnrelatedChain, start, err == nil, err)
 
-	if res {
-		MilestonePeerMeter.Mark(int64(1))
-	} else {
-		MilestonePeerMeter.Mark(int64(-1))
+	if err != nil {
+		return false, ReorgRejectUnrelatedChain, err
 	}
 
-	return res, err
-}
+	start = time.Now()
+	err = m.checkReorgDepth(currentHeader, chain)
+	trace.record(TraceCheckReorgDepth, start, err == nil, err)
 
-func (m *milestone) Process(block uint64, hash common.Hash) {
-	m.finality.Lock()
-	defer m.finality.Unlock()
+	if err != nil {
+		return false, ReorgRejectTooDeep, err
+	}
 
-	m.finality.Process(block, hash)
+	if !m.reorgProtectionSuspended(currentHeader, chain) {
+		start = time.Now()
+		err = m.checkLaggingHead(currentHeader, chain)
+		trace.record(TraceCheckLaggingHead, start, err == nil, err)
 
-	for i := 0; i < len(m.FutureMilestoneOrder); i++ {
-		if m.FutureMilestoneOrder[i] <= block {
-			m.dequeueFutureMilestone()
-		} else {
-			break
+		if err != nil {
+			return false, ReorgRejectLaggingHead, err
+		}
+
+		start = time.Now()
+		res, err := m.finality.IsValidChain(currentHeader, chain)
+
+		if !res && err == nil {
+			err = ErrFinalityMismatch
+		}
+
+		trace.record(TraceCheckWhitelisted, start, res, err)
+
+		if !res {
+			return false, ReorgRejectWhitelisted, err
+		}
+
+		start = time.Now()
+		err = m.checkReorgAllowed(currentHeader, chain)
+		trace.record(TraceCheckLocked, start, err == nil, err)
+
+		if err != nil {
+			return false, ReorgRejectLocked, err
 		}
 	}
 
-	whitelistedMilestoneMeter.Update(int64(block))
+	start = time.Now()
+	res, _, info := m.FutureMilestoneCompatibility(chain)
+	trace.record(TraceCheckFutureMilestones, start, res, rejectionError(res, ErrFutureMilestoneMismatch))
+
+	if !res {
+		m.logger().Debug("Chain conflicts with a future milestone", "number", info.Number, "expected", info.Expected,
+			"actual", info.Actual, "present", info.Present)
+
+		return false, ReorgRejectFutureMilestone, ErrFutureMilestoneMismatch
+	}
 
-	m.UnlockSprint(block)
+	return true, ReorgRejectNone, nil
 }
 
-// This function will Lock the mutex at the time of voting
-// fixme: get rid of it
-func (m *milestone) LockMutex(endBlockNum uint64) bool {
-	m.finality.Lock()
+// rejectionError returns the error of a failed check, nil if it passed
+func rejectionError(passed bool, err error) error {
+	if passed {
+		return nil
+	}
 
-	if m.doExist && endBlockNum <= m.Number { //if endNum is less than whitelisted milestone, then we won't lock the sprint
-		log.Debug("endBlockNumber is less than or equal to latesMilestoneNumber", "endBlock Number", endBlockNum, "LatestMilestone Number", m.Number)
+	return err
+}
+
+// ExtendsFinalizedChain checks whether the chain builds on top of the whitelisted
+// milestone, i.e. it contains the whitelisted block and goes beyond it. It's false
+// when there's no whitelisted milestone.
+func (m *milestone) ExtendsFinalizedChain(chain []*types.Header) bool {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	if !m.doExist || len(chain) == 0 || chain[len(chain)-1].Number.Uint64() <= m.Number {
 		return false
 	}
 
-	if m.Locked && endBlockNum < m.LockedMilestoneNumber {
-		log.Debug("endBlockNum is less than locked milestone number", "endBlock Number", endBlockNum, "Locked Milestone Number", m.LockedMilestoneNumber)
-		return false
+	for _, header := range chain {
+		if header.Number.Uint64() == m.Number {
+			return header.Hash() == m.Hash
+		}
 	}
 
-	return true
+	return false
 }
 
-// This function will unlock the mutex locked in LockMutex
-// fixme: get rid of it
-func (m *milestone) UnlockMutex(doLock bool, milestoneId string, endBlockNum uint64, endBlockHash common.Hash) {
-	m.Locked = m.Locked || doLock
+// IsValidChainLockOnly is a lighter variant of IsValidChain which only applies the
+// whitelisted milestone and the locked sprint checks, skipping the future milestone
+// compatibility check. It doesn't update the chain validation metrics.
+func (m *milestone) IsValidChainLockOnly(currentHeader *types.Header, chain []*types.Header) (bool, error) {
+	//Checking for the milestone flag
+	if !m.enabled {
+		return true, nil
+	}
 
-	if doLock {
-		m.UnlockSprint(m.LockedMilestoneNumber)
-		m.Locked = true
-		m.LockedMilestoneHash = endBlockHash
-		m.LockedMilestoneNumber = endBlockNum
-		m.LockedMilestoneIDs[milestoneId] = struct{}{}
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	if len(chain) == 0 {
+		return true, nil
 	}
 
-	err := rawdb.WriteLockField(m.db, m.Locked, m.LockedMilestoneNumber, m.LockedMilestoneHash, m.LockedMilestoneIDs)
-	if err != nil {
-		log.Error("Error in writing lock data of milestone to db", "err", err)
+	currentHeader, chain, release := m.prepareChain(currentHeader, chain)
+	defer release()
+
+	res, err := m.finality.IsValidChain(currentHeader, chain)
+	if !res {
+		if err == nil {
+			err = ErrFinalityMismatch
+		}
+
+		return false, err
 	}
 
-	milestoneIDLength := int64(len(m.LockedMilestoneIDs))
-	MilestoneIdsLengthMeter.Update(milestoneIDLength)
+	if err := m.checkReorgAllowed(currentHeader, chain); err != nil {
+		return false, err
+	}
 
-	m.finality.Unlock()
+	return true, nil
 }
 
-// This function will unlock the locked sprint
-func (m *milestone) UnlockSprint(endBlockNum uint64) {
-	if endBlockNum < m.LockedMilestoneNumber {
-		return
+// checkLaggingHead detects a current header behind the whitelisted milestone and
+// handles the chain according to the policy.
+// It should be called with the finality lock held.
+func (m *milestone) checkLaggingHead(currentHeader *types.Header, chain []*types.Header) error {
+	if !m.doExist || currentHeader == nil || len(chain) == 0 || currentHeader.Number.Uint64() >= m.Number {
+		return nil
 	}
 
-	m.Locked = false
-	m.purgeMilestoneIDsList()
+	current, last := currentHeader.Number.Uint64(), chain[len(chain)-1].Number.Uint64()
 
-	err := rawdb.WriteLockField(m.db, m.Locked, m.LockedMilestoneNumber, m.LockedMilestoneHash, m.LockedMilestoneIDs)
+	if m.LaggingHeadPolicy != LaggingHeadAdvanceOnly {
+		m.logger().Debug("Validating chain with the current header behind the whitelisted milestone", "current", current, "whitelisted", m.Number, "last", last)
+		return nil
+	}
 
-	if err != nil {
-		log.Error("Error in writing lock data of milestone to db", "err", err)
+	if last <= current {
+		m.logger().Debug("Rejecting chain not advancing toward the whitelisted milestone", "current", current, "whitelisted", m.Number, "last", last)
+		return fmt.Errorf("%w: chain ends at %d, current header %d, whitelisted milestone %d", ErrLaggingHead, last, current, m.Number)
 	}
+
+	for _, header := range chain {
+		if header.Number.Uint64() == m.Number && headerHash(header) != m.Hash {
+			m.logger().Debug("Rejecting chain diverging from the whitelisted milestone", "current", current, "whitelisted", m.Number)
+			return fmt.Errorf("%w: hash mismatch at the whitelisted milestone %d", ErrLaggingHead, m.Number)
+		}
+	}
+
+	return nil
 }
 
-// This function will remove the stored milestoneID
-func (m *milestone) RemoveMilestoneID(milestoneId string) {
-	m.finality.Lock()
+// checkUnrelatedChain detects a chain whose first block is beyond the block right
+// after the current header, i.e. the chain can't be an extension or a reorg of the
+// current one within the provided headers, and handles it according to the policy.
+func (m *milestone) checkUnrelatedChain(currentHeader *types.Header, chain []*types.Header) error {
+	if currentHeader == nil || len(chain) == 0 {
+		return nil
+	}
 
-	delete(m.LockedMilestoneIDs, milestoneId)
+	first, current := chain[0].Number.Uint64(), currentHeader.Number.Uint64()
+	if first <= current+1 {
+		return nil
+	}
 
-	if len(m.LockedMilestoneIDs) == 0 {
-		m.Locked = false
+	switch m.UnrelatedChainPolicy {
+	case UnrelatedChainReject:
+		m.logger().Debug("Rejecting chain unrelated to the current header", "current", current, "first", first)
+		return fmt.Errorf("%w: current header %d, chain starts at %d", ErrUnrelatedChain, current, first)
+	case UnrelatedChainRequestMore:
+		m.logger().Debug("Requesting the missing ancestors of the chain", "current", current, "first", first)
+		return fmt.Errorf("%w: headers %d to %d", ErrMissingAncestors, current+1, first-1)
+	default:
+		m.logger().Debug("Validating chain unrelated to the current header", "current", current, "first", first)
+		return nil
 	}
+}
 
-	err := rawdb.WriteLockField(m.db, m.Locked, m.LockedMilestoneNumber, m.LockedMilestoneHash, m.LockedMilestoneIDs)
-	if err != nil {
-		log.Error("Error in writing lock data of milestone to db", "err", err)
+// checkReorgDepth rejects the chain if it reorgs more than MaxReorgDepth blocks of the
+// current chain. The fork point is the parent of the first block of the chain, which is
+// the latest common ancestor of the received and the current chain.
+func (m *milestone) checkReorgDepth(currentHeader *types.Header, chain []*types.Header) error {
+	if m.MaxReorgDepth == 0 || currentHeader == nil || len(chain) == 0 {
+		return nil
 	}
 
-	m.finality.Unlock()
+	first, current := chain[0].Number.Uint64(), currentHeader.Number.Uint64()
+	if first == 0 || first > current {
+		// Nothing of the current chain gets replaced
+		return nil
+	}
+
+	forkPoint := first - 1
+	if depth := current - forkPoint; depth > m.MaxReorgDepth {
+		m.logger().Debug("Rejecting too deep reorg", "current", current, "forkPoint", forkPoint, "depth", depth, "max", m.MaxReorgDepth)
+		return fmt.Errorf("%w: depth %d, max %d", ErrReorgTooDeep, depth, m.MaxReorgDepth)
+	}
+
+	return nil
 }
 
-// This will check whether the incoming chain matches the locked sprint hash
-func (m *milestone) IsReorgAllowed(chain []*types.Header, lockedMilestoneNumber uint64, lockedMilestoneHash common.Hash) bool {
-	if chain[len(chain)-1].Number.Uint64() <= lockedMilestoneNumber { //Can't reorg if the end block of incoming
-		return false //chain is less than locked sprint number
+// prepareChain returns the headers to be used by the validation, which are
+// copies of the received ones in the Defensive mode, along with the release of
+// their pinned hashes to be called once the validation is done.
+func (m *milestone) prepareChain(currentHeader *types.Header, chain []*types.Header) (*types.Header, []*types.Header, func()) {
+	if !m.Defensive {
+		return currentHeader, chain, func() {}
 	}
 
-	for i := 0; i < len(chain); i++ {
-		if chain[i].Number.Uint64() == lockedMilestoneNumber {
-			return chain[i].Hash() == lockedMilestoneHash
+	currentHeader, chain = copyChain(currentHeader, chain)
+
+	// The hash is computed from every header field, some of which are still shared
+	// with the caller, hence it's pinned now for the rest of the validation
+	for _, header := range chain {
+		pinnedHashes.Store(header, header.Hash())
+	}
+
+	if m.chainCopyHook != nil {
+		m.chainCopyHook()
+	}
+
+	return currentHeader, chain, func() {
+		for _, header := range chain {
+			pinnedHashes.Delete(header)
 		}
 	}
+}
 
-	return true
+// pinnedHashes maps the header copies of the Defensive mode to their hash, computed
+// once when the copy is made, see prepareChain
+var pinnedHashes sync.Map
+
+// headerHash returns the hash of the header used by the validation, the pinned one
+// for the copies of the Defensive mode
+func headerHash(header *types.Header) common.Hash {
+	if hash, ok := pinnedHashes.Load(header); ok {
+		return hash.(common.Hash)
+	}
+
+	return header.Hash()
 }
 
-// This will return the list of milestoneIDs stored.
-func (m *milestone) GetMilestoneIDsList() []string {
-	m.finality.RLock()
-	defer m.finality.RUnlock()
+// copyChain returns copies of the current header and the chain, so that the
+// validation is immune to the caller reassigning the header fields.
+func copyChain(currentHeader *types.Header, chain []*types.Header) (*types.Header, []*types.Header) {
+	if currentHeader != nil {
+		currentHeader = copyHeader(currentHeader)
+	}
 
-	// fixme: use generics :)
-	keys := make([]string, 0, len(m.LockedMilestoneIDs))
-	for key := range m.LockedMilestoneIDs {
-		keys = append(keys, key)
+	copied := make([]*types.Header, len(chain))
+	for i, header := range chain {
+		copied[i] = copyHeader(header)
 	}
 
-	return keys
+	return currentHeader, copied
 }
 
-// This is remove the milestoneIDs stored in the list.
-func (m *milestone) purgeMilestoneIDsList() {
-	m.LockedMilestoneIDs = make(map[string]struct{})
+// copyHeader copies the header with its own number, the other fields pointing to
+// the values of the original
+func copyHeader(header *types.Header) *types.Header {
+	cpy := *header
+	if header.Number != nil {
+		cpy.Number = new(big.Int).Set(header.Number)
+	}
+
+	return &cpy
 }
 
-func (m *milestone) IsFutureMilestoneCompatible(chain []*types.Header) bool {
-	//Tip of the received chain
-	chainTipNumber := chain[len(chain)-1].Number.Uint64()
+// IsValidPeer checks if the chain we're about to receive from a peer is valid or not
+// in terms of reorgs. We won't reorg beyond the last bor finality submitted to mainchain.
+func (m *milestone) IsValidPeer(fetchHeadersByNumber func(number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (bool, error) {
+	return m.IsValidPeerCtx(context.Background(), withoutContext(fetchHeadersByNumber))
+}
 
-	for i := len(m.FutureMilestoneOrder) - 1; i >= 0; i-- {
-		//Finding out the highest future milestone number
-		//which is less or equal to received chain tip
-		if chainTipNumber >= m.FutureMilestoneOrder[i] {
-			//Looking for the received chain 's particular block number(matching future milestone number)
-			for j := len(chain) - 1; j >= 0; j-- {
-				if chain[j].Number.Uint64() == m.FutureMilestoneOrder[i] {
-					endBlockNum := m.FutureMilestoneOrder[i]
-					endBlockHash := m.FutureMilestoneList[endBlockNum]
+// IsValidPeerCtx is IsValidPeer with a fetch of the peer's header honouring the
+// context, the check returning the context error once it's cancelled
+func (m *milestone) IsValidPeerCtx(ctx context.Context, fetchHeadersByNumber func(ctx context.Context, number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (bool, error) {
+	_, err := m.CheckPeerCtx(ctx, fetchHeadersByNumber)
 
-					//Checking the received chain matches with future milestone
-					return chain[j].Hash() == endBlockHash
-				}
-			}
-		}
-	}
+	return err == nil, err
+}
 
-	return true
+// CheckPeer checks the peer like IsValidPeer, reporting the details of the comparison.
+// It allows telling a peer diverged from the whitelisted milestone apart from a peer
+// whose header couldn't be fetched.
+func (m *milestone) CheckPeer(fetchHeadersByNumber func(number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (PeerVerdict, error) {
+	return m.CheckPeerCtx(context.Background(), withoutContext(fetchHeadersByNumber))
 }
 
-func (m *milestone) ProcessFutureMilestone(num uint64, hash common.Hash) {
-	if len(m.FutureMilestoneOrder) < m.MaxCapacity {
-		m.enqueueFutureMilestone(num, hash)
+// CheckPeerCtx is CheckPeer with a fetch of the peer's header honouring the context
+func (m *milestone) CheckPeerCtx(ctx context.Context, fetchHeadersByNumber func(ctx context.Context, number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (PeerVerdict, error) {
+	if !m.enabled {
+		return PeerVerdict{Kind: PeerUnchecked}, nil
 	}
 
-	if num < m.LockedMilestoneNumber {
-		return
+	verdict, err := m.finality.CheckPeerCtx(ctx, fetchHeadersByNumber)
+
+	if err == nil {
+		MilestonePeerMeter.Mark(int64(1))
+	} else {
+		MilestonePeerMeter.Mark(int64(-1))
 	}
 
+	return verdict, err
+}
+
+// Purge purges the whitelisted milestone
+func (m *milestone) Purge() {
+	m.finality.Lock()
+	defer m.unlock()
+
+	m.doExist = false
+	m.version++
+
+	m.sendUpdateEvent()
+}
+
+// PurgeAll wipes the whole milestone state, i.e. the whitelisted milestone, the lock
+// along with its milestone ids and the future milestones, in memory and in the db.
+// It's meant for recovering from a bad state without deleting the chain db.
+func (m *milestone) PurgeAll() error {
+	m.finality.Lock()
+	defer m.unlock()
+
+	m.doExist = false
+	m.Number = 0
+	m.Hash = common.Hash{}
+
 	m.Locked = false
+	m.LockedMilestoneNumber = 0
+	m.LockedMilestoneHash = common.Hash{}
 	m.purgeMilestoneIDsList()
+	m.lockReleased(UnlockReasonPurged)
+
+	m.FutureMilestoneList = make(map[uint64]common.Hash)
+	m.FutureMilestoneOrder = make([]uint64, 0)
+	m.lag.arrivals = nil
+	m.checkFutureMilestoneInvariant()
+	m.updateFutureOccupancy()
+
+	var err error
+
+	// The removals go through the write-ahead log, so that the queued writes don't
+	// recreate the deleted records
+	if m.wal != nil {
+		err = errors.Join(
+			m.persist(stateRecord{Kind: stateRecordFinality, Delete: true}),
+			m.persist(stateRecord{Kind: stateRecordLock, Delete: true}),
+			m.persist(stateRecord{Kind: stateRecordFuture, Delete: true}),
+		)
+	} else {
+		m.version++
 
-	err := rawdb.WriteLockField(m.db, m.Locked, m.LockedMilestoneNumber, m.LockedMilestoneHash, m.LockedMilestoneIDs)
+		err = errors.Join(
+			rawdb.DeleteLastFinality[*rawdb.Milestone](m.db),
+			rawdb.DeleteLockField(m.db),
+			rawdb.DeleteFutureMilestoneList(m.db),
+		)
+	}
 
 	if err != nil {
-		log.Error("Error in writing lock data of milestone to db", "err", err)
+		m.logger().Error("Error in deleting the milestone state from db", "err", err)
+	} else {
+		m.logger().Warn("Purged the milestone state")
 	}
+
+	m.sendUpdateEvent()
+	m.sendLockEvent()
+
+	return err
 }
 
-// EnqueueFutureMilestone add the future milestone to the list
-func (m *milestone) enqueueFutureMilestone(key uint64, hash common.Hash) {
-	if _, ok := m.FutureMilestoneList[key]; ok {
-		log.Debug("Future milestone already exist", "endBlockNumber", key, "futureMilestoneHash", hash)
-		return
-	}
+func (m *milestone) Process(block uint64, hash common.Hash) {
+	m.ProcessFrom(block, hash, MilestoneSourceUnknown)
+}
 
-	log.Debug("Enqueing new future milestone", "endBlockNumber", key, "futureMilestoneHash", hash)
+// ProcessFrom whitelists the milestone received from the given source
+func (m *milestone) ProcessFrom(block uint64, hash common.Hash, source MilestoneSource) {
+	m.finality.Lock()
 
-	m.FutureMilestoneList[key] = hash
-	m.FutureMilestoneOrder = append(m.FutureMilestoneOrder, key)
+	if err := m.checkPersistence(); err != nil {
+		m.unlock()
+		m.logger().Warn("Refusing to process the milestone", "endBlockNumber", block, "err", err)
 
-	err := rawdb.WriteFutureMilestoneList(m.db, m.FutureMilestoneOrder, m.FutureMilestoneList)
-	if err != nil {
-		log.Error("Error in writing future milestone data to db", "err", err)
+		return
 	}
 
-	FutureMilestoneMeter.Update(int64(key))
+	m.releaseTimedOutLock()
+	m.checkProcessOrder(block)
+	m.process(block, hash)
+	m.setLatestSource(source)
+	notify := m.processedNotification(block, hash)
+	m.unlock()
+
+	notify()
 }
 
-// DequeueFutureMilestone remove the future milestone entry from the list.
-func (m *milestone) dequeueFutureMilestone() {
+// TryProcess whitelists the milestone only if it's above the whitelisted one,
+// skipping the out of order ones. It returns whether the milestone was processed.
+func (m *milestone) TryProcess(block uint64, hash common.Hash) bool {
+	m.finality.Lock()
+
+	if err := m.checkPersistence(); err != nil {
+		m.unlock()
+		m.logger().Warn("Refusing to process the milestone", "endBlockNumber", block, "err", err)
+
+		return false
+	}
+
+	if !m.checkProcessOrder(block) {
+		m.unlock()
+		m.logger().Debug("Skipping out of order milestone", "endBlockNumber", block, "latestMilestoneNumber", m.Number)
+
+		return false
+	}
+
+	m.process(block, hash)
+	m.setLatestSource(MilestoneSourceUnknown)
+	notify := m.processedNotification(block, hash)
+	m.unlock()
+
+	notify()
+
+	return true
+}
+
+// checkProcessOrder reports whether the milestone is above the whitelisted one,
+// counting the out of order ones. It should be called with the finality lock held.
+func (m *milestone) checkProcessOrder(block uint64) bool {
+	if m.doExist && block <= m.Number {
+		OutOfOrderMilestoneCounter.Inc(1)
+		return false
+	}
+
+	return true
+}
+
+// PromoteFutureMilestone whitelists the queued future milestone of the given number
+// without waiting for it to be processed, which is meant for manual recovery. The
+// promoted milestone and all the lower ones are removed from the future list.
+func (m *milestone) PromoteFutureMilestone(num uint64) error {
+	m.finality.Lock()
+
+	if err := m.checkPersistence(); err != nil {
+		m.unlock()
+		return err
+	}
+
+	hash, ok := m.FutureMilestoneList[num]
+	if !ok {
+		m.unlock()
+		return fmt.Errorf("%w: %d", ErrFutureMilestoneNotQueued, num)
+	}
+
+	m.logger().Info("Promoting future milestone", "endBlockNumber", num, "futureMilestoneHash", hash)
+
+	m.process(num, hash)
+	m.setLatestSource(MilestoneSourceManual)
+	notify := m.processedNotification(num, hash)
+	m.unlock()
+
+	notify()
+
+	return nil
+}
+
+// CompareAndSetMilestone atomically whitelists the milestone (newNum, newHash) only if
+// the current whitelisted number equals expectedNum (zero when there's no milestone),
+// returning whether the swap happened.
+func (m *milestone) CompareAndSetMilestone(expectedNum uint64, newNum uint64, newHash common.Hash) bool {
+	m.finality.Lock()
+
+	if m.checkPersistence() != nil {
+		m.unlock()
+		return false
+	}
+
+	var current uint64
+	if m.doExist {
+		current = m.Number
+	}
+
+	if current != expectedNum {
+		m.unlock()
+		return false
+	}
+
+	m.process(newNum, newHash)
+	m.setLatestSource(MilestoneSourceManual)
+	notify := m.processedNotification(newNum, newHash)
+	m.unlock()
+
+	notify()
+
+	return true
+}
+
+// Fastforward whitelists the given block, e.g. the pivot of a snap sync which is
+// already trusted, so that the chains up to it aren't validated again. Unlike
+// Process, the future milestones and the lock are left untouched, while the
+// subscribers, callbacks and publisher are notified alike. It's meant to be called
+// once after the pivot, and refuses to move the milestone backwards or to the
+// current number, returning whether it moved.
+func (m *milestone) Fastforward(number uint64, hash common.Hash) bool {
+	m.finality.Lock()
+
+	if err := m.checkPersistence(); err != nil {
+		m.unlock()
+		m.logger().Warn("Refusing to fast-forward the milestone", "number", number, "err", err)
+
+		return false
+	}
+
+	if err := validateBlockNumber(number); err != nil {
+		m.unlock()
+		m.logger().Warn("Refusing to fast-forward the milestone", "number", number, "err", err)
+
+		return false
+	}
+
+	if m.doExist && number <= m.Number {
+		m.unlock()
+		m.logger().Debug("Not fast-forwarding the milestone backwards", "number", number, "current", m.Number)
+
+		return false
+	}
+
+	m.commit(number, hash)
+	m.setLatestSource(MilestoneSourceManual)
+	m.sendUpdateEvent()
+	notify := m.processedNotification(number, hash)
+	m.unlock()
+
+	m.logger().Info("Fast-forwarded the milestone", "number", number, "hash", hash)
+
+	notify()
+
+	return true
+}
+
+// LatestMilestoneSource returns the source of the latest processed milestone
+func (m *milestone) LatestMilestoneSource() MilestoneSource {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	return m.latestSource
+}
+
+// setLatestSource records the source of the processed milestone.
+// It should be called with the finality lock held.
+func (m *milestone) setLatestSource(source MilestoneSource) {
+	m.latestSource = source
+
+	if counter, ok := MilestoneSourceCounters[source]; ok {
+		counter.Inc(1)
+	}
+}
+
+// process whitelists the milestone. It should be called with the finality lock held.
+func (m *milestone) process(block uint64, hash common.Hash) {
+	m.checkFeedGap(block)
+	m.commit(block, hash)
+
+	if len(m.FutureMilestoneOrder) > 0 && m.FutureMilestoneOrder[0] <= block {
+		for len(m.FutureMilestoneOrder) > 0 && m.FutureMilestoneOrder[0] <= block {
+			m.dequeueFutureMilestone()
+		}
+
+		m.writeFutureMilestoneList()
+		m.updateFutureOccupancy()
+	}
+
+	m.unlockSprint(block, UnlockReasonMilestoneProcessed)
+
+	m.sendUpdateEvent()
+}
+
+// commit whitelists the milestone in memory and in the db, recording it in the
+// history and the metrics. It should be called with the finality lock held.
+func (m *milestone) commit(block uint64, hash common.Hash) {
+	if !m.doExist || block > m.Number {
+		now := m.timeNow()
+
+		m.history.add(MilestoneRecord{Number: block, Hash: hash, Timestamp: now})
+		m.numberUnchangedSince = now
+	}
+
+	m.lastProcessedAt = m.timeNow()
+
+	m.finality.set(block, hash)
+	m.recordEvent(MilestoneCommitted, block, hash)
+
+	if err := m.persist(stateRecord{Kind: stateRecordFinality, Number: block, Hash: hash}); err != nil {
+		m.logger().Error("Error in writing whitelist state to db", "err", err)
+	}
+
+	whitelistedMilestoneMeter.Update(int64(block))
+
+	if m.InstanceID != "" {
+		metrics.GetOrRegisterGauge("chain/milestone/"+m.InstanceID+"/latest", nil).Update(int64(block))
+	}
+}
+
+// PredictNextMilestoneNumber predicts the number of the next milestone by adding
+// the average gap between the recently whitelisted milestones to the current one.
+// It returns false if there isn't enough history to compute the average.
+func (m *milestone) PredictNextMilestoneNumber() (uint64, bool) {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	if !m.doExist || m.history.len() < 2 {
+		return 0, false
+	}
+
+	records := m.history.list()
+
+	first, last := records[0].Number, records[len(records)-1].Number
+	if last <= first {
+		return 0, false
+	}
+
+	avgGap := (last - first) / uint64(len(records)-1)
+
+	return m.Number + avgGap, true
+}
+
+// LastMilestoneGap returns the difference between the block numbers of the last two
+// whitelisted milestones. It returns false if fewer than two milestones were processed.
+func (m *milestone) LastMilestoneGap() (uint64, bool) {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	if m.history.len() < 2 {
+		return 0, false
+	}
+
+	records := m.history.list()
+
+	previous, last := records[len(records)-2].Number, records[len(records)-1].Number
+	if last <= previous {
+		return 0, false
+	}
+
+	return last - previous, true
+}
+
+// CurrentMilestoneStaleness returns for how long the whitelisted milestone number
+// hasn't advanced. Processing the same milestone again doesn't reset it.
+func (m *milestone) CurrentMilestoneStaleness(now time.Time) time.Duration {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	if m.numberUnchangedSince.IsZero() || now.Before(m.numberUnchangedSince) {
+		return 0
+	}
+
+	return now.Sub(m.numberUnchangedSince)
+}
+
+// LatestMilestoneWithAge returns the whitelisted milestone along with the time elapsed
+// since a milestone was last processed. The age is zero if no milestone was processed
+// since the start, e.g. when the milestone was loaded from the db. It returns false
+// if there's no whitelisted milestone.
+func (m *milestone) LatestMilestoneWithAge(now time.Time) (num uint64, hash common.Hash, age time.Duration, ok bool) {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	if !m.doExist {
+		return 0, common.Hash{}, 0, false
+	}
+
+	if !m.lastProcessedAt.IsZero() && now.After(m.lastProcessedAt) {
+		age = now.Sub(m.lastProcessedAt)
+	}
+
+	return m.Number, m.Hash, age, true
+}
+
+// ReorgFloor returns the block number below which no reorg is possible, which is
+// the highest of the whitelisted milestone and the locked sprint. It returns false
+// when neither of them exists.
+func (m *milestone) ReorgFloor() (uint64, bool) {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	return m.reorgFloor()
+}
+
+func (m *milestone) reorgFloor() (uint64, bool) {
+	var (
+		floor uint64
+		ok    bool
+	)
+
+	if m.doExist {
+		floor, ok = m.Number, true
+	}
+
+	if m.Locked && (!ok || m.LockedMilestoneNumber > floor) {
+		floor, ok = m.LockedMilestoneNumber, true
+	}
+
+	return floor, ok
+}
+
+// CanPruneBelow returns whether it's safe to prune all the blocks strictly below
+// the given block number, which is the case when block <= ReorgFloor(). The floor
+// block itself is never covered, as it's still needed to validate incoming chains.
+func (m *milestone) CanPruneBelow(block uint64) bool {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	floor, ok := m.reorgFloor()
+
+	return ok && block <= floor
+}
+
+// IsFinalized returns whether the block is finalized by a milestone, i.e. it's at or
+// below the whitelisted milestone or it's a future milestone. The hash is only
+// verified for the milestone blocks, as the ones below aren't known.
+func (m *milestone) IsFinalized(number uint64, hash common.Hash) bool {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	if futureHash, ok := m.FutureMilestoneList[number]; ok {
+		return futureHash == hash
+	}
+
+	if !m.doExist || number > m.Number {
+		return false
+	}
+
+	return number < m.Number || hash == m.Hash
+}
+
+// CanRewind returns whether rewinding the chain head to the given block is permitted,
+// which isn't the case if it would drop the block of the locked sprint or of the
+// whitelisted milestone. The reason explains the refusal and is empty otherwise.
+func (m *milestone) CanRewind(toBlock uint64) (bool, string) {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	if m.Locked && toBlock < m.LockedMilestoneNumber {
+		return false, fmt.Sprintf("rewind to %d would drop the locked sprint at %d", toBlock, m.LockedMilestoneNumber)
+	}
+
+	if m.doExist && toBlock < m.Number {
+		return false, fmt.Sprintf("rewind to %d would drop the whitelisted milestone at %d", toBlock, m.Number)
+	}
+
+	return true, ""
+}
+
+// This function will Lock the mutex at the time of voting
+// fixme: get rid of it
+// New callers should use LockMilestone, which doesn't hold the lock across calls.
+func (m *milestone) LockMutex(endBlockNum uint64) bool {
+	m.finality.Lock()
+
+	if !m.canLock(endBlockNum) {
+		return false
+	}
+
+	m.lockHeldSince = time.Now()
+
+	return true
+}
+
+// LockMilestone locks the sprint ending at the given block with its hash and the
+// milestone id, under a single acquisition of the lock, so that a locked sprint is
+// never observable without its hash. It returns false, without locking, under the
+// same conditions as LockMutex.
+func (m *milestone) LockMilestone(endBlockNum uint64, endBlockHash common.Hash, milestoneId string) bool {
+	m.finality.Lock()
+	defer m.unlock()
+
+	if !m.canLock(endBlockNum) || m.conflictingMilestoneID(milestoneId, endBlockHash) {
+		return false
+	}
+
+	m.lock(endBlockNum, endBlockHash, milestoneId)
+
+	m.writeLockField()
+
+	MilestoneIdsLengthMeter.Update(int64(len(m.LockedMilestoneIDs)))
+
+	m.sendLockEvent()
+
+	return true
+}
+
+// canLock checks whether the sprint ending at the given block can be locked.
+// It should be called with the finality lock held.
+func (m *milestone) canLock(endBlockNum uint64) bool {
+	if err := m.checkPersistence(); err != nil {
+		m.logger().Warn("Refusing to lock the sprint", "endBlock Number", endBlockNum, "err", err)
+		return false
+	}
+
+	if err := validateBlockNumber(endBlockNum); err != nil {
+		m.logger().Warn("Refusing to lock the sprint", "endBlock Number", endBlockNum, "err", err)
+		return false
+	}
+
+	if m.doExist && endBlockNum <= m.Number { //if endNum is less than whitelisted milestone, then we won't lock the sprint
+		m.logger().Debug("endBlockNumber is less than or equal to latesMilestoneNumber", "endBlock Number", endBlockNum, "LatestMilestone Number", m.Number)
+		return false
+	}
+
+	if m.Locked && endBlockNum < m.LockedMilestoneNumber {
+		m.logger().Debug("endBlockNum is less than locked milestone number", "endBlock Number", endBlockNum, "Locked Milestone Number", m.LockedMilestoneNumber)
+		return false
+	}
+
+	return true
+}
+
+// validateBlockNumber rejects block numbers which can't be a real block number.
+// Bounding them keeps any arithmetic on block numbers (e.g. gaps) far from overflowing.
+func validateBlockNumber(number uint64) error {
+	if number > maxBlockNumber {
+		return fmt.Errorf("%w: %d", ErrBlockNumberTooLarge, number)
+	}
+
+	return nil
+}
+
+// This function will unlock the mutex locked in LockMutex
+// fixme: get rid of it
+func (m *milestone) UnlockMutex(doLock bool, milestoneId string, endBlockNum uint64, endBlockHash common.Hash) {
+	// Close the voting window opened by LockMutex
+	if !m.lockHeldSince.IsZero() {
+		MilestoneLockHeldTimer.UpdateSince(m.lockHeldSince)
+		m.lockHeldSince = time.Time{}
+	}
+
+	if err := m.checkPersistence(); err != nil {
+		m.unlock()
+		return
+	}
+
+	if doLock && m.conflictingMilestoneID(milestoneId, endBlockHash) {
+		m.unlock()
+		return
+	}
+
+	m.Locked = m.Locked || doLock
+
+	if doLock {
+		m.lock(endBlockNum, endBlockHash, milestoneId)
+	}
+
+	m.writeLockField()
+
+	milestoneIDLength := int64(len(m.LockedMilestoneIDs))
+	MilestoneIdsLengthMeter.Update(milestoneIDLength)
+
+	if doLock {
+		m.sendLockEvent()
+	}
+
+	m.unlock()
+}
+
+// lock locks the sprint ending at the given block, superseding the current lock.
+// It should be called with the finality lock held.
+func (m *milestone) lock(endBlockNum uint64, endBlockHash common.Hash, milestoneId string) {
+	m.unlockSprint(m.LockedMilestoneNumber, UnlockReasonSuperseded)
+	m.Locked = true
+	m.LockedMilestoneHash = endBlockHash
+	m.LockedMilestoneNumber = endBlockNum
+
+	if _, ok := m.LockedMilestoneIDs[milestoneId]; !ok {
+		MilestoneIdsAddedMeter.Mark(1)
+	}
+
+	// In UTC without the monotonic reading, so that the time survives the db round trip unchanged
+	m.LockedMilestoneIDs[milestoneId] = rawdb.MilestoneID{AddedAt: m.timeNow().UTC()}
+	m.lockEngaged(endBlockNum, endBlockHash, milestoneId)
+	m.evictMilestoneIDs()
+}
+
+// conflictingMilestoneID checks whether the milestone id was already voted for another
+// hash, which is a consensus inconsistency of heimdall. The ids being purged whenever
+// a new sprint is locked, every known id is associated with the locked hash. It should
+// be called with the finality lock held.
+func (m *milestone) conflictingMilestoneID(milestoneId string, hash common.Hash) bool {
+	if _, ok := m.LockedMilestoneIDs[milestoneId]; !ok || hash == m.LockedMilestoneHash {
+		return false
+	}
+
+	m.logger().Error("Ignoring milestone id voted for conflicting hashes", "milestoneID", milestoneId,
+		"lockedMilestoneHash", m.LockedMilestoneHash, "hash", hash)
+
+	MilestoneIDConflictCounter.Inc(1)
+
+	return true
+}
+
+// This function will unlock the locked sprint. It returns whether the lock state
+// changed, the already unlocked state being left untouched.
+func (m *milestone) UnlockSprint(endBlockNum uint64) bool {
+	m.finality.Lock()
+	defer m.unlock()
+
+	if err := m.checkPersistence(); err != nil {
+		m.logger().Warn("Refusing to unlock the sprint", "endBlock Number", endBlockNum, "err", err)
+		return false
+	}
+
+	return m.unlockSprint(endBlockNum, UnlockReasonSprintUnlocked)
+}
+
+// unlockSprint unlocks the sprint if the given block is at or above the locked one,
+// and returns whether the lock state changed. Nothing is persisted if it didn't.
+func (m *milestone) unlockSprint(endBlockNum uint64, reason string) bool {
+	if endBlockNum < m.LockedMilestoneNumber {
+		return false
+	}
+
+	if !m.Locked && len(m.LockedMilestoneIDs) == 0 {
+		return false
+	}
+
+	m.Locked = false
+
+	if len(m.LockedMilestoneIDs) > 0 {
+		m.purgeMilestoneIDsList()
+	}
+
+	m.lockReleased(reason)
+
+	m.writeLockField()
+
+	m.sendLockEvent()
+
+	return true
+}
+
+// This function will remove the stored milestoneID
+func (m *milestone) RemoveMilestoneID(milestoneId string) {
+	m.finality.Lock()
+
+	if err := m.checkPersistence(); err != nil {
+		m.unlock()
+		m.logger().Warn("Refusing to remove the milestoneID", "milestoneID", milestoneId, "err", err)
+
+		return
+	}
+
+	if id, ok := m.LockedMilestoneIDs[milestoneId]; ok {
+		MilestoneIdsRemovedMeter.Mark(1)
+
+		if !id.AddedAt.IsZero() {
+			MilestoneIDLifetimeTimer.Update(m.timeNow().Sub(id.AddedAt))
+		}
+	}
+
+	delete(m.LockedMilestoneIDs, milestoneId)
+
+	if len(m.LockedMilestoneIDs) == 0 {
+		m.Locked = false
+		m.lockReleased(UnlockReasonIDsRemoved)
+	}
+
+	m.writeLockField()
+
+	m.sendLockEvent()
+
+	m.unlock()
+}
+
+// ReconcileMilestoneIDs removes the stored milestoneIDs which aren't part of the
+// given authoritative list, unlocking the sprint if none is left.
+func (m *milestone) ReconcileMilestoneIDs(valid []string) {
+	m.finality.Lock()
+	defer m.unlock()
+
+	if err := m.checkPersistence(); err != nil {
+		m.logger().Warn("Refusing to reconcile the milestoneIDs", "err", err)
+		return
+	}
+
+	validIDs := make(map[string]struct{}, len(valid))
+	for _, id := range valid {
+		validIDs[id] = struct{}{}
+	}
+
+	removed := make([]string, 0)
+
+	for id := range m.LockedMilestoneIDs {
+		if _, ok := validIDs[id]; !ok {
+			delete(m.LockedMilestoneIDs, id)
+			removed = append(removed, id)
+		}
+	}
+
+	if len(removed) == 0 {
+		return
+	}
+
+	MilestoneIdsRemovedMeter.Mark(int64(len(removed)))
+
+	sort.Strings(removed)
+	m.logger().Info("Removed stale milestoneIDs", "removed", removed)
+
+	if len(m.LockedMilestoneIDs) == 0 {
+		m.Locked = false
+		m.lockReleased(UnlockReasonIDsRemoved)
+	}
+
+	m.writeLockField()
+
+	MilestoneIdsLengthMeter.Update(int64(len(m.LockedMilestoneIDs)))
+
+	m.sendLockEvent()
+}
+
+// checkReorgAllowed checks the chain against the locked sprint, or against the reorg
+// policy if set. It returns ErrReorgNotAllowed if the reorg isn't allowed.
+// It should be called with the finality lock held.
+func (m *milestone) checkReorgAllowed(currentHeader *types.Header, chain []*types.Header) error {
+	if m.ReorgPolicy == nil {
+		if m.Locked && (!m.IsReorgAllowed(chain, m.LockedMilestoneNumber, m.LockedMilestoneHash) || m.reorgGuarded(currentHeader, chain)) {
+			return ErrReorgNotAllowed
+		}
+
+		return nil
+	}
+
+	ids := make([]string, 0, len(m.LockedMilestoneIDs))
+	for id := range m.LockedMilestoneIDs {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	locked := LockState{Locked: m.Locked, Number: m.LockedMilestoneNumber, Hash: m.LockedMilestoneHash, IDs: ids}
+	whitelisted := MilestoneState{DoExist: m.doExist, Number: m.Number, Hash: m.Hash}
+
+	_, copied := copyChain(nil, chain)
+
+	if allowed, reason := m.ReorgPolicy(copied, locked, whitelisted); !allowed {
+		return fmt.Errorf("%w: %s", ErrReorgNotAllowed, reason)
+	}
+
+	return nil
+}
+
+// WithReorgGuardDepth sets the ReorgGuardDepth safety margin below the locked sprint
+func WithReorgGuardDepth(depth uint64) ServiceOption {
+	return func(m *milestone) {
+		m.ReorgGuardDepth = depth
+	}
+}
+
+// WithMilestoneEnabled enables or disables the milestone checks, overriding the
+// default taken from flags.Milestone
+func WithMilestoneEnabled(enabled bool) ServiceOption {
+	return func(m *milestone) {
+		m.enabled = enabled
+	}
+}
+
+// WithLockTimeout sets the age after which a lock is released, see LockTimeout
+func WithLockTimeout(timeout time.Duration) ServiceOption {
+	return func(m *milestone) {
+		m.LockTimeout = timeout
+	}
+}
+
+// This will check whether the incoming chain matches the locked sprint hash.
+// A chain spanning the locked sprint without containing its block (i.e. a sparse
+// chain skipping it) can't be checked against the hash and isn't allowed.
+func (m *milestone) IsReorgAllowed(chain []*types.Header, lockedMilestoneNumber uint64, lockedMilestoneHash common.Hash) bool {
+	if chain[len(chain)-1].Number.Uint64() <= lockedMilestoneNumber { //Can't reorg if the end block of incoming
+		return false //chain is less than locked sprint number
+	}
+
+	for i := 0; i < len(chain); i++ {
+		if chain[i].Number.Uint64() == lockedMilestoneNumber {
+			if headerHash(chain[i]) == lockedMilestoneHash {
+				return true
+			}
+
+			if m.AllowLockedHashMismatch {
+				m.logger().Warn("Allowing chain mismatching the locked sprint hash", "lockedMilestoneNumber", lockedMilestoneNumber,
+					"lockedMilestoneHash", lockedMilestoneHash, "chainHash", headerHash(chain[i]))
+
+				return true
+			}
+
+			return false
+		}
+	}
+
+	//The chain spans the locked sprint but doesn't contain its block
+	if chain[0].Number.Uint64() < lockedMilestoneNumber {
+		m.logger().Debug("Chain spans the locked sprint without containing its block", "lockedMilestoneNumber", lockedMilestoneNumber,
+			"first", chain[0].Number.Uint64(), "last", chain[len(chain)-1].Number.Uint64())
+
+		return false
+	}
+
+	return true
+}
+
+// reorgGuarded checks whether the chain reorgs the current chain from a fork point
+// within ReorgGuardDepth blocks below the locked sprint, see ReorgGuardDepth.
+// It should be called with the finality lock held.
+func (m *milestone) reorgGuarded(currentHeader *types.Header, chain []*types.Header) bool {
+	if m.ReorgGuardDepth == 0 || currentHeader == nil {
+		return false
+	}
+
+	for _, header := range chain {
+		if header.Number.Uint64() == m.LockedMilestoneNumber && headerHash(header) == m.LockedMilestoneHash {
+			return false
+		}
+	}
+
+	first, current := chain[0].Number.Uint64(), currentHeader.Number.Uint64()
+	if first == 0 || first > current {
+		// Nothing of the current chain gets replaced
+		return false
+	}
+
+	forkPoint := first - 1
+	if forkPoint >= m.LockedMilestoneNumber || m.LockedMilestoneNumber-forkPoint > m.ReorgGuardDepth {
+		return false
+	}
+
+	m.logger().Debug("Refusing reorg within the reorg guard depth of the locked sprint", "lockedMilestoneNumber", m.LockedMilestoneNumber,
+		"current", current, "forkPoint", forkPoint, "depth", current-forkPoint, "reorgGuardDepth", m.ReorgGuardDepth)
+
+	return true
+}
+
+// This will return the list of milestoneIDs stored.
+func (m *milestone) GetMilestoneIDsList() []string {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	// fixme: use generics :)
+	keys := make([]string, 0, len(m.LockedMilestoneIDs))
+	for key := range m.LockedMilestoneIDs {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// MilestoneIDsForLockedNumber returns the sorted list of milestoneIDs stored for
+// the given locked block number. Locking a new sprint purges the ids of the
+// previous one, hence only the ids of the current locked number are known.
+func (m *milestone) MilestoneIDsForLockedNumber(num uint64) []string {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	if len(m.LockedMilestoneIDs) == 0 || num != m.LockedMilestoneNumber {
+		return []string{}
+	}
+
+	keys := make([]string, 0, len(m.LockedMilestoneIDs))
+	for key := range m.LockedMilestoneIDs {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// This is remove the milestoneIDs stored in the list.
+func (m *milestone) purgeMilestoneIDsList() {
+	MilestoneIdsRemovedMeter.Mark(int64(len(m.LockedMilestoneIDs)))
+
+	m.LockedMilestoneIDs = make(map[string]rawdb.MilestoneID)
+}
+
+func (m *milestone) IsFutureMilestoneCompatible(chain []*types.Header) bool {
+	match, _, _ := m.FutureMilestoneCompatibility(chain)
+
+	return match
+}
+
+// FutureMilestoneMatch is the comparison of a chain against a future milestone
+type FutureMilestoneMatch struct {
+	Number   uint64
+	Expected common.Hash // Hash of the future milestone
+	Actual   common.Hash // Hash of the chain's block at the number, zero if absent
+	Present  bool        // Whether the chain contains the block at the number
+}
+
+// FutureMilestoneCompatibility checks the chain like IsFutureMilestoneCompatible and
+// reports the future milestone it was checked against, nil if none applied. The skip
+// result is true when the check was skipped, the chain being empty or shorter than
+// MinChainLenForFutureCheck. Like IsFutureMilestoneCompatible, it doesn't take the lock.
+func (m *milestone) FutureMilestoneCompatibility(chain []*types.Header) (match bool, skip bool, info *FutureMilestoneMatch) {
+	if len(chain) == 0 || len(chain) < m.MinChainLenForFutureCheck {
+		return true, true, nil
+	}
+
+	match, info = futureCompatibility(chain, m.FutureMilestoneOrder, m.FutureMilestoneList, m.RequirePresentFutureMilestones)
+
+	return match, false, info
+}
+
+// futureCompatibility checks the chain against the highest of the sorted future
+// entries (milestones or checkpoints) it reaches, and returns the comparison, nil
+// if none applied. With requirePresent, a chain spanning that entry without
+// containing its block is incompatible.
+func futureCompatibility(chain []*types.Header, order []uint64, list map[uint64]common.Hash, requirePresent bool) (bool, *FutureMilestoneMatch) {
+	//Tip of the received chain
+	chainTipNumber := chain[len(chain)-1].Number.Uint64()
+
+	for i := len(order) - 1; i >= 0; i-- {
+		//Finding out the highest future milestone number
+		//which is less or equal to received chain tip
+		if chainTipNumber >= order[i] {
+			info := &FutureMilestoneMatch{Number: order[i], Expected: list[order[i]]}
+
+			//Looking for the received chain 's particular block number(matching future milestone number)
+			for j := len(chain) - 1; j >= 0; j-- {
+				if chain[j].Number.Uint64() == order[i] {
+					info.Actual, info.Present = headerHash(chain[j]), true
+
+					//Checking the received chain matches with future milestone
+					return info.Actual == info.Expected, info
+				}
+			}
+
+			//The chain spans the future milestone but doesn't contain its block
+			if requirePresent && chain[0].Number.Uint64() <= order[i] {
+				return false, info
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// CheckHeadersAgainstFutureMilestones checks a sparse set of (announced) headers
+// against the queued future milestones and returns the milestone numbers whose
+// hash conflicts with the header received for that number. Headers which don't
+// correspond to any future milestone are ignored.
+func (m *milestone) CheckHeadersAgainstFutureMilestones(headers []*types.Header) (conflicts []uint64) {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	for _, header := range headers {
+		if header == nil || header.Number == nil {
+			continue
+		}
+
+		number := header.Number.Uint64()
+
+		hash, ok := m.FutureMilestoneList[number]
+		if !ok {
+			continue
+		}
+
+		if header.Hash() != hash {
+			conflicts = append(conflicts, number)
+		}
+	}
+
+	return conflicts
+}
+
+func (m *milestone) ProcessFutureMilestone(num uint64, hash common.Hash) {
+	if m.futurePaused.Load() {
+		m.logger().Debug("Ignoring future milestone while paused", "endBlockNumber", num)
+		return
+	}
+
+	m.finality.Lock()
+	defer m.unlock()
+
+	if err := m.checkPersistence(); err != nil {
+		m.logger().Warn("Refusing to process the future milestone", "endBlockNumber", num, "err", err)
+		return
+	}
+
+	accepted, changed := m.processFutureMilestone(num, hash)
+
+	if changed {
+		m.writeFutureMilestoneList()
+		m.updateFutureOccupancy()
+	}
+
+	if accepted && num >= m.LockedMilestoneNumber {
+		m.unlockSprint(num, UnlockReasonFutureMilestone)
+	}
+}
+
+// ProcessFutureMilestones processes a batch of future milestones, like several calls
+// of ProcessFutureMilestone would, but writes the future milestone list to the db
+// only once at the end.
+func (m *milestone) ProcessFutureMilestones(milestones []MilestonePin) {
+	if m.futurePaused.Load() {
+		m.logger().Debug("Ignoring future milestones while paused", "count", len(milestones))
+		return
+	}
+
+	m.finality.Lock()
+	defer m.unlock()
+
+	if err := m.checkPersistence(); err != nil {
+		m.logger().Warn("Refusing to process the future milestones", "count", len(milestones), "err", err)
+		return
+	}
+
+	var (
+		changed  bool
+		accepted bool
+		highest  uint64
+	)
+
+	for _, pin := range milestones {
+		ok, queued := m.processFutureMilestone(pin.Number, pin.Hash)
+		if !ok {
+			continue
+		}
+
+		changed = changed || queued
+
+		if !accepted || pin.Number > highest {
+			accepted, highest = true, pin.Number
+		}
+	}
+
+	if changed {
+		m.writeFutureMilestoneList()
+		m.updateFutureOccupancy()
+	}
+
+	// Releasing the lock for the highest milestone covers the lower ones
+	if accepted && highest >= m.LockedMilestoneNumber {
+		m.unlockSprint(highest, UnlockReasonFutureMilestone)
+	}
+}
+
+// processFutureMilestone queues the future milestone if it's accepted, reporting
+// whether it was accepted and whether the list changed. It doesn't write the list
+// to the db, which is left to the caller.
+// It should be called with the finality lock held.
+func (m *milestone) processFutureMilestone(num uint64, hash common.Hash) (accepted bool, changed bool) {
+	if !m.acceptsFutureMilestone(num) {
+		return false, false
+	}
+
+	return true, m.queueFutureMilestone(num, hash)
+}
+
+// acceptsFutureMilestone checks the sprint alignment of the future milestone, returning
+// false if the milestone is rejected because it's misaligned.
+func (m *milestone) acceptsFutureMilestone(num uint64) bool {
+	if m.isSprintAligned(num) {
+		return true
+	}
+
+	MisalignedFutureMilestoneCounter.Inc(1)
+
+	if m.RejectMisalignedFutureMilestones {
+		m.logger().Warn("Rejecting future milestone not aligned to a sprint boundary", "endBlockNumber", num, "sprintLength", m.SprintLength)
+		return false
+	}
+
+	m.logger().Warn("Future milestone not aligned to a sprint boundary", "endBlockNumber", num, "sprintLength", m.SprintLength)
+
+	return true
+}
+
+// queueFutureMilestone queues the future milestone within the capacity, evicting the
+// lowest entries if needed, and reports whether the list changed. It doesn't write the
+// list to the db, which is left to the caller.
+func (m *milestone) queueFutureMilestone(num uint64, hash common.Hash) bool {
+	q := futureQueue{
+		kind:        "milestone",
+		capacity:    m.MaxCapacity,
+		evictLowest: m.EvictLowestOnFull,
+		logger:      m.logger(),
+		order:       func() []uint64 { return m.FutureMilestoneOrder },
+		enqueue:     m.enqueueFutureMilestone,
+		dequeue:     m.dequeueFutureMilestone,
+	}
+
+	changed, dropped := q.queue(num, hash)
+	if dropped {
+		DroppedFutureMilestoneCounter.Inc(1)
+	}
+
+	return changed
+}
+
+// WithEvictLowestOnFull makes a full future milestone list evict its lowest entry in
+// favor of a higher incoming milestone, see EvictLowestOnFull
+func WithEvictLowestOnFull() ServiceOption {
+	return func(m *milestone) {
+		m.EvictLowestOnFull = true
+	}
+}
+
+// Actions of a future milestone previewed by PreviewProcessFutureMilestone
+const (
+	FutureMilestoneEnqueue        = "enqueue"         // The milestone would be added to the list
+	FutureMilestoneDuplicate      = "duplicate"       // The milestone is already in the list
+	FutureMilestoneDropFull       = "drop-full"       // The list is full, the milestone would be dropped
+	FutureMilestoneEvictLowest    = "evict-lowest"    // The list is full, the lowest milestone would be replaced
+	FutureMilestoneDropMisaligned = "drop-misaligned" // The milestone isn't sprint aligned and would be rejected
+)
+
+// PreviewProcessFutureMilestone computes what ProcessFutureMilestone would do with
+// the future milestone under the current policy, along with the resulting order of
+// the future milestones, without mutating the state. A full list drops the new
+// milestone, unless EvictLowestOnFull lets it replace the lowest entry.
+func (m *milestone) PreviewProcessFutureMilestone(num uint64, hash common.Hash) (action string, resultingOrder []uint64) {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	resultingOrder = append([]uint64{}, m.FutureMilestoneOrder...)
+
+	if !m.isSprintAligned(num) && m.RejectMisalignedFutureMilestones {
+		return FutureMilestoneDropMisaligned, resultingOrder
+	}
+
+	// The eviction beyond a lowered capacity happens even if the milestone is dropped
+	if len(resultingOrder) > m.MaxCapacity {
+		resultingOrder = resultingOrder[len(resultingOrder)-m.MaxCapacity:]
+	}
+
+	switch {
+	case slices.Contains(resultingOrder, num):
+		return FutureMilestoneDuplicate, resultingOrder
+	case m.shouldEvictLowest(num, resultingOrder):
+		return FutureMilestoneEvictLowest, insertSorted(resultingOrder[1:], num)
+	case len(resultingOrder) >= m.MaxCapacity:
+		return FutureMilestoneDropFull, resultingOrder
+	default:
+		return FutureMilestoneEnqueue, insertSorted(resultingOrder, num)
+	}
+}
+
+// shouldEvictLowest checks whether the lowest of the full future milestones should be
+// evicted in favor of the incoming one, as per EvictLowestOnFull. The order is sorted
+// ascending, so the lowest one is its head.
+func (m *milestone) shouldEvictLowest(num uint64, order []uint64) bool {
+	return m.EvictLowestOnFull && evictsLowest(num, order, m.MaxCapacity)
+}
+
+// SetFutureMilestoneCapacity sets the capacity of the future milestone list. Lowering
+// it below the number of queued future milestones evicts the lowest ones.
+func (m *milestone) SetFutureMilestoneCapacity(capacity int) error {
+	if capacity < 1 {
+		return ErrInvalidFutureMilestoneCapacity
+	}
+
+	m.finality.Lock()
+	defer m.unlock()
+
+	m.MaxCapacity = capacity
+
+	if m.evictBeyondCapacity() {
+		m.writeFutureMilestoneList()
+	}
+
+	m.updateFutureOccupancy()
+
+	return nil
+}
+
+// evictBeyondCapacity evicts the lowest future milestones beyond the capacity at once,
+// so that the list never breaks the invariant in between, and reports whether any was
+// evicted. The caller writes the list to the db.
+func (m *milestone) evictBeyondCapacity() bool {
+	excess := len(m.FutureMilestoneOrder) - m.MaxCapacity
+	if excess <= 0 {
+		return false
+	}
+
+	for _, number := range m.FutureMilestoneOrder[:excess] {
+		m.logger().Info("Evicting future milestone beyond the capacity", "endBlockNumber", number, "capacity", m.MaxCapacity)
+
+		m.recordEvent(FutureDequeued, number, m.FutureMilestoneList[number])
+		delete(m.FutureMilestoneList, number)
+		m.forgetFutureArrival(number)
+	}
+
+	m.FutureMilestoneOrder = m.FutureMilestoneOrder[excess:]
+
+	m.checkFutureMilestoneInvariant()
+
+	return true
+}
+
+// PauseFutureMilestones stops the processing of the new future milestones, e.g.
+// during an issue of their feed. The queued ones are still honored, and the
+// milestones are still processed.
+func (m *milestone) PauseFutureMilestones() {
+	if !m.futurePaused.Swap(true) {
+		m.logger().Warn("Pausing the processing of future milestones")
+	}
+}
+
+// ResumeFutureMilestones resumes the processing of the new future milestones
+func (m *milestone) ResumeFutureMilestones() {
+	if m.futurePaused.Swap(false) {
+		m.logger().Info("Resuming the processing of future milestones")
+	}
+}
+
+// isSprintAligned checks whether the block number is at a sprint boundary,
+// i.e. a multiple of the sprint length. It's always true if the sprint length is unset.
+func (m *milestone) isSprintAligned(num uint64) bool {
+	return m.SprintLength == 0 || num%m.SprintLength == 0
+}
+
+// SubscribeMilestoneUpdates registers a subscription for whitelisted milestone updates.
+// Events are sent while the finality lock is held, so subscribers must not call back
+// into the service from the receiving goroutine before draining the channel.
+func (m *milestone) SubscribeMilestoneUpdates(ch chan<- MilestoneUpdateEvent) event.Subscription {
+	return m.updateFeed.Subscribe(ch)
+}
+
+// SubscribeMilestoneLocks registers a subscription for lock state changes.
+// The same delivery constraints as SubscribeMilestoneUpdates apply.
+func (m *milestone) SubscribeMilestoneLocks(ch chan<- MilestoneLockEvent) event.Subscription {
+	return m.lockFeed.Subscribe(ch)
+}
+
+// sendUpdateEvent queues the current whitelisted milestone for the update subscribers,
+// the event being sent once the finality lock is released, see unlock.
+// It should be called with the finality lock held.
+func (m *milestone) sendUpdateEvent() {
+	ev := MilestoneUpdateEvent{Number: m.Number, Hash: m.Hash, Purged: !m.doExist}
+
+	m.pendingEvents = append(m.pendingEvents, func() { m.updateFeed.Send(ev) })
+}
+
+// sendLockEvent queues the current lock state for the lock subscribers, the event
+// being sent once the finality lock is released, see unlock.
+// It should be called with the finality lock held.
+func (m *milestone) sendLockEvent() {
+	ids := make([]string, 0, len(m.LockedMilestoneIDs))
+	for id := range m.LockedMilestoneIDs {
+		ids = append(ids, id)
+	}
+
+	ev := MilestoneLockEvent{
+		Locked: m.Locked,
+		Number: m.LockedMilestoneNumber,
+		Hash:   m.LockedMilestoneHash,
+		IDs:    ids,
+	}
+
+	m.pendingEvents = append(m.pendingEvents, func() { m.lockFeed.Send(ev) })
+}
+
+// unlock releases the finality lock, then sends the feed events queued while it was
+// held, so that a slow subscriber doesn't stall the other users of the lock. The
+// events are sent after the ones queued by the previous holders of the lock.
+func (m *milestone) unlock() {
+	events := m.pendingEvents
+	if len(events) == 0 {
+		m.finality.Unlock()
+		return
+	}
+
+	previous, done := m.lastEvents, make(chan struct{})
+	m.pendingEvents, m.lastEvents = nil, done
+
+	m.finality.Unlock()
+
+	if previous != nil {
+		<-previous
+	}
+
+	for _, send := range events {
+		send()
+	}
+
+	close(done)
+}
+
+// GetFutureMilestone returns the hash of the future milestone queued at the given
+// block number, and whether there's one
+func (m *milestone) GetFutureMilestone(number uint64) (common.Hash, bool) {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	hash, ok := m.FutureMilestoneList[number]
+
+	return hash, ok
+}
+
+// FutureMilestoneCount returns the number of queued future milestones
+func (m *milestone) FutureMilestoneCount() int {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	return len(m.FutureMilestoneOrder)
+}
+
+// FutureMilestonesSorted returns a copy of the queued future milestones in ascending
+// order, so that the callers can iterate them without holding the lock
+func (m *milestone) FutureMilestonesSorted() []MilestonePin {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	pins := make([]MilestonePin, 0, len(m.FutureMilestoneOrder))
+	for _, number := range m.FutureMilestoneOrder {
+		pins = append(pins, MilestonePin{Number: number, Hash: m.FutureMilestoneList[number]})
+	}
+
+	return pins
+}
+
+// DrainFutureMilestones removes all the queued future milestones and returns
+// them in ascending order. The emptied list is persisted.
+func (m *milestone) DrainFutureMilestones() []MilestonePin {
+	m.finality.Lock()
+	defer m.unlock()
+
+	if err := m.checkPersistence(); err != nil {
+		m.logger().Warn("Refusing to drain the future milestones", "err", err)
+		return nil
+	}
+
+	order := append([]uint64{}, m.FutureMilestoneOrder...)
+	sort.Slice(order, func(i, j int) bool { return order[i] < order[j] })
+
+	pins := make([]MilestonePin, 0, len(order))
+	for _, number := range order {
+		pins = append(pins, MilestonePin{Number: number, Hash: m.FutureMilestoneList[number]})
+		m.recordEvent(FutureDequeued, number, m.FutureMilestoneList[number])
+	}
+
+	m.FutureMilestoneList = make(map[uint64]common.Hash)
+	m.FutureMilestoneOrder = make([]uint64, 0)
+	m.lag.arrivals = nil
+
+	m.checkFutureMilestoneInvariant()
+
+	m.writeFutureMilestoneList()
+	m.updateFutureOccupancy()
+
+	return pins
+}
+
+// EnqueueFutureMilestone add the future milestone to the list, keeping the order sorted ascending,
+// and reports whether it was added. The caller writes the list to the db.
+func (m *milestone) enqueueFutureMilestone(key uint64, hash common.Hash) bool {
+	if _, ok := m.FutureMilestoneList[key]; ok {
+		m.logger().Debug("Future milestone already exist", "endBlockNumber", key, "futureMilestoneHash", hash)
+		return false
+	}
+
+	m.logger().Debug("Enqueing new future milestone", "endBlockNumber", key, "futureMilestoneHash", hash)
+
+	m.FutureMilestoneList[key] = hash
+	m.FutureMilestoneOrder = insertSorted(m.FutureMilestoneOrder, key)
+	m.recordFutureArrival(key)
+	m.recordEvent(FutureEnqueued, key, hash)
+
+	m.checkFutureMilestoneInvariant()
+
+	FutureMilestoneMeter.Update(int64(key))
+
+	return true
+}
+
+// DequeueFutureMilestone remove the future milestone entry from the list.
+// The caller writes the list to the db.
+func (m *milestone) dequeueFutureMilestone() {
+	m.recordEvent(FutureDequeued, m.FutureMilestoneOrder[0], m.FutureMilestoneList[m.FutureMilestoneOrder[0]])
 	delete(m.FutureMilestoneList, m.FutureMilestoneOrder[0])
+	m.forgetFutureArrival(m.FutureMilestoneOrder[0])
 	m.FutureMilestoneOrder = m.FutureMilestoneOrder[1:]
 
-	err := rawdb.WriteFutureMilestoneList(m.db, m.FutureMilestoneOrder, m.FutureMilestoneList)
-	if err != nil {
-		log.Error("Error in writing future milestone data to db", "err", err)
+	m.checkFutureMilestoneInvariant()
+}
+
+// repairFutureMilestones drops the entries of the future milestone order without a
+// hash in the list, the duplicated ones and the hashes of the list missing from the
+// order, so that the two are in sync. The order must be sorted. It returns the
+// repaired order and list, along with the numbers of the dropped entries.
+func repairFutureMilestones(order []uint64, list map[uint64]common.Hash) ([]uint64, map[uint64]common.Hash, []uint64) {
+	repairedOrder := make([]uint64, 0, len(order))
+	repairedList := make(map[uint64]common.Hash, len(list))
+
+	var dropped []uint64
+
+	for _, number := range order {
+		hash, ok := list[number]
+
+		if _, dup := repairedList[number]; !ok || dup {
+			dropped = append(dropped, number)
+			continue
+		}
+
+		repairedOrder = append(repairedOrder, number)
+		repairedList[number] = hash
+	}
+
+	for number := range list {
+		if _, ok := repairedList[number]; !ok {
+			dropped = append(dropped, number)
+		}
+	}
+
+	slices.Sort(dropped)
+
+	return repairedOrder, repairedList, dropped
+}
+
+// updateFutureOccupancy updates the occupancy metrics of the future milestone list.
+// It should be called with the finality lock held, whenever the list changes length.
+func (m *milestone) updateFutureOccupancy() {
+	FutureMilestoneOccupancyGauge.Update(int64(len(m.FutureMilestoneOrder)))
+
+	if m.MaxCapacity > 0 {
+		FutureMilestoneOccupancyRatioGauge.Update(int64(len(m.FutureMilestoneOrder)) * 10000 / int64(m.MaxCapacity))
 	}
 }
@@ -0,0 +1,1664 @@
+package whitelist
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/flags"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// TestMilestoneIDsForLockedNumber checks the milestone ids reported per locked number
+func TestMilestoneIDsForLockedNumber(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	require.Empty(t, s.MilestoneIDsForLockedNumber(0), "expected no ids as nothing is locked")
+
+	milestone.LockMutex(10)
+	milestone.UnlockMutex(true, "milestoneID2", 10, common.Hash{1})
+	milestone.LockMutex(10)
+	milestone.UnlockMutex(false, "milestoneID1", 10, common.Hash{1})
+
+	require.Equal(t, []string{"milestoneID2"}, s.MilestoneIDsForLockedNumber(10))
+
+	// Adding ids to the same locked number directly, as the voting for the same sprint would
+	milestone.LockedMilestoneIDs["milestoneID1"] = rawdb.MilestoneID{}
+	milestone.LockedMilestoneIDs["milestoneID3"] = rawdb.MilestoneID{}
+
+	require.Equal(t, []string{"milestoneID1", "milestoneID2", "milestoneID3"}, s.MilestoneIDsForLockedNumber(10))
+	require.Empty(t, s.MilestoneIDsForLockedNumber(11))
+
+	// Locking a new sprint purges the ids of the previous one
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID4", 20, common.Hash{2})
+
+	require.Empty(t, s.MilestoneIDsForLockedNumber(10))
+	require.Equal(t, []string{"milestoneID4"}, s.MilestoneIDsForLockedNumber(20))
+}
+
+// TestRequirePresentFutureMilestones checks the rejection of chains spanning
+// a future milestone without containing its block
+func TestRequirePresentFutureMilestones(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 30)
+
+	milestone.ProcessFutureMilestone(15, chainA[14].Hash())
+
+	// Sparse chain spanning the future milestone but missing the block 15
+	sparseChain := append(append([]*types.Header{}, chainA[9:14]...), chainA[15:30]...)
+
+	require.True(t, milestone.IsFutureMilestoneCompatible(sparseChain), "expected compatible by default")
+
+	milestone.RequirePresentFutureMilestones = true
+
+	require.False(t, milestone.IsFutureMilestoneCompatible(sparseChain), "expected incompatible as the milestone block is absent")
+
+	res, err := s.IsValidChain(chainA[0], sparseChain)
+	require.ErrorIs(t, err, ErrFutureMilestoneMismatch)
+	require.False(t, res, "expected chain to be invalid as the milestone block is absent")
+
+	// Contiguous chain containing the matching milestone block
+	require.True(t, milestone.IsFutureMilestoneCompatible(chainA[9:30]), "expected compatible as the milestone block matches")
+
+	// Chain starting after the future milestone doesn't span it
+	require.True(t, milestone.IsFutureMilestoneCompatible(chainA[20:30]), "expected compatible as the chain starts after the milestone")
+
+	// Chain ending before the future milestone doesn't span it
+	require.True(t, milestone.IsFutureMilestoneCompatible(chainA[0:10]), "expected compatible as the chain ends before the milestone")
+}
+
+// TestPredictNextMilestoneNumber checks the prediction of the next milestone
+// number from the whitelisted milestone history
+func TestPredictNextMilestoneNumber(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	_, ok := s.PredictNextMilestoneNumber()
+	require.False(t, ok, "expected no prediction without any milestone")
+
+	s.ProcessMilestone(16, common.Hash{1})
+
+	_, ok = s.PredictNextMilestoneNumber()
+	require.False(t, ok, "expected no prediction with a single milestone")
+
+	// Regular cadence of 16 blocks, longer than the history
+	for i := uint64(2); i <= 40; i++ {
+		s.ProcessMilestone(i*16, common.Hash{byte(i)})
+	}
+
+	next, ok := s.PredictNextMilestoneNumber()
+	require.True(t, ok)
+	require.Equal(t, uint64(41*16), next)
+
+	// Re-processing the same milestone doesn't affect the cadence
+	s.ProcessMilestone(40*16, common.Hash{40})
+
+	next, ok = s.PredictNextMilestoneNumber()
+	require.True(t, ok)
+	require.Equal(t, uint64(41*16), next)
+}
+
+// TestLockMutexBounds checks the LockMutex comparisons at the boundary and extreme values
+func TestLockMutexBounds(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	require.NoError(t, validateBlockNumber(0))
+	require.NoError(t, validateBlockNumber(maxBlockNumber))
+	require.ErrorIs(t, validateBlockNumber(maxBlockNumber+1), ErrBlockNumberTooLarge)
+	require.ErrorIs(t, validateBlockNumber(math.MaxUint64), ErrBlockNumberTooLarge)
+
+	// Absurdly large block numbers are rejected
+	require.False(t, milestone.LockMutex(math.MaxUint64), "expected the lock to be refused")
+	milestone.UnlockMutex(false, "", math.MaxUint64, common.Hash{})
+
+	require.False(t, milestone.LockMutex(maxBlockNumber+1), "expected the lock to be refused")
+	milestone.UnlockMutex(false, "", maxBlockNumber+1, common.Hash{})
+
+	// Zero can be locked when nothing is whitelisted
+	require.True(t, milestone.LockMutex(0))
+	milestone.UnlockMutex(false, "", 0, common.Hash{})
+
+	s.ProcessMilestone(100, common.Hash{1})
+
+	// Boundary around the whitelisted milestone
+	require.False(t, milestone.LockMutex(99))
+	milestone.UnlockMutex(false, "", 99, common.Hash{})
+
+	require.False(t, milestone.LockMutex(100))
+	milestone.UnlockMutex(false, "", 100, common.Hash{})
+
+	require.True(t, milestone.LockMutex(101))
+	milestone.UnlockMutex(true, "milestoneID1", 200, common.Hash{2})
+
+	// Boundary around the locked milestone
+	require.False(t, milestone.LockMutex(199))
+	milestone.UnlockMutex(false, "", 199, common.Hash{})
+
+	require.True(t, milestone.LockMutex(200))
+	milestone.UnlockMutex(false, "", 200, common.Hash{})
+
+	// The highest accepted block number
+	require.True(t, milestone.LockMutex(maxBlockNumber))
+	milestone.UnlockMutex(false, "", maxBlockNumber, common.Hash{})
+}
+
+// TestDrainFutureMilestones checks that draining returns all the queued future
+// milestones in ascending order and persists the empty list
+func TestDrainFutureMilestones(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+
+	require.Empty(t, s.DrainFutureMilestones(), "expected nothing to drain")
+
+	milestone.ProcessFutureMilestone(30, common.Hash{3})
+	milestone.ProcessFutureMilestone(10, common.Hash{1})
+	milestone.ProcessFutureMilestone(20, common.Hash{2})
+
+	drained := s.DrainFutureMilestones()
+	require.Equal(t, []MilestonePin{
+		{Number: 10, Hash: common.Hash{1}},
+		{Number: 20, Hash: common.Hash{2}},
+		{Number: 30, Hash: common.Hash{3}},
+	}, drained)
+
+	require.Empty(t, milestone.FutureMilestoneList)
+	require.Empty(t, milestone.FutureMilestoneOrder)
+
+	order, list, err := rawdb.ReadFutureMilestoneList(db)
+	require.Nil(t, err)
+	require.Empty(t, order, "expected the empty order to be persisted")
+	require.Empty(t, list, "expected the empty list to be persisted")
+}
+
+// TestCurrentMilestoneStaleness checks that the staleness only resets when the
+// whitelisted milestone number advances
+func TestCurrentMilestoneStaleness(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	require.Equal(t, time.Duration(0), s.CurrentMilestoneStaleness(time.Now()), "expected no staleness without any milestone")
+
+	s.ProcessMilestone(10, common.Hash{1})
+
+	since := milestone.numberUnchangedSince
+	require.False(t, since.IsZero())
+
+	// Processing the same number doesn't reset the staleness
+	var prev time.Duration
+
+	for i := 1; i <= 5; i++ {
+		s.ProcessMilestone(10, common.Hash{1})
+
+		staleness := s.CurrentMilestoneStaleness(since.Add(time.Duration(i) * time.Minute))
+		require.Equal(t, time.Duration(i)*time.Minute, staleness)
+		require.Greater(t, staleness, prev, "expected the staleness to keep growing")
+
+		prev = staleness
+	}
+
+	// Advancing the number resets the staleness
+	s.ProcessMilestone(20, common.Hash{2})
+	require.True(t, milestone.numberUnchangedSince.After(since) || milestone.numberUnchangedSince.Equal(since))
+	require.Equal(t, time.Minute, s.CurrentMilestoneStaleness(milestone.numberUnchangedSince.Add(time.Minute)))
+}
+
+// TestCanPruneBelow checks the pruning decision against the reorg floor
+func TestCanPruneBelow(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	// No floor, nothing can be pruned
+	_, ok := s.ReorgFloor()
+	require.False(t, ok)
+	require.False(t, s.CanPruneBelow(0))
+	require.False(t, s.CanPruneBelow(10))
+
+	// Floor at the whitelisted milestone
+	s.ProcessMilestone(100, common.Hash{1})
+
+	floor, ok := s.ReorgFloor()
+	require.True(t, ok)
+	require.Equal(t, uint64(100), floor)
+
+	require.True(t, s.CanPruneBelow(0))
+	require.True(t, s.CanPruneBelow(99))
+	require.True(t, s.CanPruneBelow(100), "expected blocks strictly below the floor to be prunable")
+	require.False(t, s.CanPruneBelow(101), "expected the floor block to be kept")
+
+	// Floor at the locked sprint, above the whitelisted milestone
+	milestone.LockMutex(150)
+	milestone.UnlockMutex(true, "milestoneID1", 150, common.Hash{2})
+
+	floor, ok = s.ReorgFloor()
+	require.True(t, ok)
+	require.Equal(t, uint64(150), floor)
+
+	require.True(t, s.CanPruneBelow(150))
+	require.False(t, s.CanPruneBelow(151))
+
+	// Floor at the locked sprint only
+	s.PurgeWhitelistedMilestone()
+
+	floor, ok = s.ReorgFloor()
+	require.True(t, ok)
+	require.Equal(t, uint64(150), floor)
+
+	require.True(t, s.CanPruneBelow(150))
+	require.False(t, s.CanPruneBelow(151))
+}
+
+// TestFutureMilestoneSprintAlignment checks the sprint boundary check of the future milestones
+func TestFutureMilestoneSprintAlignment(t *testing.T) {
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	defer func(counter metrics.Counter) { MisalignedFutureMilestoneCounter = counter }(MisalignedFutureMilestoneCounter)
+	MisalignedFutureMilestoneCounter = metrics.NewCounterForced()
+
+	// Check disabled by default
+	s.ProcessFutureMilestone(17, common.Hash{1})
+	require.Equal(t, []uint64{17}, milestone.FutureMilestoneOrder)
+	require.Equal(t, int64(0), MisalignedFutureMilestoneCounter.Snapshot().Count())
+
+	milestone.SprintLength = 16
+
+	// Aligned milestone
+	s.ProcessFutureMilestone(32, common.Hash{2})
+	require.Equal(t, []uint64{17, 32}, milestone.FutureMilestoneOrder)
+	require.Equal(t, int64(0), MisalignedFutureMilestoneCounter.Snapshot().Count())
+
+	// Misaligned milestone is only warned about
+	s.ProcessFutureMilestone(33, common.Hash{3})
+	require.Equal(t, []uint64{17, 32, 33}, milestone.FutureMilestoneOrder)
+	require.Equal(t, int64(1), MisalignedFutureMilestoneCounter.Snapshot().Count())
+
+	milestone.RejectMisalignedFutureMilestones = true
+
+	// Misaligned milestone is rejected
+	s.ProcessFutureMilestone(47, common.Hash{4})
+	require.Equal(t, []uint64{17, 32, 33}, milestone.FutureMilestoneOrder)
+	require.Equal(t, int64(2), MisalignedFutureMilestoneCounter.Snapshot().Count())
+
+	// Aligned milestone is still accepted
+	s.ProcessFutureMilestone(48, common.Hash{5})
+	require.Equal(t, []uint64{17, 32, 33, 48}, milestone.FutureMilestoneOrder)
+	require.Equal(t, int64(2), MisalignedFutureMilestoneCounter.Snapshot().Count())
+}
+
+// TestPromoteFutureMilestone checks the promotion of a queued future milestone
+func TestPromoteFutureMilestone(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+
+	s.ProcessFutureMilestone(10, common.Hash{1})
+	s.ProcessFutureMilestone(20, common.Hash{2})
+	s.ProcessFutureMilestone(30, common.Hash{3})
+
+	// Absent number
+	err := s.PromoteFutureMilestone(25)
+	require.ErrorIs(t, err, ErrFutureMilestoneNotQueued)
+
+	doExist, _, _ := s.GetWhitelistedMilestone()
+	require.False(t, doExist, "expected nothing to be whitelisted")
+	require.Equal(t, []uint64{10, 20, 30}, milestone.FutureMilestoneOrder)
+
+	// Present number
+	require.NoError(t, s.PromoteFutureMilestone(20))
+
+	doExist, number, hash := s.GetWhitelistedMilestone()
+	require.True(t, doExist)
+	require.Equal(t, uint64(20), number)
+	require.Equal(t, common.Hash{2}, hash)
+
+	require.Equal(t, []uint64{30}, milestone.FutureMilestoneOrder, "expected the promoted and lower entries to be removed")
+	require.Equal(t, map[uint64]common.Hash{30: {3}}, milestone.FutureMilestoneList)
+
+	// Persisted state
+	number, hash, err = rawdb.ReadFinality[*rawdb.Milestone](db)
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), number)
+	require.Equal(t, common.Hash{2}, hash)
+
+	order, _, err := rawdb.ReadFutureMilestoneList(db)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{30}, order)
+
+	// A promoted milestone is no longer queued
+	require.ErrorIs(t, s.PromoteFutureMilestone(20), ErrFutureMilestoneNotQueued)
+}
+
+// TestProcessFrom checks the per source accounting of the processed milestones
+func TestProcessFrom(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	defer func(counters map[MilestoneSource]metrics.Counter) { MilestoneSourceCounters = counters }(MilestoneSourceCounters)
+	MilestoneSourceCounters = map[MilestoneSource]metrics.Counter{
+		MilestoneSourceUnknown:  metrics.NewCounterForced(),
+		MilestoneSourceHeimdall: metrics.NewCounterForced(),
+		MilestoneSourceP2P:      metrics.NewCounterForced(),
+		MilestoneSourceManual:   metrics.NewCounterForced(),
+	}
+
+	count := func(source MilestoneSource) int64 {
+		return MilestoneSourceCounters[source].Snapshot().Count()
+	}
+
+	require.Equal(t, MilestoneSourceUnknown, s.LatestMilestoneSource())
+
+	s.ProcessMilestone(10, common.Hash{1})
+	require.Equal(t, MilestoneSourceHeimdall, s.LatestMilestoneSource())
+
+	s.ProcessFrom(20, common.Hash{2}, MilestoneSourceP2P)
+	s.ProcessFrom(30, common.Hash{3}, MilestoneSourceP2P)
+	require.Equal(t, MilestoneSourceP2P, s.LatestMilestoneSource())
+
+	s.milestoneService.Process(40, common.Hash{4})
+	require.Equal(t, MilestoneSourceUnknown, s.LatestMilestoneSource())
+
+	s.ProcessFutureMilestone(50, common.Hash{5})
+	require.NoError(t, s.PromoteFutureMilestone(50))
+	require.Equal(t, MilestoneSourceManual, s.LatestMilestoneSource())
+
+	require.Equal(t, int64(1), count(MilestoneSourceHeimdall))
+	require.Equal(t, int64(2), count(MilestoneSourceP2P))
+	require.Equal(t, int64(1), count(MilestoneSourceUnknown))
+	require.Equal(t, int64(1), count(MilestoneSourceManual))
+
+	_, number, hash := s.GetWhitelistedMilestone()
+	require.Equal(t, uint64(50), number)
+	require.Equal(t, common.Hash{5}, hash)
+}
+
+// TestReconcileMilestoneIDs checks the reconciliation of the stored milestoneIDs
+// against an authoritative list
+func TestReconcileMilestoneIDs(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+
+	milestone.LockMutex(10)
+	milestone.UnlockMutex(true, "milestoneID1", 10, common.Hash{1})
+
+	milestone.LockedMilestoneIDs["milestoneID2"] = rawdb.MilestoneID{}
+	milestone.LockedMilestoneIDs["staleID1"] = rawdb.MilestoneID{}
+	milestone.LockedMilestoneIDs["staleID2"] = rawdb.MilestoneID{}
+
+	// Stale ids are removed
+	s.ReconcileMilestoneIDs([]string{"milestoneID1", "milestoneID2", "unknownID"})
+
+	ids := s.GetMilestoneIDsList()
+	sort.Strings(ids)
+	require.Equal(t, []string{"milestoneID1", "milestoneID2"}, ids)
+	require.True(t, milestone.Locked, "expected the sprint to stay locked")
+
+	locked, _, _, lockedIDs, err := rawdb.ReadLockField(db)
+	require.NoError(t, err)
+	require.True(t, locked)
+	require.Equal(t, []string{"milestoneID1", "milestoneID2"}, sortedIDs(lockedIDs))
+
+	// Emptying the set unlocks the sprint
+	s.ReconcileMilestoneIDs(nil)
+
+	require.Empty(t, s.GetMilestoneIDsList())
+	require.False(t, milestone.Locked, "expected the sprint to be unlocked")
+
+	locked, _, _, lockedIDs, err = rawdb.ReadLockField(db)
+	require.NoError(t, err)
+	require.False(t, locked)
+	require.Empty(t, lockedIDs)
+}
+
+// TestCompareAndSetMilestone checks the atomic test-and-set of the whitelisted milestone
+func TestCompareAndSetMilestone(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	// Nothing whitelisted, expecting zero
+	require.False(t, s.CompareAndSetMilestone(5, 10, common.Hash{1}), "expected mismatching swap to fail")
+	require.True(t, s.CompareAndSetMilestone(0, 10, common.Hash{1}), "expected matching swap to succeed")
+
+	doExist, number, hash := s.GetWhitelistedMilestone()
+	require.True(t, doExist)
+	require.Equal(t, uint64(10), number)
+	require.Equal(t, common.Hash{1}, hash)
+
+	// Mismatching expected value leaves the milestone untouched
+	require.False(t, s.CompareAndSetMilestone(9, 20, common.Hash{2}))
+
+	_, number, hash = s.GetWhitelistedMilestone()
+	require.Equal(t, uint64(10), number)
+	require.Equal(t, common.Hash{1}, hash)
+
+	// Matching expected value swaps and persists the milestone
+	require.True(t, s.CompareAndSetMilestone(10, 20, common.Hash{2}))
+
+	number, hash, err := rawdb.ReadFinality[*rawdb.Milestone](db)
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), number)
+	require.Equal(t, common.Hash{2}, hash)
+
+	// Concurrent swaps from the same expected value, only one wins
+	var (
+		wg   sync.WaitGroup
+		wins int32
+	)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			if s.CompareAndSetMilestone(20, uint64(30+i), common.Hash{byte(i)}) {
+				atomic.AddInt32(&wins, 1)
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	require.Equal(t, int32(1), wins, "expected exactly one swap to succeed")
+}
+
+// TestMilestoneIDsChurnMeters checks that the meters track the added and removed milestoneIDs
+func TestMilestoneIDsChurnMeters(t *testing.T) {
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	defer func(added, removed metrics.Meter) {
+		MilestoneIdsAddedMeter, MilestoneIdsRemovedMeter = added, removed
+	}(MilestoneIdsAddedMeter, MilestoneIdsRemovedMeter)
+
+	MilestoneIdsAddedMeter = metrics.NewMeterForced()
+	MilestoneIdsRemovedMeter = metrics.NewMeterForced()
+
+	defer MilestoneIdsAddedMeter.Stop()
+	defer MilestoneIdsRemovedMeter.Stop()
+
+	milestone.LockMutex(10)
+	milestone.UnlockMutex(true, "milestoneID1", 10, common.Hash{1})
+
+	require.Equal(t, int64(1), MilestoneIdsAddedMeter.Count())
+	require.Equal(t, int64(0), MilestoneIdsRemovedMeter.Count())
+
+	// A vote without lock doesn't add an id
+	milestone.LockMutex(10)
+	milestone.UnlockMutex(false, "milestoneID2", 10, common.Hash{1})
+
+	require.Equal(t, int64(1), MilestoneIdsAddedMeter.Count())
+
+	// Locking again replaces the previous id
+	milestone.LockMutex(10)
+	milestone.UnlockMutex(true, "milestoneID2", 10, common.Hash{1})
+
+	require.Equal(t, int64(2), MilestoneIdsAddedMeter.Count())
+	require.Equal(t, int64(1), MilestoneIdsRemovedMeter.Count())
+
+	// Removing an absent id isn't counted
+	milestone.RemoveMilestoneID("milestoneID1")
+	require.Equal(t, int64(1), MilestoneIdsRemovedMeter.Count())
+
+	milestone.RemoveMilestoneID("milestoneID2")
+	require.Equal(t, int64(2), MilestoneIdsRemovedMeter.Count())
+
+	// Unlocking the sprint purges the ids
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID3", 20, common.Hash{2})
+	s.ProcessMilestone(20, common.Hash{2})
+
+	require.Equal(t, int64(3), MilestoneIdsAddedMeter.Count())
+	require.Equal(t, int64(3), MilestoneIdsRemovedMeter.Count())
+}
+
+// TestOutOfOrderMilestoneCounter checks the counting of the out of order milestones
+// and their skipping by TryProcess
+func TestOutOfOrderMilestoneCounter(t *testing.T) {
+	// Metrics are disabled in tests, hence swap in an enabled counter
+	defer func(counter metrics.Counter) { OutOfOrderMilestoneCounter = counter }(OutOfOrderMilestoneCounter)
+	OutOfOrderMilestoneCounter = metrics.NewCounterForced()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	// The first milestone is never out of order
+	require.True(t, s.TryProcess(10, common.Hash{0x1}))
+	require.Equal(t, int64(0), OutOfOrderMilestoneCounter.Snapshot().Count())
+
+	// Process still applies the out of order milestones, but counts them
+	s.ProcessMilestone(10, common.Hash{0x1})
+	s.ProcessMilestone(5, common.Hash{0x5})
+	require.Equal(t, int64(2), OutOfOrderMilestoneCounter.Snapshot().Count())
+
+	_, number, _ := s.milestoneService.Get()
+	require.Equal(t, uint64(5), number)
+
+	// TryProcess skips them
+	s.ProcessMilestone(20, common.Hash{0x2})
+	require.False(t, s.TryProcess(20, common.Hash{0x2}))
+	require.False(t, s.TryProcess(15, common.Hash{0x3}))
+	require.Equal(t, int64(4), OutOfOrderMilestoneCounter.Snapshot().Count())
+
+	_, number, hash := s.milestoneService.Get()
+	require.Equal(t, uint64(20), number)
+	require.Equal(t, common.Hash{0x2}, hash)
+
+	require.True(t, s.TryProcess(30, common.Hash{0x4}))
+	require.Equal(t, int64(4), OutOfOrderMilestoneCounter.Snapshot().Count())
+}
+
+// TestLatestMilestoneWithAge checks the whitelisted milestone and its age returned together
+func TestLatestMilestoneWithAge(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	num, hash, age, ok := s.LatestMilestoneWithAge(time.Now())
+	require.False(t, ok, "expected no milestone on an empty service")
+	require.Equal(t, uint64(0), num)
+	require.Equal(t, common.Hash{}, hash)
+	require.Equal(t, time.Duration(0), age)
+
+	s.ProcessMilestone(10, common.Hash{0x1})
+
+	processedAt := milestone.lastProcessedAt
+
+	num, hash, age, ok = s.LatestMilestoneWithAge(processedAt.Add(time.Minute))
+	require.True(t, ok)
+	require.Equal(t, uint64(10), num)
+	require.Equal(t, common.Hash{0x1}, hash)
+	require.Equal(t, time.Minute, age)
+
+	// A time before the processing doesn't produce a negative age
+	_, _, age, ok = s.LatestMilestoneWithAge(processedAt.Add(-time.Minute))
+	require.True(t, ok)
+	require.Equal(t, time.Duration(0), age)
+}
+
+// TestLockedHashMismatch checks the handling of a chain mismatching the locked sprint hash
+func TestLockedHashMismatch(t *testing.T) {
+	t.Parallel()
+
+	for _, allow := range []bool{false, true} {
+		s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+		milestone.AllowLockedHashMismatch = allow
+
+		chainA := createMockChain(1, 20)
+		chainB := createMockChain(1, 20)
+
+		milestone.LockMutex(10)
+		milestone.UnlockMutex(true, "milestoneID1", 10, chainA[9].Hash())
+
+		res, err := s.IsValidChain(chainA[0], chainB)
+		require.Equal(t, allow, res, "allow: %v", allow)
+
+		if !allow {
+			require.ErrorIs(t, err, ErrReorgNotAllowed)
+		}
+
+		// A matching chain is valid either way
+		res, err = s.IsValidChain(chainA[0], chainA)
+		require.NoError(t, err)
+		require.True(t, res)
+
+		// A chain ending at the locked number is still rejected
+		res, err = s.IsValidChain(chainA[0], chainB[:10])
+		require.ErrorIs(t, err, ErrReorgNotAllowed)
+		require.False(t, res)
+	}
+}
+
+// TestPreviewProcessFutureMilestone checks the previewed action and resulting order of future milestones
+func TestPreviewProcessFutureMilestone(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+	milestone.MaxCapacity = 3
+
+	// Enqueue
+	action, order := s.PreviewProcessFutureMilestone(16, common.Hash{0x1})
+	require.Equal(t, FutureMilestoneEnqueue, action)
+	require.Equal(t, []uint64{16}, order)
+	require.Empty(t, milestone.FutureMilestoneOrder, "preview shouldn't mutate the state")
+
+	s.ProcessFutureMilestone(16, common.Hash{0x1})
+	s.ProcessFutureMilestone(32, common.Hash{0x2})
+
+	// Duplicate
+	action, order = s.PreviewProcessFutureMilestone(16, common.Hash{0x1})
+	require.Equal(t, FutureMilestoneDuplicate, action)
+	require.Equal(t, []uint64{16, 32}, order)
+
+	action, order = s.PreviewProcessFutureMilestone(48, common.Hash{0x3})
+	require.Equal(t, FutureMilestoneEnqueue, action)
+	require.Equal(t, []uint64{16, 32, 48}, order)
+
+	s.ProcessFutureMilestone(48, common.Hash{0x3})
+
+	// Full list drops the new milestone, there's no eviction
+	action, order = s.PreviewProcessFutureMilestone(64, common.Hash{0x4})
+	require.Equal(t, FutureMilestoneDropFull, action)
+	require.Equal(t, []uint64{16, 32, 48}, order)
+
+	s.ProcessFutureMilestone(64, common.Hash{0x4})
+	require.Equal(t, order, milestone.FutureMilestoneOrder, "preview should match the actual outcome")
+
+	// Misaligned milestone rejected by the policy
+	milestone.SprintLength = 16
+	milestone.RejectMisalignedFutureMilestones = true
+
+	action, order = s.PreviewProcessFutureMilestone(70, common.Hash{0x5})
+	require.Equal(t, FutureMilestoneDropMisaligned, action)
+	require.Equal(t, []uint64{16, 32, 48}, order)
+}
+
+// TestInstanceIDLogging checks the tagging of the log lines with the instance id
+func TestInstanceIDLogging(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		records []*log.Record
+	)
+
+	// The root handler is global, hence the test can't run in parallel
+	defer func(handler log.Handler) { log.Root().SetHandler(handler) }(log.Root().GetHandler())
+	log.Root().SetHandler(log.FuncHandler(func(r *log.Record) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		records = append(records, r)
+
+		return nil
+	}, log.LvlTrace))
+
+	tagged := NewMockService(rawdb.NewMemoryDatabase())
+	tagged.milestoneService.(*milestone).InstanceID = "chainA"
+
+	untagged := NewMockService(rawdb.NewMemoryDatabase())
+
+	tagged.ProcessFutureMilestone(16, common.Hash{0x1})
+	untagged.ProcessFutureMilestone(32, common.Hash{0x2})
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	instances := make(map[string]string)
+
+	for _, r := range records {
+		if r.Msg != "Enqueing new future milestone" {
+			continue
+		}
+
+		var instance string
+
+		for i := 0; i+1 < len(r.Ctx); i += 2 {
+			if r.Ctx[i] == "instance" {
+				instance = r.Ctx[i+1].(string)
+			}
+
+			if r.Ctx[i] == "endBlockNumber" {
+				instances[fmt.Sprint(r.Ctx[i+1])] = instance
+			}
+		}
+	}
+
+	require.Equal(t, map[string]string{"16": "chainA", "32": ""}, instances)
+}
+
+// TestCanRewind checks the rewinds blocked by the lock and the whitelisted milestone
+func TestCanRewind(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	ok, reason := s.CanRewind(0)
+	require.True(t, ok, "expected any rewind to be permitted without milestones")
+	require.Empty(t, reason)
+
+	s.ProcessMilestone(10, common.Hash{0x1})
+
+	ok, reason = s.CanRewind(9)
+	require.False(t, ok)
+	require.Contains(t, reason, "whitelisted milestone at 10")
+
+	ok, _ = s.CanRewind(10)
+	require.True(t, ok)
+
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID1", 20, common.Hash{0x2})
+
+	ok, reason = s.CanRewind(15)
+	require.False(t, ok)
+	require.Contains(t, reason, "locked sprint at 20")
+
+	ok, reason = s.CanRewind(5)
+	require.False(t, ok)
+	require.Contains(t, reason, "locked sprint at 20")
+
+	ok, reason = s.CanRewind(25)
+	require.True(t, ok)
+	require.Empty(t, reason)
+}
+
+// TestLastMilestoneGap checks the gap between the last two whitelisted milestones
+func TestLastMilestoneGap(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	_, ok := s.LastMilestoneGap()
+	require.False(t, ok)
+
+	s.ProcessMilestone(16, common.Hash{0x1})
+
+	_, ok = s.LastMilestoneGap()
+	require.False(t, ok, "expected no gap with a single milestone")
+
+	for i, number := range []uint64{32, 56, 64} {
+		s.ProcessMilestone(number, common.Hash{byte(i + 2)})
+	}
+
+	gap, ok := s.LastMilestoneGap()
+	require.True(t, ok)
+	require.Equal(t, uint64(8), gap)
+
+	// Processing the same milestone again doesn't change the gap
+	s.ProcessMilestone(64, common.Hash{0x4})
+
+	gap, ok = s.LastMilestoneGap()
+	require.True(t, ok)
+	require.Equal(t, uint64(8), gap)
+}
+
+// TestPauseFutureMilestones checks the pausing of the future milestones only
+func TestPauseFutureMilestones(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	s.ProcessFutureMilestone(16, common.Hash{0x1})
+	s.ProcessFutureMilestone(32, common.Hash{0x2})
+
+	s.PauseFutureMilestones()
+
+	s.ProcessFutureMilestone(48, common.Hash{0x3})
+	require.Equal(t, []uint64{16, 32}, milestone.FutureMilestoneOrder, "expected the future milestone to be ignored while paused")
+
+	// The milestones are still processed, draining the queue
+	s.ProcessMilestone(16, common.Hash{0x1})
+
+	doExist, number, _ := s.milestoneService.Get()
+	require.True(t, doExist)
+	require.Equal(t, uint64(16), number)
+	require.Equal(t, []uint64{32}, milestone.FutureMilestoneOrder)
+
+	// The queued ones are still honored
+	require.False(t, milestone.IsFutureMilestoneCompatible(createMockChain(30, 40)))
+
+	s.ResumeFutureMilestones()
+
+	s.ProcessFutureMilestone(48, common.Hash{0x3})
+	require.Equal(t, []uint64{32, 48}, milestone.FutureMilestoneOrder)
+}
+
+// TestSetFutureMilestoneCapacity checks the eviction of the oldest future milestones
+// once the capacity is lowered below the queued ones
+func TestSetFutureMilestoneCapacity(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+
+	require.ErrorIs(t, s.SetFutureMilestoneCapacity(0), ErrInvalidFutureMilestoneCapacity)
+	require.ErrorIs(t, s.SetFutureMilestoneCapacity(-1), ErrInvalidFutureMilestoneCapacity)
+	require.Equal(t, 10, milestone.MaxCapacity)
+
+	for i := uint64(1); i <= 10; i++ {
+		s.ProcessFutureMilestone(i*16, common.Hash{byte(i)})
+	}
+
+	require.Len(t, milestone.FutureMilestoneOrder, 10)
+
+	require.NoError(t, s.SetFutureMilestoneCapacity(4))
+
+	// The eviction makes room down to the capacity only, so the new one is dropped
+	action, order := s.PreviewProcessFutureMilestone(176, common.Hash{0xb})
+	require.Equal(t, FutureMilestoneDropFull, action)
+	require.Equal(t, []uint64{112, 128, 144, 160}, order)
+
+	s.ProcessFutureMilestone(176, common.Hash{0xb})
+
+	require.Equal(t, []uint64{112, 128, 144, 160}, milestone.FutureMilestoneOrder)
+	require.Len(t, milestone.FutureMilestoneList, 4)
+	require.NotContains(t, milestone.FutureMilestoneList, uint64(16))
+
+	order, list, err := rawdb.ReadFutureMilestoneList(db)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{112, 128, 144, 160}, order)
+	require.Equal(t, milestone.FutureMilestoneList, list)
+
+	// Raising the capacity again accepts the new ones
+	require.NoError(t, s.SetFutureMilestoneCapacity(5))
+
+	s.ProcessFutureMilestone(176, common.Hash{0xb})
+	require.Equal(t, []uint64{112, 128, 144, 160, 176}, milestone.FutureMilestoneOrder)
+}
+
+// TestEvictLowestOnFull checks the replacement of the lowest future milestone of a
+// full list by a higher one
+func TestEvictLowestOnFull(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+	milestone.MaxCapacity = 3
+
+	for i := uint64(2); i <= 4; i++ {
+		s.ProcessFutureMilestone(i*16, common.Hash{byte(i)})
+	}
+
+	// Disabled by default, the higher milestone is dropped
+	s.ProcessFutureMilestone(80, common.Hash{0x5})
+	require.Equal(t, []uint64{32, 48, 64}, milestone.FutureMilestoneOrder)
+
+	milestone.EvictLowestOnFull = true
+
+	// A lower milestone is still dropped
+	action, _ := s.PreviewProcessFutureMilestone(16, common.Hash{0x1})
+	require.Equal(t, FutureMilestoneDropFull, action)
+
+	s.ProcessFutureMilestone(16, common.Hash{0x1})
+	require.Equal(t, []uint64{32, 48, 64}, milestone.FutureMilestoneOrder)
+	require.NotContains(t, milestone.FutureMilestoneList, uint64(16))
+
+	// A queued one doesn't evict anything
+	s.ProcessFutureMilestone(48, common.Hash{0x3})
+	require.Equal(t, []uint64{32, 48, 64}, milestone.FutureMilestoneOrder)
+
+	// A higher milestone replaces the lowest one
+	action, order := s.PreviewProcessFutureMilestone(80, common.Hash{0x5})
+	require.Equal(t, FutureMilestoneEvictLowest, action)
+	require.Equal(t, []uint64{48, 64, 80}, order)
+
+	s.ProcessFutureMilestone(80, common.Hash{0x5})
+
+	require.Equal(t, []uint64{48, 64, 80}, milestone.FutureMilestoneOrder)
+	require.Equal(t, map[uint64]common.Hash{48: {0x3}, 64: {0x4}, 80: {0x5}}, milestone.FutureMilestoneList)
+
+	order, list, err := rawdb.ReadFutureMilestoneList(db)
+	require.NoError(t, err)
+	require.Equal(t, milestone.FutureMilestoneOrder, order)
+	require.Equal(t, milestone.FutureMilestoneList, list)
+}
+
+// TestIsFinalized checks the finality of the blocks against the whitelisted and future milestones
+func TestIsFinalized(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	// Nothing is finalized without a milestone
+	require.False(t, s.IsFinalized(0, common.Hash{}))
+	require.False(t, s.IsFinalized(10, common.Hash{10}))
+
+	s.ProcessMilestone(10, common.Hash{10})
+
+	// Below the latest milestone, regardless of the hash
+	require.True(t, s.IsFinalized(0, common.Hash{}))
+	require.True(t, s.IsFinalized(9, common.Hash{9}))
+
+	// At the latest milestone, with a matching and a mismatching hash
+	require.True(t, s.IsFinalized(10, common.Hash{10}))
+	require.False(t, s.IsFinalized(10, common.Hash{11}))
+
+	// Beyond the latest milestone
+	require.False(t, s.IsFinalized(11, common.Hash{11}))
+
+	// Future milestones, with a matching and a mismatching hash
+	s.ProcessFutureMilestone(32, common.Hash{32})
+
+	require.True(t, s.IsFinalized(32, common.Hash{32}))
+	require.False(t, s.IsFinalized(32, common.Hash{33}))
+	require.False(t, s.IsFinalized(20, common.Hash{20}))
+
+	// Purging the milestone keeps the future ones
+	s.PurgeWhitelistedMilestone()
+
+	require.False(t, s.IsFinalized(9, common.Hash{9}))
+	require.True(t, s.IsFinalized(32, common.Hash{32}))
+}
+
+// TestFutureMilestoneOccupancy checks the occupancy metrics of the future milestone list
+func TestFutureMilestoneOccupancy(t *testing.T) {
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	defer func(occupancy, ratio metrics.Gauge) {
+		FutureMilestoneOccupancyGauge, FutureMilestoneOccupancyRatioGauge = occupancy, ratio
+	}(FutureMilestoneOccupancyGauge, FutureMilestoneOccupancyRatioGauge)
+
+	FutureMilestoneOccupancyGauge = &metrics.StandardGauge{}
+	FutureMilestoneOccupancyRatioGauge = &metrics.StandardGauge{}
+
+	require.NoError(t, s.SetFutureMilestoneCapacity(4))
+
+	s.ProcessFutureMilestone(16, common.Hash{16})
+	s.ProcessFutureMilestone(32, common.Hash{32})
+	s.ProcessFutureMilestone(48, common.Hash{48})
+
+	require.Equal(t, int64(3), FutureMilestoneOccupancyGauge.Snapshot().Value())
+	require.Equal(t, int64(7500), FutureMilestoneOccupancyRatioGauge.Snapshot().Value())
+
+	// A duplicate doesn't change the occupancy
+	s.ProcessFutureMilestone(48, common.Hash{48})
+	require.Equal(t, int64(3), FutureMilestoneOccupancyGauge.Snapshot().Value())
+
+	s.ProcessFutureMilestone(64, common.Hash{64})
+	require.Equal(t, int64(4), FutureMilestoneOccupancyGauge.Snapshot().Value())
+	require.Equal(t, int64(10000), FutureMilestoneOccupancyRatioGauge.Snapshot().Value())
+
+	// Changing the capacity updates the ratio
+	require.NoError(t, s.SetFutureMilestoneCapacity(8))
+	require.Equal(t, int64(4), FutureMilestoneOccupancyGauge.Snapshot().Value())
+	require.Equal(t, int64(5000), FutureMilestoneOccupancyRatioGauge.Snapshot().Value())
+
+	// Processing a milestone dequeues the future ones at or below it
+	s.ProcessMilestone(32, common.Hash{32})
+	require.Equal(t, []uint64{48, 64}, milestone.FutureMilestoneOrder)
+	require.Equal(t, int64(2), FutureMilestoneOccupancyGauge.Snapshot().Value())
+	require.Equal(t, int64(2500), FutureMilestoneOccupancyRatioGauge.Snapshot().Value())
+
+	s.DrainFutureMilestones()
+	require.Equal(t, int64(0), FutureMilestoneOccupancyGauge.Snapshot().Value())
+	require.Equal(t, int64(0), FutureMilestoneOccupancyRatioGauge.Snapshot().Value())
+}
+
+// TestLockMilestone checks the atomic locking of a sprint along with its hash
+func TestLockMilestone(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+
+	locks := make(chan MilestoneLockEvent, 1024)
+	sub := s.SubscribeMilestoneLocks(locks)
+	defer sub.Unsubscribe()
+
+	var (
+		wg      sync.WaitGroup
+		stop    = make(chan struct{})
+		checked atomic.Int64
+	)
+
+	// Observe the state concurrently with the locking
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+
+		for {
+			status := s.MilestoneStatus()
+			if status.Locked && status.LockedMilestoneHash == (common.Hash{}) {
+				t.Errorf("observed a locked sprint %d without hash", status.LockedMilestoneNumber)
+			}
+
+			checked.Add(1)
+
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}()
+
+	for i := uint64(1); i <= 200; i++ {
+		require.True(t, s.LockMilestone(i*16, common.Hash{byte(i), 1}, fmt.Sprintf("milestoneID%d", i)))
+	}
+
+	close(stop)
+	wg.Wait()
+
+	require.Positive(t, checked.Load())
+
+	for len(locks) > 0 {
+		event := <-locks
+		require.False(t, event.Locked && event.Hash == (common.Hash{}), "lock event without hash")
+	}
+
+	require.True(t, milestone.Locked)
+	require.Equal(t, uint64(3200), milestone.LockedMilestoneNumber)
+	require.Equal(t, common.Hash{200, 1}, milestone.LockedMilestoneHash)
+	require.Equal(t, []string{"milestoneID200"}, s.GetMilestoneIDsList())
+
+	locked, lockedNumber, lockedHash, lockedIDs, err := rawdb.ReadLockField(db)
+	require.NoError(t, err)
+	require.True(t, locked)
+	require.Equal(t, uint64(3200), lockedNumber)
+	require.Equal(t, common.Hash{200, 1}, lockedHash)
+	require.Equal(t, []string{"milestoneID200"}, sortedIDs(lockedIDs))
+
+	// Same refusals as LockMutex: below the locked sprint
+	require.False(t, s.LockMilestone(3184, common.Hash{1}, "milestoneIDLow"))
+
+	// Re-locking the same sprint supersedes the lock, like UnlockMutex
+	require.True(t, s.LockMilestone(3200, common.Hash{200, 1}, "milestoneIDSame"))
+	require.Equal(t, []string{"milestoneIDSame"}, s.GetMilestoneIDsList())
+
+	// At or below the whitelisted milestone, and a too large block number
+	s.ProcessMilestone(4000, common.Hash{2})
+
+	require.False(t, s.LockMilestone(4000, common.Hash{3}, "milestoneIDWhitelisted"))
+	require.False(t, s.LockMilestone(maxBlockNumber+1, common.Hash{3}, "milestoneIDTooLarge"))
+	require.False(t, milestone.Locked)
+
+	require.True(t, s.LockMilestone(4016, common.Hash{4}, "milestoneID4016"))
+	require.True(t, milestone.Locked)
+
+	// The lock isn't left held
+	require.True(t, milestone.finality.TryLock())
+	milestone.finality.Unlock()
+}
+
+// TestSlowSubscriber checks that a subscriber not reading its events doesn't stall the
+// users of the finality lock, the events being sent once the lock is released
+func TestSlowSubscriber(t *testing.T) {
+	t.Parallel()
+
+	s := NewMockService(rawdb.NewMemoryDatabase())
+
+	updates := make(chan MilestoneUpdateEvent)
+	sub := s.SubscribeMilestoneUpdates(updates)
+	defer sub.Unsubscribe()
+
+	processed := make(chan struct{})
+
+	go func() {
+		defer close(processed)
+
+		s.ProcessMilestone(10, common.Hash{1})
+		s.ProcessMilestone(20, common.Hash{2})
+	}()
+
+	// The processing waits for the subscriber, but not under the lock
+	require.Eventually(t, func() bool {
+		doExist, number, _ := s.GetWhitelistedMilestone()
+		return doExist && number == 10
+	}, time.Second, 10*time.Millisecond)
+
+	chain := createMockChain(1, 20)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		_, _ = s.IsValidChain(chain[len(chain)-1], chain)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the validation not to wait for the subscriber")
+	}
+
+	// The events are delivered in order
+	require.Equal(t, uint64(10), (<-updates).Number)
+	require.Equal(t, uint64(20), (<-updates).Number)
+
+	<-processed
+}
+
+// TestProcessFutureMilestoneConcurrent checks that the future milestones can be processed
+// concurrently with their readers, which the race detector verifies
+func TestProcessFutureMilestoneConcurrent(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+
+		go func(i int) {
+			defer wg.Done()
+			s.ProcessFutureMilestone(uint64(i+1)*16, common.Hash{byte(i)})
+		}(i)
+
+		go func(i int) {
+			defer wg.Done()
+			milestone.GetFutureMilestone(uint64(i+1) * 16)
+			milestone.FutureMilestonesSorted()
+		}(i)
+	}
+
+	wg.Wait()
+
+	require.Len(t, milestone.FutureMilestonesSorted(), 8)
+}
+
+// TestGetFutureMilestone checks the lookup of the queued future milestones
+func TestGetFutureMilestone(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	_, ok := s.GetFutureMilestone(16)
+	require.False(t, ok)
+	require.Zero(t, s.FutureMilestoneCount())
+
+	s.ProcessFutureMilestone(16, common.Hash{16})
+	s.ProcessFutureMilestone(32, common.Hash{32})
+
+	hash, ok := s.GetFutureMilestone(16)
+	require.True(t, ok)
+	require.Equal(t, common.Hash{16}, hash)
+
+	hash, ok = s.GetFutureMilestone(32)
+	require.True(t, ok)
+	require.Equal(t, common.Hash{32}, hash)
+
+	hash, ok = s.GetFutureMilestone(24)
+	require.False(t, ok)
+	require.Equal(t, common.Hash{}, hash)
+
+	require.Equal(t, 2, s.FutureMilestoneCount())
+
+	// Processing a milestone dequeues the future ones at or below it
+	s.ProcessMilestone(16, common.Hash{16})
+
+	_, ok = s.GetFutureMilestone(16)
+	require.False(t, ok)
+	require.Equal(t, 1, s.FutureMilestoneCount())
+}
+
+// TestFutureMilestoneOrderSorted checks that the future milestones arriving out of
+// order are kept sorted, so that the highest applicable one is checked
+func TestFutureMilestoneOrderSorted(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+
+	chainA := createMockChain(1, 60)
+	chainB := createMockChain(1, 60)
+
+	for _, number := range []uint64{48, 16, 32, 16, 8} {
+		s.ProcessFutureMilestone(number, chainA[number-1].Hash())
+		require.True(t, slices.IsSorted(milestone.FutureMilestoneOrder), "order %v isn't sorted", milestone.FutureMilestoneOrder)
+	}
+
+	require.Equal(t, []uint64{8, 16, 32, 48}, milestone.FutureMilestoneOrder)
+
+	// The chain ending at 40 is checked against the milestone at 32, the highest applicable
+	mismatchAt32 := append(append([]*types.Header{}, chainA[:31]...), chainB[31:40]...)
+	require.False(t, milestone.IsFutureMilestoneCompatible(mismatchAt32))
+
+	mismatchAt16 := append(append([]*types.Header{}, chainB[:16]...), chainA[16:40]...)
+	require.True(t, milestone.IsFutureMilestoneCompatible(mismatchAt16))
+
+	// The eviction beyond a lowered capacity drops the lowest milestones
+	require.NoError(t, s.SetFutureMilestoneCapacity(2))
+
+	s.ProcessFutureMilestone(24, chainA[23].Hash())
+	require.Equal(t, []uint64{32, 48}, milestone.FutureMilestoneOrder)
+
+	// An unsorted order persisted by an older version is sorted on load
+	require.NoError(t, rawdb.WriteFutureMilestoneList(db, []uint64{48, 16, 32}, map[uint64]common.Hash{16: {16}, 32: {32}, 48: {48}}))
+
+	loaded := NewService(db).Snapshot()
+	require.Equal(t, []uint64{16, 32, 48}, loaded.FutureMilestoneOrder)
+}
+
+// TestDroppedFutureMilestone checks the counting of the future milestones dropped by a full list
+func TestDroppedFutureMilestone(t *testing.T) {
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	defer func(counter metrics.Counter) { DroppedFutureMilestoneCounter = counter }(DroppedFutureMilestoneCounter)
+	DroppedFutureMilestoneCounter = metrics.NewCounterForced()
+
+	for i := 1; i <= milestone.MaxCapacity; i++ {
+		s.ProcessFutureMilestone(uint64(i)*16, common.Hash{byte(i)})
+	}
+
+	require.Len(t, milestone.FutureMilestoneOrder, milestone.MaxCapacity)
+	require.Equal(t, int64(0), DroppedFutureMilestoneCounter.Snapshot().Count())
+
+	// A duplicate of a queued milestone isn't dropped
+	s.ProcessFutureMilestone(16, common.Hash{1})
+	require.Equal(t, int64(0), DroppedFutureMilestoneCounter.Snapshot().Count())
+
+	s.ProcessFutureMilestone(uint64(milestone.MaxCapacity+1)*16, common.Hash{0xff})
+	require.Equal(t, int64(1), DroppedFutureMilestoneCounter.Snapshot().Count())
+	require.Len(t, milestone.FutureMilestoneOrder, milestone.MaxCapacity)
+
+	// Evicting the lowest milestone instead doesn't drop the incoming one
+	milestone.EvictLowestOnFull = true
+
+	s.ProcessFutureMilestone(uint64(milestone.MaxCapacity+2)*16, common.Hash{0xfe})
+	require.Equal(t, int64(1), DroppedFutureMilestoneCounter.Snapshot().Count())
+}
+
+// TestUnlockSprintIdempotent checks that UnlockSprint only persists an actual change of the lock
+func TestUnlockSprintIdempotent(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+
+	// Already unlocked, nothing is written
+	version := s.StateVersion()
+
+	require.False(t, s.UnlockSprint(16))
+	require.Equal(t, version, s.StateVersion())
+
+	_, _, _, _, err := rawdb.ReadLockField(db)
+	require.Error(t, err, "expected no lock field to be written")
+
+	require.True(t, s.LockMilestone(32, common.Hash{32}, "milestoneID1"))
+
+	// Below the locked sprint, nothing is written
+	version = s.StateVersion()
+
+	require.False(t, s.UnlockSprint(16))
+	require.Equal(t, version, s.StateVersion())
+	require.True(t, milestone.Locked)
+
+	// The transition is written once
+	require.True(t, s.UnlockSprint(32))
+	require.Equal(t, version+1, s.StateVersion())
+	require.False(t, milestone.Locked)
+	require.Empty(t, milestone.LockedMilestoneIDs)
+
+	locked, _, _, ids, err := rawdb.ReadLockField(db)
+	require.NoError(t, err)
+	require.False(t, locked)
+	require.Empty(t, ids)
+
+	// Unlocking again is a no-op
+	require.False(t, s.UnlockSprint(32))
+	require.Equal(t, version+1, s.StateVersion())
+}
+
+// TestMilestoneFinalityLag checks the gauge of the lag of the whitelisted milestone behind the current header
+func TestMilestoneFinalityLag(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	defer func(gauge metrics.Gauge) { MilestoneFinalityLagGauge = gauge }(MilestoneFinalityLagGauge)
+
+	MilestoneFinalityLagGauge = &metrics.StandardGauge{}
+
+	chain := createMockChain(1, 40)
+
+	// No lag without a milestone
+	_, err := s.IsValidChain(chain[29], chain[30:])
+	require.NoError(t, err)
+	require.Zero(t, MilestoneFinalityLagGauge.Snapshot().Value())
+
+	s.ProcessMilestone(20, chain[19].Hash())
+
+	_, err = s.IsValidChain(chain[29], chain[30:])
+	require.NoError(t, err)
+	require.Equal(t, int64(10), MilestoneFinalityLagGauge.Snapshot().Value())
+
+	// Clamped at zero when the milestone is ahead of the current header
+	_, _ = s.IsValidChain(chain[9], chain[10:])
+	require.Zero(t, MilestoneFinalityLagGauge.Snapshot().Value())
+}
+
+// TestPurgeAll checks the wipe of the whole milestone state, in memory and in the db
+func TestPurgeAll(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	s.ProcessMilestone(16, common.Hash{0x1})
+	require.True(t, s.LockMilestone(32, common.Hash{0x2}, "milestoneID1"))
+	s.ProcessFutureMilestone(64, common.Hash{0x4})
+	s.ProcessFutureMilestone(80, common.Hash{0x5})
+
+	// Unlocked by the future milestones, lock again
+	require.True(t, s.LockMilestone(96, common.Hash{0x6}, "milestoneID2"))
+
+	events := make(chan MilestoneLockEvent, 1)
+	sub := s.SubscribeMilestoneLocks(events)
+	defer sub.Unsubscribe()
+
+	require.NoError(t, s.PurgeAll())
+
+	snapshot := s.Snapshot()
+	require.False(t, snapshot.DoExist)
+	require.Zero(t, snapshot.Number)
+	require.Equal(t, common.Hash{}, snapshot.Hash)
+	require.False(t, snapshot.Locked)
+	require.Zero(t, snapshot.LockedMilestoneNumber)
+	require.Equal(t, common.Hash{}, snapshot.LockedMilestoneHash)
+	require.Empty(t, snapshot.LockedMilestoneIDs)
+	require.Empty(t, snapshot.FutureMilestoneList)
+	require.Empty(t, snapshot.FutureMilestoneOrder)
+
+	require.False(t, (<-events).Locked)
+
+	lifecycle, ok := s.LastLockLifecycle()
+	require.True(t, ok)
+	require.Equal(t, UnlockReasonPurged, lifecycle.UnlockReason)
+
+	_, _, err := rawdb.ReadFinality[*rawdb.Milestone](db)
+	require.Error(t, err)
+
+	_, _, _, _, err = rawdb.ReadLockField(db)
+	require.Error(t, err)
+
+	_, _, err = rawdb.ReadFutureMilestoneList(db)
+	require.Error(t, err)
+
+	// A restarted service starts from scratch
+	snapshot = NewService(db).Snapshot()
+	require.False(t, snapshot.DoExist)
+	require.False(t, snapshot.Locked)
+	require.Empty(t, snapshot.FutureMilestoneOrder)
+
+	// The service keeps working after the purge
+	s.ProcessMilestone(16, common.Hash{0x1})
+
+	doExist, number, hash := s.GetWhitelistedMilestone()
+	require.True(t, doExist)
+	require.Equal(t, uint64(16), number)
+	require.Equal(t, common.Hash{0x1}, hash)
+}
+
+// TestConflictingMilestoneID checks that a milestone id received again with another hash is ignored
+func TestConflictingMilestoneID(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	defer func(counter metrics.Counter) { MilestoneIDConflictCounter = counter }(MilestoneIDConflictCounter)
+
+	MilestoneIDConflictCounter = metrics.NewCounterForced()
+
+	require.True(t, s.LockMutex(32))
+	s.UnlockMutex(true, "milestoneID1", 32, common.Hash{0x1})
+
+	// Same id, same hash
+	require.True(t, s.LockMutex(32))
+	s.UnlockMutex(true, "milestoneID1", 32, common.Hash{0x1})
+	require.Zero(t, MilestoneIDConflictCounter.Snapshot().Count())
+
+	// Same id, another hash
+	require.True(t, s.LockMutex(32))
+	s.UnlockMutex(true, "milestoneID1", 32, common.Hash{0x2})
+	require.Equal(t, int64(1), MilestoneIDConflictCounter.Snapshot().Count())
+
+	require.False(t, s.LockMilestone(48, common.Hash{0x3}, "milestoneID1"))
+	require.Equal(t, int64(2), MilestoneIDConflictCounter.Snapshot().Count())
+
+	snapshot := s.Snapshot()
+	require.True(t, snapshot.Locked)
+	require.Equal(t, uint64(32), snapshot.LockedMilestoneNumber)
+	require.Equal(t, common.Hash{0x1}, snapshot.LockedMilestoneHash)
+	require.Equal(t, []string{"milestoneID1"}, s.GetMilestoneIDsList())
+
+	// The original hash is persisted
+	_, _, hash, _, err := rawdb.ReadLockField(db)
+	require.NoError(t, err)
+	require.Equal(t, common.Hash{0x1}, hash)
+
+	// Another id locks as usual
+	require.True(t, s.LockMilestone(48, common.Hash{0x3}, "milestoneID2"))
+	require.Equal(t, common.Hash{0x3}, s.Snapshot().LockedMilestoneHash)
+	require.Equal(t, int64(2), MilestoneIDConflictCounter.Snapshot().Count())
+}
+
+// TestFutureMilestonesSorted checks the copy of the future milestones in ascending order
+func TestFutureMilestonesSorted(t *testing.T) {
+	t.Parallel()
+
+	s := NewMockService(rawdb.NewMemoryDatabase())
+
+	require.Empty(t, s.FutureMilestonesSorted())
+
+	s.ProcessFutureMilestone(48, common.Hash{0x3})
+	s.ProcessFutureMilestone(16, common.Hash{0x1})
+	s.ProcessFutureMilestone(64, common.Hash{0x4})
+	s.ProcessFutureMilestone(32, common.Hash{0x2})
+
+	pins := s.FutureMilestonesSorted()
+	require.Equal(t, []MilestonePin{
+		{Number: 16, Hash: common.Hash{0x1}},
+		{Number: 32, Hash: common.Hash{0x2}},
+		{Number: 48, Hash: common.Hash{0x3}},
+		{Number: 64, Hash: common.Hash{0x4}},
+	}, pins)
+
+	// The copy doesn't follow the live list
+	pins[0].Hash = common.Hash{0xff}
+	s.ProcessMilestone(32, common.Hash{0x2})
+
+	require.Len(t, pins, 4)
+	require.Equal(t, []MilestonePin{
+		{Number: 48, Hash: common.Hash{0x3}},
+		{Number: 64, Hash: common.Hash{0x4}},
+	}, s.FutureMilestonesSorted())
+}
+
+// TestFutureMilestoneCompatibility checks the reported comparison against the future milestones
+func TestFutureMilestoneCompatibility(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 40)
+	chainB := createMockChain(1, 40)
+
+	// No future milestone applies
+	match, skip, info := milestone.FutureMilestoneCompatibility(chainB)
+	require.True(t, match)
+	require.False(t, skip)
+	require.Nil(t, info)
+
+	s.ProcessFutureMilestone(16, chainA[15].Hash())
+	s.ProcessFutureMilestone(32, chainA[31].Hash())
+
+	match, skip, info = milestone.FutureMilestoneCompatibility(chainA)
+	require.True(t, match)
+	require.False(t, skip)
+	require.Equal(t, &FutureMilestoneMatch{Number: 32, Expected: chainA[31].Hash(), Actual: chainA[31].Hash(), Present: true}, info)
+
+	match, _, info = milestone.FutureMilestoneCompatibility(chainB[:20])
+	require.False(t, match)
+	require.Equal(t, &FutureMilestoneMatch{Number: 16, Expected: chainA[15].Hash(), Actual: chainB[15].Hash(), Present: true}, info)
+	require.False(t, milestone.IsFutureMilestoneCompatible(chainB[:20]))
+
+	// A chain spanning a future milestone without its block
+	milestone.RequirePresentFutureMilestones = true
+
+	sparse := append(append([]*types.Header{}, chainA[20:31]...), chainA[32:]...)
+
+	match, _, info = milestone.FutureMilestoneCompatibility(sparse)
+	require.False(t, match)
+	require.Equal(t, &FutureMilestoneMatch{Number: 32, Expected: chainA[31].Hash()}, info)
+
+	// Skipped checks
+	match, skip, info = milestone.FutureMilestoneCompatibility(nil)
+	require.True(t, match)
+	require.True(t, skip)
+	require.Nil(t, info)
+
+	milestone.MinChainLenForFutureCheck = 5
+
+	match, skip, info = milestone.FutureMilestoneCompatibility(chainB[15:17])
+	require.True(t, match)
+	require.True(t, skip)
+	require.Nil(t, info)
+}
+
+// TestMilestoneEnabled checks that an enabled and a disabled milestone behave
+// independently in the same process
+func TestMilestoneEnabled(t *testing.T) {
+	t.Parallel()
+
+	chainA := createMockChain(1, 20)
+	chainB := createMockChain(1, 20)
+
+	enabled := NewService(rawdb.NewMemoryDatabase())
+	disabled := NewService(rawdb.NewMemoryDatabase(), WithMilestoneEnabled(false))
+
+	require.Equal(t, flags.Milestone, enabled.milestoneService.(*milestone).enabled)
+	require.False(t, disabled.milestoneService.(*milestone).enabled)
+
+	enabled.ProcessMilestone(10, chainA[9].Hash())
+	disabled.ProcessMilestone(10, chainA[9].Hash())
+
+	res, err := enabled.IsValidChain(chainB[19], chainB)
+	require.False(t, res)
+	require.ErrorIs(t, err, ErrFinalityMismatch)
+
+	res, err = disabled.IsValidChain(chainB[19], chainB)
+	require.True(t, res)
+	require.NoError(t, err)
+
+	validator, _ := disabled.SnapshotValidator()
+	res, err = validator.IsValidChain(chainB[19], chainB)
+	require.True(t, res)
+	require.NoError(t, err)
+
+	fetch := func(number uint64, _ int, _ int, _ bool) ([]*types.Header, []common.Hash, error) {
+		return []*types.Header{chainB[number-1]}, []common.Hash{chainB[number-1].Hash()}, nil
+	}
+
+	verdict, err := enabled.milestoneService.CheckPeer(fetch)
+	require.ErrorIs(t, err, ErrMismatch)
+	require.Equal(t, PeerDiverged, verdict.Kind)
+
+	verdict, err = disabled.milestoneService.CheckPeer(fetch)
+	require.NoError(t, err)
+	require.Equal(t, PeerUnchecked, verdict.Kind)
+}
+
+// TestFastforward checks that the milestone is fast-forwarded without touching the
+// future milestones, and never moved backwards
+func TestFastforward(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+
+	mirror := NewMilestoneMirror(s)
+	defer mirror.Stop()
+
+	var notified []uint64
+
+	s.SubscribeMilestone(func(number uint64, hash common.Hash) {
+		notified = append(notified, number)
+	})
+
+	require.True(t, s.Fastforward(10, common.Hash{0x1}))
+
+	s.ProcessFutureMilestone(30, common.Hash{0x3})
+
+	require.True(t, s.Fastforward(40, common.Hash{0x4}))
+
+	doExist, number, hash := s.milestoneService.Get()
+	require.True(t, doExist)
+	require.Equal(t, uint64(40), number)
+	require.Equal(t, common.Hash{0x4}, hash)
+	require.Equal(t, []uint64{30}, milestone.FutureMilestoneOrder)
+
+	number, hash, err := rawdb.ReadFinality[*rawdb.Milestone](db)
+	require.NoError(t, err)
+	require.Equal(t, uint64(40), number)
+	require.Equal(t, common.Hash{0x4}, hash)
+
+	// Notified and recorded like a processed milestone
+	require.Equal(t, []uint64{10, 40}, notified)
+	require.Equal(t, []uint64{10, 40}, []uint64{s.RecentMilestones()[0].Number, s.RecentMilestones()[1].Number})
+	require.False(t, milestone.lastProcessedAt.IsZero())
+
+	require.Eventually(t, func() bool {
+		_, number, hash := mirror.Get()
+		return number == 40 && hash == common.Hash{0x4}
+	}, time.Second, 10*time.Millisecond, "expected the mirror to follow the fast-forward")
+
+	// Backwards and to the current number are no-ops
+	require.False(t, s.Fastforward(20, common.Hash{0x2}))
+	require.False(t, s.Fastforward(40, common.Hash{0x5}))
+
+	_, number, hash = s.milestoneService.Get()
+	require.Equal(t, uint64(40), number)
+	require.Equal(t, common.Hash{0x4}, hash)
+	require.Equal(t, []uint64{10, 40}, notified)
+
+	number, hash, err = rawdb.ReadFinality[*rawdb.Milestone](db)
+	require.NoError(t, err)
+	require.Equal(t, uint64(40), number)
+	require.Equal(t, common.Hash{0x4}, hash)
+}
+
+// TestRepairFutureMilestones checks that the future milestones loaded out of sync
+// between the order and the list are repaired, and the repair persisted
+func TestRepairFutureMilestones(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+
+	// 32 has no hash, 48 is duplicated and 64 isn't ordered
+	order := []uint64{16, 32, 48, 48}
+	list := map[uint64]common.Hash{16: {0x1}, 48: {0x3}, 64: {0x4}}
+
+	require.NoError(t, rawdb.WriteFutureMilestoneList(db, order, list))
+
+	s := NewService(db)
+
+	milestone := s.milestoneService.(*milestone)
+
+	expectedOrder := []uint64{16, 48}
+	expectedList := map[uint64]common.Hash{16: {0x1}, 48: {0x3}}
+
+	require.Equal(t, expectedOrder, milestone.FutureMilestoneOrder)
+	require.Equal(t, expectedList, milestone.FutureMilestoneList)
+	require.True(t, milestone.checkFutureMilestoneInvariant())
+
+	order, list, err := rawdb.ReadFutureMilestoneList(db)
+	require.NoError(t, err)
+	require.Equal(t, expectedOrder, order)
+	require.Equal(t, expectedList, list)
+
+	// A consistent list is left untouched
+	_, _, dropped := repairFutureMilestones(expectedOrder, expectedList)
+	require.Empty(t, dropped)
+
+	_, _, dropped = repairFutureMilestones([]uint64{16, 32, 48, 48}, map[uint64]common.Hash{16: {0x1}, 48: {0x3}, 64: {0x4}})
+	require.Equal(t, []uint64{32, 48, 64}, dropped)
+}
@@ -0,0 +1,154 @@
+package whitelist
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/event"
+)
+
+// MilestoneMirror is a read-only replica of another milestone service. It
+// follows the update and lock feeds of the primary and mirrors its state,
+// which lets an observer track finality without its own heimdall connection.
+type MilestoneMirror struct {
+	mu sync.RWMutex
+
+	doExist bool
+	Number  uint64
+	Hash    common.Hash
+
+	Locked                bool
+	LockedMilestoneNumber uint64
+	LockedMilestoneHash   common.Hash
+	LockedMilestoneIDs    []string
+
+	updateCh  chan MilestoneUpdateEvent
+	lockCh    chan MilestoneLockEvent
+	updateSub event.Subscription
+	lockSub   event.Subscription
+	quit      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewMilestoneMirror creates a mirror of the milestone state of the given service.
+// The mirror starts from the current state of the primary and follows it until
+// Stop is called.
+func NewMilestoneMirror(s *Service) *MilestoneMirror {
+	mirror := &MilestoneMirror{
+		updateCh: make(chan MilestoneUpdateEvent, 16),
+		lockCh:   make(chan MilestoneLockEvent, 16),
+		quit:     make(chan struct{}),
+	}
+
+	// Subscribe before reading the initial state, so no update is missed
+	mirror.updateSub = s.milestoneService.SubscribeMilestoneUpdates(mirror.updateCh)
+	mirror.lockSub = s.milestoneService.SubscribeMilestoneLocks(mirror.lockCh)
+
+	if m, ok := s.milestoneService.(*milestone); ok {
+		m.finality.RLock()
+
+		mirror.doExist = m.doExist
+		mirror.Number = m.Number
+		mirror.Hash = m.Hash
+		mirror.Locked = m.Locked
+		mirror.LockedMilestoneNumber = m.LockedMilestoneNumber
+		mirror.LockedMilestoneHash = m.LockedMilestoneHash
+
+		for id := range m.LockedMilestoneIDs {
+			mirror.LockedMilestoneIDs = append(mirror.LockedMilestoneIDs, id)
+		}
+
+		m.finality.RUnlock()
+	}
+
+	mirror.wg.Add(1)
+
+	go mirror.loop()
+
+	return mirror
+}
+
+func (mm *MilestoneMirror) loop() {
+	defer mm.wg.Done()
+
+	for {
+		select {
+		case ev := <-mm.updateCh:
+			mm.mu.Lock()
+			mm.doExist = !ev.Purged
+			mm.Number = ev.Number
+			mm.Hash = ev.Hash
+			mm.mu.Unlock()
+		case ev := <-mm.lockCh:
+			mm.mu.Lock()
+			mm.Locked = ev.Locked
+			mm.LockedMilestoneNumber = ev.Number
+			mm.LockedMilestoneHash = ev.Hash
+			mm.LockedMilestoneIDs = ev.IDs
+			mm.mu.Unlock()
+		case <-mm.updateSub.Err():
+			return
+		case <-mm.lockSub.Err():
+			return
+		case <-mm.quit:
+			return
+		}
+	}
+}
+
+// Stop unsubscribes the mirror from the primary.
+func (mm *MilestoneMirror) Stop() {
+	mm.updateSub.Unsubscribe()
+	mm.lockSub.Unsubscribe()
+	close(mm.quit)
+	mm.wg.Wait()
+}
+
+// Get returns the mirrored whitelisted milestone of the form (doExist,block number,block hash.)
+func (mm *MilestoneMirror) Get() (bool, uint64, common.Hash) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	return mm.doExist, mm.Number, mm.Hash
+}
+
+// GetLock returns the mirrored lock state of the form (locked,block number,block hash.)
+func (mm *MilestoneMirror) GetLock() (bool, uint64, common.Hash) {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	return mm.Locked, mm.LockedMilestoneNumber, mm.LockedMilestoneHash
+}
+
+// GetMilestoneIDsList returns the mirrored list of locked milestone ids.
+func (mm *MilestoneMirror) GetMilestoneIDsList() []string {
+	mm.mu.RLock()
+	defer mm.mu.RUnlock()
+
+	return append([]string(nil), mm.LockedMilestoneIDs...)
+}
+
+// Process is not supported on a mirror.
+func (mm *MilestoneMirror) Process(block uint64, hash common.Hash) error {
+	return ErrReadOnly
+}
+
+// ProcessFutureMilestone is not supported on a mirror.
+func (mm *MilestoneMirror) ProcessFutureMilestone(num uint64, hash common.Hash) error {
+	return ErrReadOnly
+}
+
+// RemoveMilestoneID is not supported on a mirror.
+func (mm *MilestoneMirror) RemoveMilestoneID(milestoneId string) error {
+	return ErrReadOnly
+}
+
+// UnlockSprint is not supported on a mirror.
+func (mm *MilestoneMirror) UnlockSprint(endBlockNum uint64) error {
+	return ErrReadOnly
+}
+
+// Purge is not supported on a mirror.
+func (mm *MilestoneMirror) Purge() error {
+	return ErrReadOnly
+}
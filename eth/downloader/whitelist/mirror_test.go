@@ -0,0 +1,101 @@
+package whitelist
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// TestMilestoneMirror checks that a mirror follows the updates of the primary
+// and rejects every mutating call
+func TestMilestoneMirror(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	s.ProcessMilestone(10, common.Hash{1})
+
+	mirror := NewMilestoneMirror(s)
+	defer mirror.Stop()
+
+	doExist, number, hash := mirror.Get()
+	require.True(t, doExist, "expected the initial state of the primary to be mirrored")
+	require.Equal(t, uint64(10), number)
+	require.Equal(t, common.Hash{1}, hash)
+
+	s.ProcessMilestone(20, common.Hash{2})
+
+	require.Eventually(t, func() bool {
+		_, number, hash := mirror.Get()
+		return number == 20 && hash == common.Hash{2}
+	}, time.Second, 10*time.Millisecond, "expected the mirror to follow the processed milestone")
+
+	s.LockMutex(30)
+	s.UnlockMutex(true, "milestoneID1", 30, common.Hash{3})
+
+	require.Eventually(t, func() bool {
+		locked, number, hash := mirror.GetLock()
+		return locked && number == 30 && hash == common.Hash{3}
+	}, time.Second, 10*time.Millisecond, "expected the mirror to follow the lock")
+	require.Equal(t, []string{"milestoneID1"}, mirror.GetMilestoneIDsList())
+
+	s.ProcessMilestone(30, common.Hash{3})
+
+	require.Eventually(t, func() bool {
+		locked, _, _ := mirror.GetLock()
+		return !locked && len(mirror.GetMilestoneIDsList()) == 0
+	}, time.Second, 10*time.Millisecond, "expected the mirror to follow the unlock")
+
+	// All the mutating calls are rejected
+	require.ErrorIs(t, mirror.Process(40, common.Hash{4}), ErrReadOnly)
+	require.ErrorIs(t, mirror.ProcessFutureMilestone(40, common.Hash{4}), ErrReadOnly)
+	require.ErrorIs(t, mirror.RemoveMilestoneID("milestoneID1"), ErrReadOnly)
+	require.ErrorIs(t, mirror.UnlockSprint(40), ErrReadOnly)
+	require.ErrorIs(t, mirror.Purge(), ErrReadOnly)
+
+	_, number, _ = mirror.Get()
+	require.Equal(t, uint64(30), number, "expected the mirror state to be unchanged by rejected calls")
+}
+
+// TestMilestoneMirrorPurge checks that the mirror follows the purge and the restore of the primary
+func TestMilestoneMirrorPurge(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	s.ProcessMilestone(10, common.Hash{1})
+	require.True(t, s.LockMilestone(20, common.Hash{2}, "milestoneID1"))
+
+	snapshot := milestone.Snapshot()
+
+	mirror := NewMilestoneMirror(s)
+	defer mirror.Stop()
+
+	milestone.Purge()
+
+	require.Eventually(t, func() bool {
+		doExist, _, _ := mirror.Get()
+		return !doExist
+	}, time.Second, 10*time.Millisecond, "expected the mirror to follow the purge")
+
+	milestone.Restore(snapshot)
+
+	require.Eventually(t, func() bool {
+		doExist, number, hash := mirror.Get()
+		return doExist && number == 10 && hash == common.Hash{1}
+	}, time.Second, 10*time.Millisecond, "expected the mirror to follow the restore")
+
+	require.NoError(t, milestone.PurgeAll())
+
+	require.Eventually(t, func() bool {
+		doExist, number, _ := mirror.Get()
+		locked, _, _ := mirror.GetLock()
+
+		return !doExist && number == 0 && !locked && len(mirror.GetMilestoneIDsList()) == 0
+	}, time.Second, 10*time.Millisecond, "expected the mirror to follow the full purge")
+}
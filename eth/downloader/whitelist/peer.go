@@ -0,0 +1,77 @@
+package whitelist
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// PeerVerdictKind is the classification of a peer checked against the whitelisted block
+type PeerVerdictKind int
+
+const (
+	PeerUnchecked   PeerVerdictKind = iota // Nothing to check the peer against
+	PeerMatched                            // Peer has the whitelisted block
+	PeerDiverged                           // Peer has another block at the whitelisted number
+	PeerFetchFailed                        // Peer's header couldn't be fetched
+)
+
+func (k PeerVerdictKind) String() string {
+	switch k {
+	case PeerUnchecked:
+		return "unchecked"
+	case PeerMatched:
+		return "matched"
+	case PeerDiverged:
+		return "diverged"
+	case PeerFetchFailed:
+		return "fetch failed"
+	default:
+		return "unknown"
+	}
+}
+
+// PeerVerdict is the detailed result of the check of a peer by CheckPeer
+type PeerVerdict struct {
+	Kind     PeerVerdictKind
+	Number   uint64      // Number of the compared block
+	Expected common.Hash // Whitelisted hash at the number
+	Got      common.Hash // Peer's hash at the number, zero if it couldn't be fetched
+}
+
+// checkPeer fetches the peer's header at the whitelisted number and classifies the
+// peer by comparing it against the whitelisted hash
+func checkPeer(ctx context.Context, fetchHeadersByNumber func(ctx context.Context, number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error), retry fetchRetryPolicy, doExist bool, number uint64, hash common.Hash) (PeerVerdict, error) {
+	// Check for availaibility of the last whitelisted block. This can be also be
+	// empty if our heimdall is not responding or we're running without it.
+	if !doExist {
+		return PeerVerdict{Kind: PeerUnchecked}, nil
+	}
+
+	verdict := PeerVerdict{Number: number, Expected: hash}
+
+	headers, hashes, err := fetchHeadersWithRetry(ctx, fetchHeadersByNumber, retry, number)
+	if err != nil {
+		verdict.Kind = PeerFetchFailed
+		return verdict, err
+	}
+
+	if len(hashes) == 0 {
+		verdict.Kind = PeerFetchFailed
+		return verdict, fmt.Errorf("%w: no hash for the last whitelisted block number %d", ErrNoRemote, number)
+	}
+
+	verdict.Got = hashes[0]
+
+	// Check against the whitelisted blocks
+	if headers[0].Number.Uint64() == number && hashes[0] == hash {
+		verdict.Kind = PeerMatched
+		return verdict, nil
+	}
+
+	verdict.Kind = PeerDiverged
+
+	return verdict, ErrMismatch
+}
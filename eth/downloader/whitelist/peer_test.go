@@ -0,0 +1,262 @@
+package whitelist
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TestIsValidPeerFetchRetry checks that the failed fetches are retried while
+// the mismatches aren't
+func TestIsValidPeerFetchRetry(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	s.ProcessMilestone(10, common.Hash{1})
+
+	var calls int
+
+	// Fails once, then returns the valid header
+	flakyFetch := func(number uint64, _ int, _ int, _ bool) ([]*types.Header, []common.Hash, error) {
+		calls++
+		if calls == 1 {
+			return nil, nil, errors.New("timeout")
+		}
+
+		return []*types.Header{{Number: big.NewInt(int64(number))}}, []common.Hash{{1}}, nil
+	}
+
+	// No retry by default
+	res, err := milestone.IsValidPeer(flakyFetch)
+	require.ErrorIs(t, err, ErrNoRemote)
+	require.False(t, res)
+	require.Equal(t, 1, calls)
+
+	milestone.FetchRetries = 2
+	milestone.FetchBackoff = time.Millisecond
+
+	calls = 0
+
+	res, err = milestone.IsValidPeer(flakyFetch)
+	require.NoError(t, err)
+	require.True(t, res, "expected the peer to be valid after the retry")
+	require.Equal(t, 2, calls)
+
+	// The retries are bounded
+	calls = 0
+	failingFetch := func(number uint64, _ int, _ int, _ bool) ([]*types.Header, []common.Hash, error) {
+		calls++
+		return nil, nil, errors.New("timeout")
+	}
+
+	res, err = milestone.IsValidPeer(failingFetch)
+	require.ErrorIs(t, err, ErrNoRemote)
+	require.False(t, res)
+	require.Equal(t, 3, calls)
+
+	// A peer lacking the block is definitive
+	calls = 0
+	emptyFetch := func(number uint64, _ int, _ int, _ bool) ([]*types.Header, []common.Hash, error) {
+		calls++
+		return nil, nil, nil
+	}
+
+	res, err = milestone.IsValidPeer(emptyFetch)
+	require.ErrorIs(t, err, ErrNoRemote)
+	require.False(t, res)
+	require.Equal(t, 1, calls)
+
+	// A response without the hash of the header is rejected
+	noHashFetch := func(number uint64, _ int, _ int, _ bool) ([]*types.Header, []common.Hash, error) {
+		return []*types.Header{{Number: big.NewInt(int64(number))}}, nil, nil
+	}
+
+	verdict, err := milestone.CheckPeer(noHashFetch)
+	require.ErrorIs(t, err, ErrNoRemote)
+	require.Equal(t, PeerFetchFailed, verdict.Kind)
+
+	// A mismatch is definitive
+	calls = 0
+	mismatchFetch := func(number uint64, _ int, _ int, _ bool) ([]*types.Header, []common.Hash, error) {
+		calls++
+		return []*types.Header{{Number: big.NewInt(int64(number))}}, []common.Hash{{2}}, nil
+	}
+
+	res, err = milestone.IsValidPeer(mismatchFetch)
+	require.ErrorIs(t, err, ErrMismatch)
+	require.False(t, res)
+	require.Equal(t, 1, calls)
+
+	// The option applies to both the milestone and the checkpoint
+	s = NewService(rawdb.NewMemoryDatabase(), WithFetchRetries(3, time.Second))
+
+	// The checkpoint follows the retries set on the milestone
+	require.Equal(t, 3, s.checkpointService.(*checkpoint).FetchRetries)
+	require.Equal(t, time.Second, s.checkpointService.(*checkpoint).FetchBackoff)
+}
+
+// TestIsValidPeerWhitelistedHash checks the verification of the peer's hash at the whitelisted number
+func TestIsValidPeerWhitelistedHash(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 20)
+	chainB := createMockChain(1, 20)
+
+	s.ProcessMilestone(10, chainA[9].Hash())
+
+	var requested []uint64
+
+	fetchFrom := func(chain []*types.Header) func(number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error) {
+		return func(number uint64, _ int, _ int, _ bool) ([]*types.Header, []common.Hash, error) {
+			requested = append(requested, number)
+			header := chain[number-1]
+
+			return []*types.Header{header}, []common.Hash{header.Hash()}, nil
+		}
+	}
+
+	res, err := milestone.IsValidPeer(fetchFrom(chainA))
+	require.NoError(t, err)
+	require.True(t, res)
+	require.Equal(t, []uint64{10}, requested, "expected the header at the whitelisted number to be fetched")
+
+	res, err = milestone.IsValidPeer(fetchFrom(chainB))
+	require.ErrorIs(t, err, ErrMismatch)
+	require.False(t, res)
+
+	// A header of another number is rejected, even with the whitelisted hash
+	res, err = milestone.IsValidPeer(func(number uint64, _ int, _ int, _ bool) ([]*types.Header, []common.Hash, error) {
+		return []*types.Header{chainA[number]}, []common.Hash{chainA[number-1].Hash()}, nil
+	})
+	require.ErrorIs(t, err, ErrMismatch)
+	require.False(t, res)
+}
+
+// TestCheckPeer checks the classification of the peers by CheckPeer
+func TestCheckPeer(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	chainA := createMockChain(1, 20)
+	chainB := createMockChain(1, 20)
+
+	fetchFrom := func(chain []*types.Header) func(number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error) {
+		return func(number uint64, _ int, _ int, _ bool) ([]*types.Header, []common.Hash, error) {
+			header := chain[number-1]
+
+			return []*types.Header{header}, []common.Hash{header.Hash()}, nil
+		}
+	}
+
+	failingFetch := func(number uint64, _ int, _ int, _ bool) ([]*types.Header, []common.Hash, error) {
+		return nil, nil, errors.New("timeout")
+	}
+
+	// Nothing to check the peer against without a whitelisted milestone
+	verdict, err := s.CheckPeer(failingFetch)
+	require.NoError(t, err)
+	require.Equal(t, PeerVerdict{Kind: PeerUnchecked}, verdict)
+
+	s.ProcessMilestone(10, chainA[9].Hash())
+
+	verdict, err = s.CheckPeer(fetchFrom(chainA))
+	require.NoError(t, err)
+	require.Equal(t, PeerVerdict{Kind: PeerMatched, Number: 10, Expected: chainA[9].Hash(), Got: chainA[9].Hash()}, verdict)
+
+	verdict, err = s.CheckPeer(fetchFrom(chainB))
+	require.ErrorIs(t, err, ErrMismatch)
+	require.Equal(t, PeerVerdict{Kind: PeerDiverged, Number: 10, Expected: chainA[9].Hash(), Got: chainB[9].Hash()}, verdict)
+
+	verdict, err = s.CheckPeer(failingFetch)
+	require.ErrorIs(t, err, ErrNoRemote)
+	require.Equal(t, PeerVerdict{Kind: PeerFetchFailed, Number: 10, Expected: chainA[9].Hash()}, verdict)
+
+	// A peer without the header at the whitelisted number
+	verdict, err = s.CheckPeer(func(number uint64, _ int, _ int, _ bool) ([]*types.Header, []common.Hash, error) {
+		return nil, nil, nil
+	})
+	require.ErrorIs(t, err, ErrNoRemote)
+	require.Equal(t, PeerFetchFailed, verdict.Kind)
+
+	// IsValidPeer agrees with the verdicts
+	res, err := s.IsValidPeer(fetchFrom(chainA))
+	require.NoError(t, err)
+	require.True(t, res)
+
+	res, err = s.IsValidPeer(fetchFrom(chainB))
+	require.ErrorIs(t, err, ErrMismatch)
+	require.False(t, res)
+
+	require.Equal(t, "diverged", PeerDiverged.String())
+	require.Equal(t, "fetch failed", PeerFetchFailed.String())
+}
+
+// TestIsValidPeerCtx checks the abort of the peer's header fetch on the cancellation of the context
+func TestIsValidPeerCtx(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	s.ProcessMilestone(10, common.Hash{0x1})
+
+	started := make(chan struct{})
+	returned := make(chan struct{})
+
+	// Blocks until the context is cancelled, like a fetch from an unresponsive peer
+	blockingFetch := func(ctx context.Context, number uint64, _ int, _ int, _ bool) ([]*types.Header, []common.Hash, error) {
+		defer close(returned)
+
+		close(started)
+		<-ctx.Done()
+
+		return nil, nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	begin := time.Now()
+
+	res, err := s.IsValidPeerCtx(ctx, blockingFetch)
+	require.ErrorIs(t, err, context.Canceled)
+	require.False(t, res)
+	require.Less(t, time.Since(begin), 5*time.Second, "expected the call to return promptly")
+
+	select {
+	case <-returned:
+	default:
+		t.Fatal("expected the fetch to be done, not left running in the background")
+	}
+
+	// The retries stop as well
+	milestone := s.milestoneService.(*milestone)
+	milestone.FetchRetries = 100
+	milestone.FetchBackoff = time.Hour
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	verdict, err := s.CheckPeerCtx(ctx, func(_ context.Context, number uint64, _ int, _ int, _ bool) ([]*types.Header, []common.Hash, error) {
+		return nil, nil, errors.New("timeout")
+	})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Equal(t, PeerFetchFailed, verdict.Kind)
+}
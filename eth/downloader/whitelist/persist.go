@@ -0,0 +1,116 @@
+package whitelist
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// stateRecordKind is the kind of milestone state a record is written to
+type stateRecordKind uint8
+
+const (
+	stateRecordFinality stateRecordKind = iota // Whitelisted milestone
+	stateRecordLock                            // Lock field
+	stateRecordFuture                          // Future milestone list
+)
+
+// stateRecord is a write of the milestone state to its db key, or its removal
+type stateRecord struct {
+	Kind   stateRecordKind
+	Delete bool `json:",omitempty"`
+
+	Number uint64      `json:",omitempty"`
+	Hash   common.Hash `json:",omitempty"`
+
+	Locked bool                         `json:",omitempty"`
+	IDs    map[string]rawdb.MilestoneID `json:",omitempty"`
+
+	Order []uint64               `json:",omitempty"`
+	List  map[uint64]common.Hash `json:",omitempty"`
+}
+
+// clone returns a copy of the record not sharing its maps and slices
+func (r stateRecord) clone() stateRecord {
+	if r.IDs != nil {
+		ids := make(map[string]rawdb.MilestoneID, len(r.IDs))
+		for id, info := range r.IDs {
+			ids[id] = info
+		}
+
+		r.IDs = ids
+	}
+
+	if r.Order != nil {
+		r.Order = append([]uint64{}, r.Order...)
+	}
+
+	if r.List != nil {
+		list := make(map[uint64]common.Hash, len(r.List))
+		for number, hash := range r.List {
+			list[number] = hash
+		}
+
+		r.List = list
+	}
+
+	return r
+}
+
+// apply writes the record to its key
+func (r *stateRecord) apply(db ethdb.KeyValueWriter) error {
+	if r.Delete {
+		return r.delete(db)
+	}
+
+	switch r.Kind {
+	case stateRecordFinality:
+		return rawdb.WriteLastFinality[*rawdb.Milestone](db, r.Number, r.Hash)
+	case stateRecordLock:
+		return rawdb.WriteLockField(db, r.Locked, r.Number, r.Hash, r.IDs)
+	case stateRecordFuture:
+		return rawdb.WriteFutureMilestoneList(db, r.Order, r.List)
+	default:
+		return fmt.Errorf("unknown milestone state record kind %d", r.Kind)
+	}
+}
+
+// delete removes the key of the record
+func (r *stateRecord) delete(db ethdb.KeyValueWriter) error {
+	switch r.Kind {
+	case stateRecordFinality:
+		return rawdb.DeleteLastFinality[*rawdb.Milestone](db)
+	case stateRecordLock:
+		return rawdb.DeleteLockField(db)
+	case stateRecordFuture:
+		return rawdb.DeleteFutureMilestoneList(db)
+	default:
+		return fmt.Errorf("unknown milestone state record kind %d", r.Kind)
+	}
+}
+
+// persist writes the record and records the outcome in the persistence breaker.
+// Through the write-ahead log, only the failure to store the intent record is
+// recorded here, the outcome of the write itself being reported by the background
+// writer. Every state change is persisted, hence the state version is bumped here.
+// It should be called with the finality lock held.
+func (m *milestone) persist(record stateRecord) error {
+	m.version++
+
+	if m.wal != nil {
+		err := m.wal.append(record.clone())
+		if err != nil {
+			m.recordPersistence(err)
+		}
+
+		return err
+	}
+
+	err := record.apply(m.db)
+
+	m.recordPersistence(err)
+
+	return err
+}
@@ -0,0 +1,208 @@
+package whitelist
+
+import (
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// TestPersistedMilestoneChecksum checks that a tampered lock field or future
+// milestone list is detected on load, the lock being kept and the future list dropped
+func TestPersistedMilestoneChecksum(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+
+	ids := map[string]rawdb.MilestoneID{"milestoneID1": {}}
+
+	require.NoError(t, rawdb.WriteLockField(db, true, 15, common.Hash{1}, ids))
+	require.NoError(t, rawdb.WriteFutureMilestoneList(db, []uint64{20}, map[uint64]common.Hash{20: {2}}))
+
+	// Untampered data is loaded as is
+	locked, number, hash, idList, err := rawdb.ReadLockField(db)
+	require.NoError(t, err)
+	require.True(t, locked)
+	require.Equal(t, uint64(15), number)
+	require.Equal(t, common.Hash{1}, hash)
+	require.Equal(t, ids, idList)
+
+	order, list, err := rawdb.ReadFutureMilestoneList(db)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{20}, order)
+	require.Equal(t, map[uint64]common.Hash{20: {2}}, list)
+
+	// Tamper the stored values without updating the checksums
+	tamper := func(key string, from, to string) {
+		data, err := db.Get([]byte(key))
+		require.NoError(t, err)
+
+		tampered := strings.Replace(string(data), from, to, 1)
+		require.NotEqual(t, string(data), tampered)
+		require.NoError(t, db.Put([]byte(key), []byte(tampered)))
+	}
+
+	tamper("LockField", `"Block":15`, `"Block":16`)
+	tamper("FutureMilestoneField", `[20]`, `[21]`)
+
+	locked, number, hash, idList, err = rawdb.ReadLockField(db)
+	require.ErrorIs(t, err, rawdb.ErrChecksumMismatch)
+	require.False(t, locked, "expected no decoded value along with the checksum mismatch")
+	require.Zero(t, number)
+	require.Equal(t, common.Hash{}, hash)
+	require.Nil(t, idList)
+
+	_, _, err = rawdb.ReadFutureMilestoneList(db)
+	require.ErrorIs(t, err, rawdb.ErrChecksumMismatch)
+
+	// Metrics are disabled in tests, hence swap in an enabled counter
+	defer func(counter metrics.Counter) { MilestoneCorruptedDataCounter = counter }(MilestoneCorruptedDataCounter)
+	MilestoneCorruptedDataCounter = metrics.NewCounterForced()
+
+	s := NewService(db)
+	milestone := s.milestoneService.(*milestone)
+
+	require.Equal(t, int64(2), MilestoneCorruptedDataCounter.Snapshot().Count(), "expected both corruptions to be counted")
+	require.False(t, milestone.Locked, "expected the corrupted lock to be discarded")
+	require.Zero(t, milestone.LockedMilestoneNumber)
+	require.Equal(t, common.Hash{}, milestone.LockedMilestoneHash)
+	require.Empty(t, milestone.LockedMilestoneIDs)
+
+	has, err := db.Has([]byte("LockField"))
+	require.NoError(t, err)
+	require.False(t, has, "expected the corrupted lock field to be deleted")
+	require.Empty(t, milestone.FutureMilestoneOrder, "expected the corrupted future list to be discarded")
+	require.Empty(t, milestone.FutureMilestoneList)
+
+	// Data written without a checksum is still accepted
+	require.NoError(t, db.Put([]byte("FutureMilestoneField"), []byte(`{"Order":[30],"List":{"30":"0x0300000000000000000000000000000000000000000000000000000000000000"}}`)))
+
+	order, _, err = rawdb.ReadFutureMilestoneList(db)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{30}, order)
+}
+
+// TestLockFieldRestoredOnStartup checks the restoration of the persisted lock by NewService
+func TestLockFieldRestoredOnStartup(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+
+	// Unlocked without a lock field
+	s := NewService(db)
+	m := s.milestoneService.(*milestone)
+
+	require.False(t, m.Locked)
+	require.NotNil(t, m.LockedMilestoneIDs)
+	require.Empty(t, m.LockedMilestoneIDs)
+
+	// Restored from a written lock field
+	ids := map[string]rawdb.MilestoneID{"milestoneID1": {}, "milestoneID2": {}}
+	require.NoError(t, rawdb.WriteLockField(db, true, 48, common.Hash{48}, ids))
+
+	s = NewService(db)
+	m = s.milestoneService.(*milestone)
+
+	require.True(t, m.Locked)
+	require.Equal(t, uint64(48), m.LockedMilestoneNumber)
+	require.Equal(t, common.Hash{48}, m.LockedMilestoneHash)
+	require.Equal(t, ids, m.LockedMilestoneIDs)
+
+	// The restored lock protects the locked sprint
+	chain := createMockChain(40, 48)
+
+	res, err := s.IsValidChain(chain[0], chain)
+	require.ErrorIs(t, err, ErrReorgNotAllowed)
+	require.False(t, res)
+
+	// Round trip of a lock through a restart
+	require.True(t, s.LockMilestone(64, common.Hash{64}, "milestoneID3"))
+
+	m = NewService(db).milestoneService.(*milestone)
+
+	require.True(t, m.Locked)
+	require.Equal(t, uint64(64), m.LockedMilestoneNumber)
+	require.Equal(t, common.Hash{64}, m.LockedMilestoneHash)
+	require.Equal(t, []string{"milestoneID3"}, sortedIDs(m.LockedMilestoneIDs))
+
+	// An unlocked lock field defaults to unlocked, without ids
+	require.NoError(t, rawdb.WriteLockField(db, false, 64, common.Hash{64}, map[string]rawdb.MilestoneID{"milestoneID3": {}}))
+
+	m = NewService(db).milestoneService.(*milestone)
+
+	require.False(t, m.Locked)
+	require.Empty(t, m.LockedMilestoneIDs)
+}
+
+// countingDB is a database counting the writes of the future milestone list
+type countingDB struct {
+	ethdb.Database
+	futureWrites atomic.Int32
+}
+
+func (db *countingDB) Put(key []byte, value []byte) error {
+	if string(key) == "FutureMilestoneField" {
+		db.futureWrites.Add(1)
+	}
+
+	return db.Database.Put(key, value)
+}
+
+// TestProcessFutureMilestones checks that a batch of future milestones is processed like
+// a loop of ProcessFutureMilestone, with a single write of the future milestone list
+func TestProcessFutureMilestones(t *testing.T) {
+	t.Parallel()
+
+	pins := []MilestonePin{
+		{Number: 48, Hash: common.Hash{0x3}},
+		{Number: 16, Hash: common.Hash{0x1}},
+		{Number: 80, Hash: common.Hash{0x5}},
+		{Number: 32, Hash: common.Hash{0x2}},
+		{Number: 16, Hash: common.Hash{0x1}},
+		{Number: 64, Hash: common.Hash{0x4}},
+	}
+
+	loopDB := &countingDB{Database: rawdb.NewMemoryDatabase()}
+	loop := NewMockService(loopDB)
+
+	for _, pin := range pins {
+		loop.ProcessFutureMilestone(pin.Number, pin.Hash)
+	}
+
+	batchDB := &countingDB{Database: rawdb.NewMemoryDatabase()}
+	batch := NewMockService(batchDB)
+
+	batch.ProcessFutureMilestones(pins)
+
+	require.Equal(t, int32(5), loopDB.futureWrites.Load())
+	require.Equal(t, int32(1), batchDB.futureWrites.Load())
+
+	require.Equal(t, loop.Snapshot(), batch.Snapshot())
+	require.Equal(t, []uint64{16, 32, 48, 64, 80}, batch.Snapshot().FutureMilestoneOrder)
+
+	// The persisted list matches the one in memory
+	order, list, err := rawdb.ReadFutureMilestoneList(batchDB)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{16, 32, 48, 64, 80}, order)
+	require.Equal(t, common.Hash{0x5}, list[80])
+
+	// Entries already queued don't cause a write
+	batch.ProcessFutureMilestones([]MilestonePin{{Number: 32, Hash: common.Hash{0x2}}})
+	require.Equal(t, int32(1), batchDB.futureWrites.Load())
+
+	// The capacity is respected
+	batch.ProcessFutureMilestones([]MilestonePin{
+		{Number: 96, Hash: common.Hash{0x6}},
+		{Number: 112, Hash: common.Hash{0x7}},
+		{Number: 128, Hash: common.Hash{0x8}},
+		{Number: 144, Hash: common.Hash{0x9}},
+		{Number: 160, Hash: common.Hash{0xa}},
+		{Number: 176, Hash: common.Hash{0xb}},
+	})
+	require.Equal(t, int32(2), batchDB.futureWrites.Load())
+	require.Equal(t, 10, batch.FutureMilestoneCount())
+}
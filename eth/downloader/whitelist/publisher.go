@@ -0,0 +1,169 @@
+package whitelist
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/log"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+const (
+	publishAttempts  = 3                      // Number of attempts to publish a milestone
+	publishBackoff   = 100 * time.Millisecond // Delay between the attempts, doubled after each failure
+	publishTimeout   = 5 * time.Second        // Deadline of each attempt
+	publishQueueSize = 64                     // Number of milestones waiting to be published, the newer ones being dropped beyond it
+)
+
+// Metrics for collecting the number of milestones which couldn't be published
+var MilestonePublishFailureCounter = metrics.NewRegisteredCounter("chain/milestone/publish/failures", nil)
+
+// EventPublisher publishes the whitelisted milestones to an external message
+// queue (e.g. Kafka or NATS) for the data pipelines.
+type EventPublisher interface {
+	PublishMilestone(ctx context.Context, num uint64, hash common.Hash) error
+}
+
+// SetEventPublisher sets the publisher invoked in the background after each processed
+// milestone. A nil publisher disables the publishing, the milestones waiting to be
+// published by the previous publisher being dropped.
+func (m *milestone) SetEventPublisher(publisher EventPublisher) {
+	var worker *milestonePublisher
+	if publisher != nil {
+		worker = newMilestonePublisher(publisher)
+	}
+
+	m.finality.Lock()
+	previous := m.publisher
+	m.publisher = worker
+	m.unlock()
+
+	if previous != nil {
+		previous.stop()
+	}
+}
+
+// MilestoneCallback is invoked with each processed milestone, see SubscribeMilestone
+type MilestoneCallback func(number uint64, hash common.Hash)
+
+// SubscribeMilestone registers a callback invoked with each processed milestone.
+// The callbacks are invoked in the order of their registration, after the finality
+// lock is released, so that they can call back into the service. They should be
+// quick, as they delay the return of the processing.
+func (m *milestone) SubscribeMilestone(callback MilestoneCallback) {
+	m.finality.Lock()
+	defer m.unlock()
+
+	// Copy on write, as the callbacks are invoked outside of the lock
+	callbacks := make([]MilestoneCallback, 0, len(m.milestoneCallbacks)+1)
+	callbacks = append(callbacks, m.milestoneCallbacks...)
+	m.milestoneCallbacks = append(callbacks, callback)
+}
+
+// processedNotification returns the notification of the processed milestone to the
+// callbacks and the publisher, which must be run once the finality lock is released.
+// It should be called with the finality lock held.
+func (m *milestone) processedNotification(num uint64, hash common.Hash) func() {
+	callbacks, publisher := m.milestoneCallbacks, m.publisher
+
+	return func() {
+		for _, callback := range callbacks {
+			callback(num, hash)
+		}
+
+		if publisher != nil {
+			publisher.enqueue(num, hash)
+		}
+	}
+}
+
+// milestonePublisher publishes the milestones in order from a single background
+// worker, so that a failing publisher doesn't delay the processing
+type milestonePublisher struct {
+	publisher EventPublisher
+	queue     chan MilestonePin
+
+	ctx    context.Context // Cancelled on stop, aborting the ongoing publishing
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newMilestonePublisher(publisher EventPublisher) *milestonePublisher {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	p := &milestonePublisher{
+		publisher: publisher,
+		queue:     make(chan MilestonePin, publishQueueSize),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	p.wg.Add(1)
+
+	go func() {
+		defer p.wg.Done()
+
+		for {
+			select {
+			case pin := <-p.queue:
+				// The queued milestones are dropped once stopped
+				if p.ctx.Err() != nil {
+					return
+				}
+
+				p.publish(pin.Number, pin.Hash)
+			case <-p.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return p
+}
+
+// enqueue queues the milestone for the publishing, dropping it if the queue is full
+func (p *milestonePublisher) enqueue(num uint64, hash common.Hash) {
+	select {
+	case p.queue <- MilestonePin{Number: num, Hash: hash}:
+	default:
+		log.Warn("Dropping the milestone to publish, the queue is full", "number", num, "hash", hash, "size", publishQueueSize)
+		MilestonePublishFailureCounter.Inc(1)
+	}
+}
+
+func (p *milestonePublisher) stop() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// publish publishes the milestone on a best-effort basis, retrying a bounded number
+// of times, each attempt being given publishTimeout.
+func (p *milestonePublisher) publish(num uint64, hash common.Hash) {
+	backoff := publishBackoff
+
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(p.ctx, publishTimeout)
+		err := p.publisher.PublishMilestone(ctx, num, hash)
+		cancel()
+
+		if err == nil {
+			return
+		}
+
+		if attempt == publishAttempts || p.ctx.Err() != nil {
+			log.Warn("Failed to publish the milestone", "number", num, "hash", hash, "attempts", attempt, "err", err)
+			MilestonePublishFailureCounter.Inc(1)
+
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-p.ctx.Done():
+		}
+
+		backoff *= 2
+	}
+}
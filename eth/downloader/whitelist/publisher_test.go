@@ -0,0 +1,241 @@
+package whitelist
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// capturingPublisher records the published milestones, failing the first
+// `failures` attempts
+type capturingPublisher struct {
+	mu         sync.Mutex
+	failures   int
+	attempts   int
+	published  []MilestonePin
+	noDeadline bool // Whether an attempt was made without a deadline
+}
+
+func (p *capturingPublisher) PublishMilestone(ctx context.Context, num uint64, hash common.Hash) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.attempts++
+
+	if _, ok := ctx.Deadline(); !ok {
+		p.noDeadline = true
+	}
+
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("queue unavailable")
+	}
+
+	p.published = append(p.published, MilestonePin{Number: num, Hash: hash})
+
+	return nil
+}
+
+func (p *capturingPublisher) setFailures(failures int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.failures = failures
+}
+
+func (p *capturingPublisher) state() (attempts int, published []MilestonePin) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.attempts, append([]MilestonePin(nil), p.published...)
+}
+
+// TestEventPublisher checks that every processed milestone is published in order
+func TestEventPublisher(t *testing.T) {
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	defer func(counter metrics.Counter) { MilestonePublishFailureCounter = counter }(MilestonePublishFailureCounter)
+	MilestonePublishFailureCounter = metrics.NewCounterForced()
+
+	publisher := &capturingPublisher{}
+	s.SetEventPublisher(publisher)
+
+	published := func(expected ...MilestonePin) func() bool {
+		return func() bool {
+			_, pins := publisher.state()
+			return reflect.DeepEqual(expected, pins)
+		}
+	}
+
+	s.ProcessMilestone(10, common.Hash{1})
+	s.ProcessMilestone(20, common.Hash{2})
+
+	require.Eventually(t, published(MilestonePin{10, common.Hash{1}}, MilestonePin{20, common.Hash{2}}), time.Second, 10*time.Millisecond)
+
+	// A transient failure is retried in the background, keeping the order
+	publisher.setFailures(1)
+
+	start := time.Now()
+	s.ProcessMilestone(30, common.Hash{3})
+	s.ProcessMilestone(35, common.Hash{4})
+
+	require.Less(t, time.Since(start), publishBackoff, "expected the processing not to wait for the retries")
+	require.Eventually(t, published(MilestonePin{10, common.Hash{1}}, MilestonePin{20, common.Hash{2}}, MilestonePin{30, common.Hash{3}}, MilestonePin{35, common.Hash{4}}), time.Second, 10*time.Millisecond)
+	require.Equal(t, int64(0), MilestonePublishFailureCounter.Snapshot().Count())
+
+	// A persistent failure is counted without affecting the finality
+	publisher.setFailures(publishAttempts)
+	s.ProcessMilestone(40, common.Hash{5})
+
+	require.Eventually(t, func() bool {
+		return MilestonePublishFailureCounter.Snapshot().Count() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	_, pins := publisher.state()
+	require.Len(t, pins, 4)
+
+	_, number, hash := s.GetWhitelistedMilestone()
+	require.Equal(t, uint64(40), number)
+	require.Equal(t, common.Hash{5}, hash)
+
+	// Publishing can be disabled
+	s.SetEventPublisher(nil)
+	s.ProcessMilestone(50, common.Hash{6})
+
+	attempts, _ := publisher.state()
+	time.Sleep(50 * time.Millisecond)
+
+	after, pins := publisher.state()
+	require.Equal(t, attempts, after, "expected no publishing once disabled")
+	require.Len(t, pins, 4)
+	require.False(t, publisher.noDeadline, "expected every attempt to be given a deadline")
+}
+
+// blockingPublisher blocks every publishing until its context is done
+type blockingPublisher struct {
+	started chan struct{}
+}
+
+func (p *blockingPublisher) PublishMilestone(ctx context.Context, num uint64, hash common.Hash) error {
+	select {
+	case p.started <- struct{}{}:
+	default:
+	}
+
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+// TestEventPublisherStuck checks that a stuck publisher neither delays the processing
+// nor queues the milestones beyond the bound, and that it's aborted once replaced
+func TestEventPublisherStuck(t *testing.T) {
+	s := NewMockService(rawdb.NewMemoryDatabase())
+
+	defer func(counter metrics.Counter) { MilestonePublishFailureCounter = counter }(MilestonePublishFailureCounter)
+	MilestonePublishFailureCounter = metrics.NewCounterForced()
+
+	publisher := &blockingPublisher{started: make(chan struct{})}
+	s.SetEventPublisher(publisher)
+
+	s.ProcessMilestone(16, common.Hash{1})
+	<-publisher.started
+
+	// One milestone being published, the queue fills up and the next one is dropped
+	start := time.Now()
+
+	for i := 0; i <= publishQueueSize; i++ {
+		s.ProcessMilestone(uint64(i+2)*16, common.Hash{byte(i)})
+	}
+
+	require.Less(t, time.Since(start), time.Second, "expected the processing not to wait for the publisher")
+	require.Equal(t, int64(1), MilestonePublishFailureCounter.Snapshot().Count(), "expected the milestone beyond the queue to be dropped")
+
+	// Disabling the publishing aborts the stuck attempt
+	s.SetEventPublisher(nil)
+	require.Equal(t, int64(2), MilestonePublishFailureCounter.Snapshot().Count(), "expected the aborted attempt to be counted")
+}
+
+// TestSubscribeMilestone checks the invocation of the milestone callbacks
+func TestSubscribeMilestone(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	var (
+		first, second []MilestonePin
+		order         []int
+	)
+
+	s.SubscribeMilestone(func(number uint64, hash common.Hash) {
+		first = append(first, MilestonePin{Number: number, Hash: hash})
+		order = append(order, 1)
+	})
+
+	s.SubscribeMilestone(func(number uint64, hash common.Hash) {
+		// The lock is released, so the callback can call back into the service
+		doExist, whitelisted, _ := s.GetWhitelistedMilestone()
+		require.True(t, doExist)
+		require.Equal(t, number, whitelisted)
+
+		second = append(second, MilestonePin{Number: number, Hash: hash})
+		order = append(order, 2)
+	})
+
+	s.ProcessMilestone(16, common.Hash{16})
+	require.True(t, s.TryProcess(32, common.Hash{32}))
+	require.True(t, s.CompareAndSetMilestone(32, 48, common.Hash{48}))
+
+	// Not invoked without processing
+	require.False(t, s.TryProcess(16, common.Hash{16}))
+
+	expected := []MilestonePin{{Number: 16, Hash: common.Hash{16}}, {Number: 32, Hash: common.Hash{32}}, {Number: 48, Hash: common.Hash{48}}}
+	require.Equal(t, expected, first)
+	require.Equal(t, expected, second)
+	require.Equal(t, []int{1, 2, 1, 2, 1, 2}, order)
+}
+
+// TestSubscribeMilestoneConcurrent checks the registration of the callbacks while processing milestones
+func TestSubscribeMilestoneConcurrent(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	var (
+		wg    sync.WaitGroup
+		calls atomic.Int64
+	)
+
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+			s.SubscribeMilestone(func(uint64, common.Hash) { calls.Add(1) })
+		}()
+
+		go func(i int) {
+			defer wg.Done()
+			s.ProcessMilestone(uint64(i+1)*16, common.Hash{byte(i)})
+		}(i)
+	}
+
+	wg.Wait()
+
+	calls.Store(0)
+	s.ProcessMilestone(1000, common.Hash{1})
+	require.Equal(t, int64(8), calls.Load())
+}
@@ -0,0 +1,184 @@
+package whitelist
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// milestoneSnapshotJSON is the JSON encoding of a MilestoneSnapshot. The numbers are
+// hex encoded and the future milestones are listed in ascending order. The times at
+// which the milestone ids were added are listed apart, omitting the unknown ones.
+type milestoneSnapshotJSON struct {
+	DoExist bool           `json:"doExist"`
+	Number  hexutil.Uint64 `json:"number"`
+	Hash    common.Hash    `json:"hash"`
+
+	Locked                bool           `json:"locked"`
+	LockedMilestoneNumber hexutil.Uint64 `json:"lockedMilestoneNumber"`
+	LockedMilestoneHash   common.Hash    `json:"lockedMilestoneHash"`
+	LockedMilestoneIDs    []string       `json:"lockedMilestoneIds"`
+
+	LockedMilestoneIDsAddedAt map[string]time.Time `json:"lockedMilestoneIdsAddedAt,omitempty"`
+
+	FutureMilestones []futureMilestoneJSON `json:"futureMilestones"`
+}
+
+type futureMilestoneJSON struct {
+	Number hexutil.Uint64 `json:"number"`
+	Hash   common.Hash    `json:"hash"`
+}
+
+// MarshalJSON encodes the snapshot, e.g. to replicate the milestone state to a
+// hot-standby node
+func (s MilestoneSnapshot) MarshalJSON() ([]byte, error) {
+	enc := milestoneSnapshotJSON{
+		DoExist:               s.DoExist,
+		Number:                hexutil.Uint64(s.Number),
+		Hash:                  s.Hash,
+		Locked:                s.Locked,
+		LockedMilestoneNumber: hexutil.Uint64(s.LockedMilestoneNumber),
+		LockedMilestoneHash:   s.LockedMilestoneHash,
+		LockedMilestoneIDs:    make([]string, 0, len(s.LockedMilestoneIDs)),
+		FutureMilestones:      make([]futureMilestoneJSON, 0, len(s.FutureMilestoneOrder)),
+	}
+
+	for id, entry := range s.LockedMilestoneIDs {
+		enc.LockedMilestoneIDs = append(enc.LockedMilestoneIDs, id)
+
+		if !entry.AddedAt.IsZero() {
+			if enc.LockedMilestoneIDsAddedAt == nil {
+				enc.LockedMilestoneIDsAddedAt = make(map[string]time.Time)
+			}
+
+			enc.LockedMilestoneIDsAddedAt[id] = entry.AddedAt
+		}
+	}
+
+	sort.Strings(enc.LockedMilestoneIDs)
+
+	for _, number := range s.FutureMilestoneOrder {
+		enc.FutureMilestones = append(enc.FutureMilestones, futureMilestoneJSON{Number: hexutil.Uint64(number), Hash: s.FutureMilestoneList[number]})
+	}
+
+	return json.Marshal(enc)
+}
+
+// UnmarshalJSON decodes a snapshot encoded by MarshalJSON
+func (s *MilestoneSnapshot) UnmarshalJSON(input []byte) error {
+	var dec milestoneSnapshotJSON
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+
+	*s = MilestoneSnapshot{
+		DoExist:               dec.DoExist,
+		Number:                uint64(dec.Number),
+		Hash:                  dec.Hash,
+		Locked:                dec.Locked,
+		LockedMilestoneNumber: uint64(dec.LockedMilestoneNumber),
+		LockedMilestoneHash:   dec.LockedMilestoneHash,
+		LockedMilestoneIDs:    make(map[string]rawdb.MilestoneID, len(dec.LockedMilestoneIDs)),
+		FutureMilestoneList:   make(map[uint64]common.Hash, len(dec.FutureMilestones)),
+		FutureMilestoneOrder:  make([]uint64, 0, len(dec.FutureMilestones)),
+	}
+
+	for _, id := range dec.LockedMilestoneIDs {
+		s.LockedMilestoneIDs[id] = rawdb.MilestoneID{AddedAt: dec.LockedMilestoneIDsAddedAt[id]}
+	}
+
+	for _, future := range dec.FutureMilestones {
+		s.FutureMilestoneList[uint64(future.Number)] = future.Hash
+		s.FutureMilestoneOrder = append(s.FutureMilestoneOrder, uint64(future.Number))
+	}
+
+	return nil
+}
+
+// validate checks the consistency of the future milestones of the snapshot
+func (s MilestoneSnapshot) validate() error {
+	if len(s.FutureMilestoneOrder) != len(s.FutureMilestoneList) {
+		return fmt.Errorf("%w: %d ordered future milestones for %d hashes", ErrInvalidMilestoneState, len(s.FutureMilestoneOrder), len(s.FutureMilestoneList))
+	}
+
+	for i, number := range s.FutureMilestoneOrder {
+		if _, ok := s.FutureMilestoneList[number]; !ok {
+			return fmt.Errorf("%w: no hash for the future milestone %d", ErrInvalidMilestoneState, number)
+		}
+
+		if i > 0 && number <= s.FutureMilestoneOrder[i-1] {
+			return fmt.Errorf("%w: future milestones not in strictly ascending order", ErrInvalidMilestoneState)
+		}
+	}
+
+	return nil
+}
+
+// ExportState captures the milestone state to replicate, i.e. the whitelisted
+// milestone, the lock and the future milestones. It's the same as Snapshot, the
+// snapshot being serializable to JSON.
+func (m *milestone) ExportState() MilestoneSnapshot {
+	return m.Snapshot()
+}
+
+// ImportState replaces the milestone state with the exported one and writes it
+// through to the db, unlike Restore which doesn't persist the whitelisted milestone.
+// The state is rejected if its future milestones are inconsistent. The write errors
+// are logged and counted by the persistence breaker, like for the other updates.
+func (m *milestone) ImportState(state MilestoneSnapshot) error {
+	if err := state.validate(); err != nil {
+		return err
+	}
+
+	m.finality.Lock()
+	defer m.unlock()
+
+	if err := m.checkPersistence(); err != nil {
+		return err
+	}
+
+	state = state.clone()
+
+	m.doExist = state.DoExist
+	m.Number = state.Number
+	m.Hash = state.Hash
+
+	m.Locked = state.Locked
+	m.LockedMilestoneNumber = state.LockedMilestoneNumber
+	m.LockedMilestoneHash = state.LockedMilestoneHash
+	m.LockedMilestoneIDs = state.LockedMilestoneIDs
+
+	m.FutureMilestoneList = state.FutureMilestoneList
+	m.FutureMilestoneOrder = state.FutureMilestoneOrder
+	m.lag.arrivals = nil
+
+	if !m.evictBeyondCapacity() {
+		m.checkFutureMilestoneInvariant()
+	}
+
+	if m.doExist {
+		if err := m.persist(stateRecord{Kind: stateRecordFinality, Number: m.Number, Hash: m.Hash}); err != nil {
+			m.logger().Error("Error in writing whitelist state to db", "err", err)
+		}
+	} else {
+		if err := m.persist(stateRecord{Kind: stateRecordFinality, Delete: true}); err != nil {
+			m.logger().Error("Error in deleting the whitelisted milestone from db", "err", err)
+		}
+	}
+
+	m.evictMilestoneIDs()
+
+	m.writeLockField()
+	m.writeFutureMilestoneList()
+	m.updateFutureOccupancy()
+
+	m.sendUpdateEvent()
+	m.sendLockEvent()
+
+	return nil
+}
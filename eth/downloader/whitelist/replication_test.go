@@ -0,0 +1,88 @@
+package whitelist
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// TestMilestoneStateJSON checks the JSON round trip of the exported milestone state
+func TestMilestoneStateJSON(t *testing.T) {
+	t.Parallel()
+
+	// Empty state
+	s := NewMockService(rawdb.NewMemoryDatabase())
+
+	enc, err := json.Marshal(s.ExportState())
+	require.NoError(t, err)
+
+	var dec MilestoneSnapshot
+	require.NoError(t, json.Unmarshal(enc, &dec))
+	require.Equal(t, s.ExportState(), dec)
+
+	// Populated state
+	s.ProcessMilestone(16, common.Hash{0x1})
+	require.True(t, s.LockMilestone(96, common.Hash{0x6}, "milestoneID1"))
+	s.ProcessFutureMilestone(48, common.Hash{0x3})
+	s.ProcessFutureMilestone(32, common.Hash{0x2})
+
+	enc, err = json.Marshal(s.ExportState())
+	require.NoError(t, err)
+
+	// The numbers are hex encoded
+	require.Contains(t, string(enc), `"number":"0x10"`)
+	require.Contains(t, string(enc), `"lockedMilestoneNumber":"0x60"`)
+	require.Contains(t, string(enc), `"futureMilestones":[{"number":"0x20"`)
+
+	dec = MilestoneSnapshot{}
+	require.NoError(t, json.Unmarshal(enc, &dec))
+	require.Equal(t, s.ExportState(), dec)
+}
+
+// TestImportState checks the import of an exported milestone state on another node
+func TestImportState(t *testing.T) {
+	t.Parallel()
+
+	primary := NewMockService(rawdb.NewMemoryDatabase())
+
+	primary.ProcessMilestone(16, common.Hash{0x1})
+	require.True(t, primary.LockMilestone(96, common.Hash{0x6}, "milestoneID1"))
+	primary.ProcessFutureMilestone(32, common.Hash{0x2})
+	primary.ProcessFutureMilestone(48, common.Hash{0x3})
+
+	enc, err := json.Marshal(primary.ExportState())
+	require.NoError(t, err)
+
+	var state MilestoneSnapshot
+	require.NoError(t, json.Unmarshal(enc, &state))
+
+	db := rawdb.NewMemoryDatabase()
+	standby := NewMockService(db)
+
+	require.NoError(t, standby.ImportState(state))
+	require.Equal(t, primary.ExportState(), standby.ExportState())
+
+	// Written through to the db
+	require.Equal(t, primary.ExportState(), NewService(db).ExportState())
+
+	// Importing an empty state wipes the persisted one
+	require.NoError(t, standby.ImportState(NewMockService(rawdb.NewMemoryDatabase()).ExportState()))
+
+	snapshot := NewService(db).ExportState()
+	require.False(t, snapshot.DoExist)
+	require.False(t, snapshot.Locked)
+	require.Empty(t, snapshot.FutureMilestoneOrder)
+
+	// Inconsistent future milestones are rejected
+	state.FutureMilestoneOrder = []uint64{48, 32}
+	require.ErrorIs(t, standby.ImportState(state), ErrInvalidMilestoneState)
+
+	state.FutureMilestoneOrder = []uint64{32}
+	require.ErrorIs(t, standby.ImportState(state), ErrInvalidMilestoneState)
+
+	require.False(t, standby.ExportState().DoExist)
+}
@@ -0,0 +1,86 @@
+package whitelist
+
+import (
+	"sync"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	peerScoreReward  = 1   // Score gained by a peer for a valid chain
+	peerScorePenalty = 10  // Score lost by a peer for a chain rejected by the finality rules
+	peerScoreLimit   = 100 // Bound of the absolute score of a peer
+)
+
+// peerScores tracks a finality-aware reputation score per peer, rewarding the peers
+// serving valid chains and penalizing the ones serving rejected ones
+type peerScores struct {
+	sync.Mutex
+	scores map[string]int
+}
+
+// adjust adds delta to the score of the peer, keeping it within peerScoreLimit
+func (p *peerScores) adjust(peerID string, delta int) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.scores == nil {
+		p.scores = make(map[string]int)
+	}
+
+	score := p.scores[peerID] + delta
+	score = min(max(score, -peerScoreLimit), peerScoreLimit)
+
+	p.scores[peerID] = score
+}
+
+// get returns the score of the peer, zero for an unknown peer
+func (p *peerScores) get(peerID string) int {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.scores[peerID]
+}
+
+// ValidateAndScore validates the chain received from the peer like IsValidChain and
+// updates the score of the peer accordingly. It returns whether the chain is valid
+// and whether the peer was penalized. An empty chain is invalid but carries nothing
+// to judge the peer on, so the score is left untouched. The scores are kept by the
+// milestone service of this package, other implementations leave them untouched.
+func (s *Service) ValidateAndScore(peerID string, currentHeader *types.Header, chain []*types.Header) (bool, bool, error) {
+	valid, err := s.IsValidChain(currentHeader, chain)
+
+	m, ok := s.milestoneService.(*milestone)
+	if !ok {
+		return valid, false, err
+	}
+
+	switch {
+	case valid:
+		m.adjustPeerScore(peerID, peerScoreReward)
+	case len(chain) > 0:
+		m.adjustPeerScore(peerID, -peerScorePenalty)
+
+		return false, true, err
+	}
+
+	return valid, false, err
+}
+
+// PeerScore returns the finality-aware score of the peer, zero for an unknown peer
+func (s *Service) PeerScore(peerID string) int {
+	m, ok := s.milestoneService.(*milestone)
+	if !ok {
+		return 0
+	}
+
+	return m.peerScore(peerID)
+}
+
+func (m *milestone) adjustPeerScore(peerID string, delta int) {
+	m.scores.adjust(peerID, delta)
+}
+
+func (m *milestone) peerScore(peerID string) int {
+	return m.scores.get(peerID)
+}
@@ -0,0 +1,57 @@
+package whitelist
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TestValidateAndScore checks the peer scores across valid and rejected chains
+func TestValidateAndScore(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	s.ProcessMilestone(10, common.Hash{0x1})
+
+	currentHeader := &types.Header{Number: big.NewInt(20)}
+
+	require.Equal(t, 0, s.PeerScore("peer1"))
+
+	// The whitelisted milestone hash doesn't match the chain
+	valid, penalized, err := s.ValidateAndScore("peer1", currentHeader, createMockChain(5, 25))
+	require.False(t, valid)
+	require.True(t, penalized)
+	require.Equal(t, -peerScorePenalty, s.PeerScore("peer1"))
+
+	// A chain beyond the whitelisted milestone is valid
+	for i := 0; i < 3; i++ {
+		valid, penalized, err = s.ValidateAndScore("peer1", currentHeader, createMockChain(11, 25))
+		require.True(t, valid)
+		require.False(t, penalized)
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, 3*peerScoreReward-peerScorePenalty, s.PeerScore("peer1"))
+	require.Equal(t, 0, s.PeerScore("peer2"))
+
+	// An empty chain leaves the score untouched
+	valid, penalized, err = s.ValidateAndScore("peer1", currentHeader, nil)
+	require.False(t, valid)
+	require.False(t, penalized)
+	require.NoError(t, err)
+	require.Equal(t, 3*peerScoreReward-peerScorePenalty, s.PeerScore("peer1"))
+
+	// The score is bounded
+	for i := 0; i < 2*peerScoreLimit; i++ {
+		s.ValidateAndScore("peer2", currentHeader, createMockChain(5, 25))
+	}
+
+	require.Equal(t, -peerScoreLimit, s.PeerScore("peer2"))
+}
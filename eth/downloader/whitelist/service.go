@@ -1,13 +1,19 @@
 package whitelist
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
+
+	"golang.org/x/exp/slices"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/flags"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
 )
 
 var (
@@ -17,6 +23,25 @@ var (
 	ErrCheckpointMismatch = errors.New("checkpoint mismatch")
 	ErrLongFutureChain    = errors.New("received future chain of unacceptable length")
 	ErrNoRemoteCheckpoint = errors.New("remote peer doesn't have a checkpoint")
+
+	ErrReadOnly            = errors.New("milestone mirror is read-only")
+	ErrBlockNumberTooLarge = errors.New("block number is too large")
+	ErrUnrelatedChain      = errors.New("chain is unrelated to the current header")
+	ErrMissingAncestors    = errors.New("chain is missing the ancestors connecting it to the current header")
+	ErrReorgTooDeep        = errors.New("reorg is deeper than allowed")
+	ErrLaggingHead         = errors.New("chain doesn't advance toward the whitelisted milestone")
+
+	ErrFinalityMismatch        = errors.New("chain conflicts with the whitelisted milestone")
+	ErrReorgNotAllowed         = errors.New("reorg conflicts with the locked sprint")
+	ErrFutureMilestoneMismatch = errors.New("chain conflicts with a future milestone")
+
+	ErrFutureCheckpointMismatch = errors.New("chain conflicts with a future checkpoint")
+
+	ErrFutureMilestoneNotQueued = errors.New("future milestone is not queued")
+	ErrPersistenceDegraded      = errors.New("milestone persistence is degraded")
+
+	ErrInvalidFutureMilestoneCapacity = errors.New("future milestone capacity must be at least 1")
+	ErrInvalidMilestoneState          = errors.New("invalid milestone state")
 )
 
 type Service struct {
@@ -24,7 +49,12 @@ type Service struct {
 	milestoneService
 }
 
-func NewService(db ethdb.Database) *Service {
+func NewService(db ethdb.Database, opts ...ServiceOption) *Service {
+	// Apply the milestone writes left in the write-ahead log by a crash
+	if err := replayMilestoneWAL(db); err != nil {
+		log.Error("Error in replaying the milestone WAL", "err", err)
+	}
+
 	var checkpointDoExist = true
 
 	checkpointNumber, checkpointHash, err := rawdb.ReadFinality[*rawdb.Checkpoint](db)
@@ -42,56 +72,120 @@ func NewService(db ethdb.Database) *Service {
 
 	locked, lockedMilestoneNumber, lockedMilestoneHash, lockedMilestoneIDs, err := rawdb.ReadLockField(db)
 	if err != nil || !locked {
+		if errors.Is(err, rawdb.ErrChecksumMismatch) {
+			log.Error("Discarding corrupted milestone lock field", "err", err)
+			MilestoneCorruptedDataCounter.Inc(1)
+
+			// Drop the record, so that the corruption isn't reported again on restart
+			if err := rawdb.DeleteLockField(db); err != nil {
+				log.Error("Failed to delete the corrupted milestone lock field", "err", err)
+			}
+		}
+
 		locked = false
-		lockedMilestoneIDs = make(map[string]struct{})
+	}
+
+	if !locked || lockedMilestoneIDs == nil {
+		lockedMilestoneIDs = make(map[string]rawdb.MilestoneID)
 	}
 
 	order, list, err := rawdb.ReadFutureMilestoneList(db)
 	if err != nil {
+		if errors.Is(err, rawdb.ErrChecksumMismatch) {
+			log.Error("Discarding corrupted future milestone list", "err", err)
+			MilestoneCorruptedDataCounter.Inc(1)
+		}
+
 		order = make([]uint64, 0)
 		list = make(map[uint64]common.Hash)
 	}
 
+	// The order persisted by the older versions follows the arrival of the milestones
+	slices.Sort(order)
+
+	// Drop the entries out of sync between the order and the list
+	order, list, dropped := repairFutureMilestones(order, list)
+	if len(dropped) > 0 {
+		log.Warn("Dropping the inconsistent future milestones", "dropped", dropped)
+	}
+
+	m := &milestone{
+		finality: finality[*rawdb.Milestone]{
+			doExist:  milestoneDoExist,
+			Number:   milestoneNumber,
+			Hash:     milestoneHash,
+			interval: 256,
+			db:       db,
+		},
+
+		Locked:                locked,
+		LockedMilestoneNumber: lockedMilestoneNumber,
+		LockedMilestoneHash:   lockedMilestoneHash,
+		LockedMilestoneIDs:    lockedMilestoneIDs,
+		FutureMilestoneList:   list,
+		FutureMilestoneOrder:  order,
+		MaxCapacity:           DefaultFutureMilestoneCapacity,
+		enabled:               flags.Milestone,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if m.wal != nil {
+		m.wal.start()
+	}
+
+	// The older versions didn't bound the milestone ids
+	if len(m.evictMilestoneIDs()) > 0 {
+		m.writeLockField()
+	}
+
+	if len(dropped) > 0 {
+		m.writeFutureMilestoneList()
+	}
+
+	if m.pruner != nil {
+		m.pruner.start(m)
+	}
+
 	return &Service{
 		&checkpoint{
-			finality[*rawdb.Checkpoint]{
+			finality: finality[*rawdb.Checkpoint]{
 				doExist:  checkpointDoExist,
 				Number:   checkpointNumber,
 				Hash:     checkpointHash,
 				interval: 256,
 				db:       db,
-			},
-		},
 
-		&milestone{
-			finality: finality[*rawdb.Milestone]{
-				doExist:  milestoneDoExist,
-				Number:   milestoneNumber,
-				Hash:     milestoneHash,
-				interval: 256,
-				db:       db,
+				FetchRetries: m.FetchRetries,
+				FetchBackoff: m.FetchBackoff,
 			},
-
-			Locked:                locked,
-			LockedMilestoneNumber: lockedMilestoneNumber,
-			LockedMilestoneHash:   lockedMilestoneHash,
-			LockedMilestoneIDs:    lockedMilestoneIDs,
-			FutureMilestoneList:   list,
-			FutureMilestoneOrder:  order,
-			MaxCapacity:           10,
+			FutureCheckpointList:  make(map[uint64]common.Hash),
+			FutureCheckpointOrder: make([]uint64, 0),
+			MaxCapacity:           DefaultFutureMilestoneCapacity,
+			EvictLowestOnFull:     m.EvictLowestOnFull,
 		},
+
+		m,
 	}
 }
 
 // IsValidPeer checks if the chain we're about to receive from a peer is valid or not
 // in terms of reorgs. We won't reorg beyond the last bor checkpoint submitted to mainchain and last milestone voted in the heimdall
 func (s *Service) IsValidPeer(fetchHeadersByNumber func(number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (bool, error) {
-	checkpointBool, err := s.checkpointService.IsValidPeer(fetchHeadersByNumber)
+	return s.IsValidPeerCtx(context.Background(), withoutContext(fetchHeadersByNumber))
+}
+
+// IsValidPeerCtx is IsValidPeer with a fetch of the peer's headers honouring the
+// context. Once it's cancelled, the check returns false along with the context error.
+func (s *Service) IsValidPeerCtx(ctx context.Context, fetchHeadersByNumber func(ctx context.Context, number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) (bool, error) {
+	checkpointBool, err := s.checkpointService.IsValidPeerCtx(ctx, fetchHeadersByNumber)
 	if !checkpointBool {
 		return checkpointBool, err
 	}
 
-	milestoneBool, err := s.milestoneService.IsValidPeer(fetchHeadersByNumber)
+	milestoneBool, err := s.milestoneService.IsValidPeerCtx(ctx, fetchHeadersByNumber)
 	if !milestoneBool {
 		return milestoneBool, err
 	}
@@ -115,8 +209,9 @@ func (s *Service) GetWhitelistedMilestone() (bool, uint64, common.Hash) {
 	return s.milestoneService.Get()
 }
 
+// ProcessMilestone whitelists the milestone fetched from heimdall
 func (s *Service) ProcessMilestone(endBlockNum uint64, endBlockHash common.Hash) {
-	s.milestoneService.Process(endBlockNum, endBlockHash)
+	s.milestoneService.ProcessFrom(endBlockNum, endBlockHash, MilestoneSourceHeimdall)
 }
 
 func (s *Service) ProcessCheckpoint(endBlockNum uint64, endBlockHash common.Hash) {
@@ -137,6 +232,28 @@ func (s *Service) IsValidChain(currentHeader *types.Header, chain []*types.Heade
 	return true, nil
 }
 
+// IsValidChainWithSkipTd validates the chain against both the checkpoint and the
+// milestone and reports whether the total difficulty comparison can be skipped,
+// see VerifyChain.
+func (s *Service) IsValidChainWithSkipTd(currentHeader *types.Header, chain []*types.Header) (bool, bool, error) {
+	verdict, err := s.VerifyChain(currentHeader, chain)
+
+	return verdict.Valid, verdict.SkipTd, err
+}
+
+// VerifyChain validates the chain against both the checkpoint and the milestone and
+// reports why the total difficulty comparison can be skipped, which only the milestone
+// side can permit. The two services are locked one after the other, never together,
+// so it can't deadlock. It doesn't run the validation hooks of the milestone.
+func (s *Service) VerifyChain(currentHeader *types.Header, chain []*types.Header) (ChainVerdict, error) {
+	checkpointBool, err := s.checkpointService.IsValidChain(currentHeader, chain)
+	if !checkpointBool {
+		return ChainVerdict{}, err
+	}
+
+	return s.milestoneService.VerifyChain(currentHeader, chain)
+}
+
 func (s *Service) GetMilestoneIDsList() []string {
 	return s.milestoneService.GetMilestoneIDsList()
 }
@@ -194,7 +311,7 @@ func isValidChain(currentHeader *types.Header, chain []*types.Header, doExist bo
 	// It will handle all cases when the incoming chain has atleast one milestone
 	for i := len(pastChain) - 1; i >= 0; i-- {
 		if pastChain[i].Number.Uint64() == number {
-			res := pastChain[i].Hash() == hash
+			res := headerHash(pastChain[i]) == hash
 
 			return res, nil
 		}
@@ -203,33 +320,72 @@ func isValidChain(currentHeader *types.Header, chain []*types.Header, doExist bo
 	return true, nil
 }
 
-// FIXME: remoteHeader is not used
-func isValidPeer(fetchHeadersByNumber func(number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error), doExist bool, number uint64, hash common.Hash) (bool, error) {
-	// Check for availaibility of the last milestone block.
-	// This can be also be empty if our heimdall is not responding
-	// or we're running without it.
-	if !doExist {
-		// worst case, we don't have the milestone in memory
-		return true, nil
-	}
+// DefaultFetchRetries and DefaultFetchBackoff are the retries of the peer's header
+// fetch used by the node, see WithFetchRetries
+const (
+	DefaultFetchRetries = 2
+	DefaultFetchBackoff = 250 * time.Millisecond
+)
 
-	// todo: we can extract this as an interface and mock as well or just test IsValidChain in isolation from downloader passing fake fetchHeadersByNumber functions
-	headers, hashes, err := fetchHeadersByNumber(number, 1, 0, false)
-	if err != nil {
-		return false, fmt.Errorf("%w: last whitelisted block number %d, err %v", ErrNoRemote, number, err)
+// WithFetchRetries sets the FetchRetries and FetchBackoff of the peer's header fetch,
+// for both the milestone and the checkpoint
+func WithFetchRetries(retries int, backoff time.Duration) ServiceOption {
+	return func(m *milestone) {
+		m.FetchRetries = retries
+		m.FetchBackoff = backoff
 	}
+}
 
-	if len(headers) == 0 {
-		return false, fmt.Errorf("%w: last whitlisted block number %d", ErrNoRemote, number)
-	}
+// fetchRetryPolicy is the bounded retry of the peer's header fetch in checkPeer
+type fetchRetryPolicy struct {
+	retries int
+	backoff time.Duration
+}
 
-	reqBlockNum := headers[0].Number.Uint64()
-	reqBlockHash := hashes[0]
+// fetchHeadersWithRetry fetches the peer's header at the given number, retrying
+// the failed fetches (which are likely transient network errors) according to the
+// retry policy. An empty response means the peer lacks the block, which isn't
+// retried. The retries stop as soon as the context is cancelled.
+func fetchHeadersWithRetry(ctx context.Context, fetchHeadersByNumber func(ctx context.Context, number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error), retry fetchRetryPolicy, number uint64) ([]*types.Header, []common.Hash, error) {
+	backoff := retry.backoff
 
-	// Check against the whitelisted blocks
-	if reqBlockNum == number && reqBlockHash == hash {
-		return true, nil
+	for attempt := 0; ; attempt++ {
+		headers, hashes, err := fetchHeadersByNumber(ctx, number, 1, 0, false)
+
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+
+		if err == nil {
+			if len(headers) == 0 {
+				return nil, nil, fmt.Errorf("%w: last whitlisted block number %d", ErrNoRemote, number)
+			}
+
+			return headers, hashes, nil
+		}
+
+		err = fmt.Errorf("%w: last whitelisted block number %d, err %v", ErrNoRemote, number, err)
+
+		if attempt >= retry.retries {
+			return nil, nil, err
+		}
+
+		log.Debug("Retrying the fetch of the whitelisted block from peer", "number", number, "attempt", attempt+1, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
 	}
+}
 
-	return false, ErrMismatch
+// withoutContext adapts a fetch of the peer's headers which can't be cancelled to the
+// context aware fetch of IsValidPeerCtx, ignoring the context
+func withoutContext(fetchHeadersByNumber func(number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error)) func(ctx context.Context, number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error) {
+	return func(_ context.Context, number uint64, amount int, skip int, reverse bool) ([]*types.Header, []common.Hash, error) {
+		return fetchHeadersByNumber(number, amount, skip, reverse)
+	}
 }
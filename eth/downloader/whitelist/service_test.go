@@ -15,6 +15,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/flags"
 	"github.com/ethereum/go-ethereum/core/rawdb"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethdb"
@@ -25,11 +26,14 @@ func NewMockService(db ethdb.Database) *Service {
 	return &Service{
 
 		&checkpoint{
-			finality[*rawdb.Checkpoint]{
+			finality: finality[*rawdb.Checkpoint]{
 				doExist:  false,
 				interval: 256,
 				db:       db,
 			},
+			FutureCheckpointList:  make(map[uint64]common.Hash),
+			FutureCheckpointOrder: make([]uint64, 0),
+			MaxCapacity:           10,
 		},
 
 		&milestone{
@@ -38,14 +42,23 @@ func NewMockService(db ethdb.Database) *Service {
 				interval: 256,
 				db:       db,
 			},
-			LockedMilestoneIDs:   make(map[string]struct{}),
+			LockedMilestoneIDs:   make(map[string]rawdb.MilestoneID),
 			FutureMilestoneList:  make(map[uint64]common.Hash),
 			FutureMilestoneOrder: make([]uint64, 0),
 			MaxCapacity:          10,
+			enabled:              flags.Milestone,
 		},
 	}
 }
 
+// newMockMilestone creates a new mock whitelist service, returning it along with
+// its milestone service
+func newMockMilestone(db ethdb.Database) (*Service, *milestone) {
+	s := NewMockService(db)
+
+	return s, s.milestoneService.(*milestone)
+}
+
 // TestWhitelistCheckpoint checks the checkpoint whitelist setter and getter functions.
 func TestWhitelistedCheckpoint(t *testing.T) {
 	t.Parallel()
@@ -493,7 +506,7 @@ func TestIsValidChain(t *testing.T) {
 	//Case5: As the received chain is still invalid after removing the checkpoint as it is
 	//still behind the whitelisted milestone
 	res, err = s.IsValidChain(tempChain[1], chainA)
-	require.Nil(t, err)
+	require.ErrorIs(t, err, ErrFinalityMismatch)
 	require.Equal(t, res, false, "expected chain to be invalid")
 
 	//Remove the whitelisted milestone
@@ -520,7 +533,7 @@ func TestIsValidChain(t *testing.T) {
 	milestone.UnlockMutex(true, "MilestoneID2", chainA[len(chainA)-4].Number.Uint64(), hash3)
 
 	res, err = s.IsValidChain(chainA[len(chainA)-1], chainA)
-	require.Nil(t, err)
+	require.ErrorIs(t, err, ErrReorgNotAllowed)
 	require.Equal(t, res, false, "expected chain to be invalid as incoming chain does match with the locked value hash ")
 
 	//Locking for sprintNumber 19
@@ -529,7 +542,7 @@ func TestIsValidChain(t *testing.T) {
 
 	//Case7: As the received chain is valid as the locked sprintHash matches with the incoming chain.
 	res, err = s.IsValidChain(chainA[len(chainA)-1], chainA)
-	require.Nil(t, err)
+	require.ErrorIs(t, err, ErrReorgNotAllowed)
 	require.Equal(t, res, false, "expected chain to be invalid as incoming chain is less than the locked value ")
 
 	//Locking for sprintNumber 19
@@ -538,7 +551,7 @@ func TestIsValidChain(t *testing.T) {
 
 	//Case8: As the received chain is invalid as the locked sprintHash matches is ahead of incoming chain.
 	res, err = s.IsValidChain(chainA[len(chainA)-1], chainA)
-	require.Nil(t, err)
+	require.ErrorIs(t, err, ErrReorgNotAllowed)
 	require.Equal(t, res, false, "expected chain to be invalid as incoming chain is less than the locked value ")
 
 	//Unlocking the sprint
@@ -561,7 +574,7 @@ func TestIsValidChain(t *testing.T) {
 	// case10: Try importing a past chain having valid checkpoint, should
 	// consider the chain as invalid as still lastest milestone is ahead of the chain.
 	res, err = s.IsValidChain(tempChain[1], chainA)
-	require.Nil(t, err)
+	require.ErrorIs(t, err, ErrFinalityMismatch)
 	require.Equal(t, res, false, "expected chain to be invalid")
 
 	// add mock milestone entries
@@ -588,7 +601,7 @@ func TestIsValidChain(t *testing.T) {
 	// case14: Try importing a past chain having valid checkpoint and milestone with wrong hash, should
 	// consider the chain as invalid
 	res, err = s.IsValidChain(chainA[len(chainA)-1], chainA)
-	require.Nil(t, err)
+	require.ErrorIs(t, err, ErrFinalityMismatch)
 	require.Equal(t, res, false, "expected chain to be invalid as hash mismatches")
 
 	// Clear milestone and add blocks A15 in whitelist
@@ -653,7 +666,7 @@ func TestIsValidChain(t *testing.T) {
 
 	// case22: Try importing a future chain with mismatch future milestone
 	res, err = s.IsValidChain(tempChain[0], chainB)
-	require.Nil(t, err)
+	require.ErrorIs(t, err, ErrFutureMilestoneMismatch)
 	require.Equal(t, res, false, "expected chain to be invalid")
 
 	chainB = createMockChain(40, 49) // C40->C41...C48->C49
@@ -682,14 +695,15 @@ func TestPropertyBasedTestingMilestone(t *testing.T) {
 			Locked:                false,
 			LockedMilestoneNumber: 0,
 			LockedMilestoneHash:   common.Hash{},
-			LockedMilestoneIDs:    make(map[string]struct{}),
+			LockedMilestoneIDs:    make(map[string]rawdb.MilestoneID),
 			FutureMilestoneList:   make(map[uint64]common.Hash),
 			FutureMilestoneOrder:  make([]uint64, 0),
 			MaxCapacity:           10,
+			enabled:               flags.Milestone,
 		}
 
 		var (
-			milestoneEndNum = rapid.Uint64().Draw(t, "endBlock")
+			milestoneEndNum = rapid.Uint64Max(maxBlockNumber).Draw(t, "endBlock")
 			milestoneID     = rapid.String().Draw(t, "MilestoneID")
 			doLock          = rapid.Bool().Draw(t, "Voted")
 		)
@@ -767,7 +781,13 @@ func TestPropertyBasedTestingMilestone(t *testing.T) {
 		chainTemp := createMockChain(start.(uint64), end.(uint64))
 
 		val, err := milestone.IsValidChain(chainTemp[0], chainTemp)
-		if err != nil {
+
+		// The locked hash is never part of the mock chain
+		if doLock.(bool) && !errors.Is(err, ErrReorgNotAllowed) {
+			t.Error("Expected the locked sprint to reject the chain with", ErrReorgNotAllowed, "got", err)
+		}
+
+		if !doLock.(bool) && err != nil {
 			t.Error("Error", err)
 		}
 
@@ -780,7 +800,7 @@ func TestPropertyBasedTestingMilestone(t *testing.T) {
 		}
 
 		var (
-			milestoneEndNum2 = rapid.Uint64().Draw(t, "endBlockNum 2")
+			milestoneEndNum2 = rapid.Uint64Max(maxBlockNumber).Draw(t, "endBlockNum 2")
 			milestoneID2     = rapid.String().Draw(t, "MilestoneID 2")
 			doLock2          = rapid.Bool().Draw(t, "Voted 2")
 		)
@@ -1150,3 +1170,14 @@ func addTestCaseParams(mXNM map[int]map[int]map[int]struct{}, x, n, m int) {
 
 	mXNM[x][n][m] = struct{}{}
 }
+
+// createLinkedMockChain creates a mock chain whose headers are linked by their parent hashes
+func createLinkedMockChain(start, end uint64) []*types.Header {
+	chain := createMockChain(start, end)
+
+	for i := 1; i < len(chain); i++ {
+		chain[i].ParentHash = chain[i-1].Hash()
+	}
+
+	return chain
+}
@@ -0,0 +1,230 @@
+package whitelist
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Validator validates chains against a frozen copy of the milestone state, so that
+// a batch of chains is evaluated against the same finality state. It's obtained
+// from SnapshotValidator and never follows the live state.
+type Validator struct {
+	frozen  *milestone // Copy of the state, never mutated
+	version uint64
+}
+
+// SnapshotValidator returns a validator bound to the current milestone state along
+// with the version of that state. The live state moved on once StateVersion differs.
+func (m *milestone) SnapshotValidator() (*Validator, uint64) {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	return &Validator{frozen: m.freeze(), version: m.version}, m.version
+}
+
+// freeze returns a copy of the configuration and of the lock and future milestones
+// state, not aliasing the maps and slices of the live state. The feeds, the history,
+// the event log and the other runtime state aren't copied.
+// It should be called with the finality lock held.
+func (m *milestone) freeze() *milestone {
+	ids := make(map[string]rawdb.MilestoneID, len(m.LockedMilestoneIDs))
+	for id, entry := range m.LockedMilestoneIDs {
+		ids[id] = entry
+	}
+
+	list := make(map[uint64]common.Hash, len(m.FutureMilestoneList))
+	for number, hash := range m.FutureMilestoneList {
+		list[number] = hash
+	}
+
+	var lifecycle *LockLifecycle
+
+	if m.lockLifecycle != nil {
+		copied := *m.lockLifecycle
+		copied.IDs = append([]LockedMilestoneID{}, m.lockLifecycle.IDs...)
+		lifecycle = &copied
+	}
+
+	frozen := &milestone{
+		finality: finality[*rawdb.Milestone]{
+			doExist:      m.doExist,
+			Number:       m.Number,
+			Hash:         m.Hash,
+			interval:     m.interval,
+			FetchRetries: m.FetchRetries,
+			FetchBackoff: m.FetchBackoff,
+		},
+
+		Locked:                m.Locked,
+		LockedMilestoneNumber: m.LockedMilestoneNumber,
+		LockedMilestoneHash:   m.LockedMilestoneHash,
+		LockedMilestoneIDs:    ids,
+		FutureMilestoneList:   list,
+		FutureMilestoneOrder:  append([]uint64{}, m.FutureMilestoneOrder...),
+		MaxCapacity:           m.MaxCapacity,
+		EvictLowestOnFull:     m.EvictLowestOnFull,
+
+		enabled:                        m.enabled,
+		Defensive:                      m.Defensive,
+		RequirePresentFutureMilestones: m.RequirePresentFutureMilestones,
+		lockLifecycle:                  lifecycle,
+		latestSource:                   m.latestSource,
+		numberUnchangedSince:           m.numberUnchangedSince,
+		lastProcessedAt:                m.lastProcessedAt,
+		lockHeldSince:                  m.lockHeldSince,
+
+		UnrelatedChainPolicy:             m.UnrelatedChainPolicy,
+		LaggingHeadPolicy:                m.LaggingHeadPolicy,
+		SprintLength:                     m.SprintLength,
+		RejectMisalignedFutureMilestones: m.RejectMisalignedFutureMilestones,
+		MilestoneIDTTL:                   m.MilestoneIDTTL,
+		StaleLockAge:                     m.StaleLockAge,
+		LockTimeout:                      m.LockTimeout,
+		BlockTimeEstimator:               m.BlockTimeEstimator,
+		PreferMilestoneOverTd:            m.PreferMilestoneOverTd,
+		RequireTipBeyondMilestone:        m.RequireTipBeyondMilestone,
+		MinChainLenForFutureCheck:        m.MinChainLenForFutureCheck,
+		AllowLockedHashMismatch:          m.AllowLockedHashMismatch,
+		ReorgGuardDepth:                  m.ReorgGuardDepth,
+		MaxMilestoneIDs:                  m.MaxMilestoneIDs,
+		IDEvictionPolicy:                 m.IDEvictionPolicy,
+		ReorgPolicy:                      m.ReorgPolicy,
+		ConfidenceMaxDistance:            m.ConfidenceMaxDistance,
+		ConfidenceMaxAge:                 m.ConfidenceMaxAge,
+		MaxReorgDepth:                    m.MaxReorgDepth,
+		PersistenceFailureThreshold:      m.PersistenceFailureThreshold,
+		InstanceID:                       m.InstanceID,
+		Clock:                            m.Clock,
+	}
+
+	frozen.futurePaused.Store(m.futurePaused.Load())
+	frozen.suspendedUntil.Store(m.suspendedUntil.Load())
+
+	return frozen
+}
+
+// StateVersion returns the version of the milestone state, bumped on every change
+// of the whitelisted milestone, the lock or the future milestones
+func (m *milestone) StateVersion() uint64 {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	return m.version
+}
+
+// Version returns the version of the state the validator is bound to
+func (v *Validator) Version() uint64 {
+	return v.version
+}
+
+// IsValidChain validates the chain like the IsValidChain of the service, against the
+// frozen state. The validation hooks aren't run and the metrics aren't updated.
+func (v *Validator) IsValidChain(currentHeader *types.Header, chain []*types.Header) (bool, error) {
+	if !v.frozen.enabled {
+		return true, nil
+	}
+
+	v.frozen.finality.RLock()
+	defer v.frozen.finality.RUnlock()
+
+	currentHeader, chain, release := v.frozen.prepareChain(currentHeader, chain)
+	defer release()
+
+	valid, _, err := v.frozen.validateChain(currentHeader, chain, nil)
+
+	return valid, err
+}
+
+// MilestoneSnapshot is a copy of the in-memory milestone state, see Snapshot
+type MilestoneSnapshot struct {
+	DoExist bool
+	Number  uint64
+	Hash    common.Hash
+
+	Locked                bool
+	LockedMilestoneNumber uint64
+	LockedMilestoneHash   common.Hash
+	LockedMilestoneIDs    map[string]rawdb.MilestoneID
+
+	FutureMilestoneList  map[uint64]common.Hash
+	FutureMilestoneOrder []uint64
+}
+
+// clone returns a deep copy of the snapshot
+func (s MilestoneSnapshot) clone() MilestoneSnapshot {
+	ids := make(map[string]rawdb.MilestoneID, len(s.LockedMilestoneIDs))
+	for id, entry := range s.LockedMilestoneIDs {
+		ids[id] = entry
+	}
+
+	list := make(map[uint64]common.Hash, len(s.FutureMilestoneList))
+	for number, hash := range s.FutureMilestoneList {
+		list[number] = hash
+	}
+
+	s.LockedMilestoneIDs = ids
+	s.FutureMilestoneList = list
+	s.FutureMilestoneOrder = append([]uint64{}, s.FutureMilestoneOrder...)
+
+	return s
+}
+
+// Snapshot captures the in-memory milestone state, e.g. to restore it later in a
+// test harness. The snapshot doesn't alias the maps and slices of the live state.
+func (m *milestone) Snapshot() MilestoneSnapshot {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	return MilestoneSnapshot{
+		DoExist:               m.doExist,
+		Number:                m.Number,
+		Hash:                  m.Hash,
+		Locked:                m.Locked,
+		LockedMilestoneNumber: m.LockedMilestoneNumber,
+		LockedMilestoneHash:   m.LockedMilestoneHash,
+		LockedMilestoneIDs:    m.LockedMilestoneIDs,
+		FutureMilestoneList:   m.FutureMilestoneList,
+		FutureMilestoneOrder:  m.FutureMilestoneOrder,
+	}.clone()
+}
+
+// Restore replaces the in-memory milestone state with the snapshot and persists the
+// lock field and the future milestones. The whitelisted milestone isn't persisted.
+func (m *milestone) Restore(snapshot MilestoneSnapshot) {
+	m.finality.Lock()
+	defer m.unlock()
+
+	if err := m.checkPersistence(); err != nil {
+		m.logger().Warn("Refusing to restore the milestone state", "err", err)
+		return
+	}
+
+	snapshot = snapshot.clone()
+
+	m.doExist = snapshot.DoExist
+	m.Number = snapshot.Number
+	m.Hash = snapshot.Hash
+
+	m.Locked = snapshot.Locked
+	m.LockedMilestoneNumber = snapshot.LockedMilestoneNumber
+	m.LockedMilestoneHash = snapshot.LockedMilestoneHash
+	m.LockedMilestoneIDs = snapshot.LockedMilestoneIDs
+
+	m.FutureMilestoneList = snapshot.FutureMilestoneList
+	m.FutureMilestoneOrder = snapshot.FutureMilestoneOrder
+	m.lag.arrivals = nil
+
+	if !m.evictBeyondCapacity() {
+		m.checkFutureMilestoneInvariant()
+	}
+
+	m.evictMilestoneIDs()
+
+	m.writeLockField()
+	m.writeFutureMilestoneList()
+	m.updateFutureOccupancy()
+
+	m.sendUpdateEvent()
+	m.sendLockEvent()
+}
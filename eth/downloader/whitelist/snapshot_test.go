@@ -0,0 +1,395 @@
+package whitelist
+
+import (
+	"reflect"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TestSnapshotValidator checks the validation of chains against a frozen state while
+// the live one changes
+func TestSnapshotValidator(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 30)
+	chainB := createMockChain(1, 30)
+
+	s.ProcessMilestone(10, chainA[9].Hash())
+
+	validator, version := s.SnapshotValidator()
+	require.Equal(t, version, validator.Version())
+	require.Equal(t, version, s.StateVersion())
+
+	// The live state moves to a conflicting milestone and locks a sprint
+	s.ProcessMilestone(12, chainB[11].Hash())
+
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID1", 20, chainB[19].Hash())
+
+	s.ProcessFutureMilestone(16, chainB[15].Hash())
+
+	require.Greater(t, s.StateVersion(), version)
+
+	for i := 0; i < 3; i++ {
+		res, err := validator.IsValidChain(chainA[9], chainA)
+		require.NoError(t, err)
+		require.True(t, res, "expected the frozen state to accept the chain")
+
+		res, err = validator.IsValidChain(chainA[9], chainB)
+		require.ErrorIs(t, err, ErrFinalityMismatch)
+		require.False(t, res)
+	}
+
+	res, err := s.IsValidChain(chainA[9], chainA)
+	require.Error(t, err)
+	require.False(t, res, "expected the live state to reject the chain")
+
+	res, err = s.IsValidChain(chainA[9], chainB)
+	require.NoError(t, err)
+	require.True(t, res)
+
+	// A new snapshot follows the live state
+	validator, version = s.SnapshotValidator()
+	require.Equal(t, s.StateVersion(), version)
+
+	res, err = validator.IsValidChain(chainA[9], chainB)
+	require.NoError(t, err)
+	require.True(t, res)
+
+	s.PurgeWhitelistedMilestone()
+	require.Greater(t, s.StateVersion(), version)
+}
+
+// TestSnapshotValidatorConfig checks that the frozen state carries the configuration
+// and the lock state, the validator agreeing with the live state until it changes
+func TestSnapshotValidatorConfig(t *testing.T) {
+	t.Parallel()
+
+	s := NewService(rawdb.NewMemoryDatabase(), WithReorgGuardDepth(4))
+	milestone := s.milestoneService.(*milestone)
+
+	milestone.PreferMilestoneOverTd = true
+	milestone.RequireTipBeyondMilestone = true
+	milestone.MaxReorgDepth = 12
+
+	chainA := createMockChain(1, 30)
+
+	s.ProcessMilestone(10, chainA[9].Hash())
+	require.True(t, s.LockMilestone(20, chainA[19].Hash(), "milestoneID1"))
+
+	validator, _ := s.SnapshotValidator()
+
+	frozen := validator.frozen
+	require.Equal(t, milestone.ReorgGuardDepth, frozen.ReorgGuardDepth)
+	require.Equal(t, milestone.LockedMilestoneIDs, frozen.LockedMilestoneIDs)
+	require.True(t, frozen.PreferMilestoneOverTd)
+	require.True(t, frozen.RequireTipBeyondMilestone)
+
+	chains := [][]*types.Header{
+		chainA[10:],
+		chainA[14:],
+		chainA[15:],
+		chainA[19:],
+		chainA[20:],
+		createMockChain(15, 30),
+		createMockChain(11, 30),
+	}
+
+	for i, chain := range chains {
+		wantValid, wantErr := s.IsValidChain(chainA[28], chain)
+		valid, err := validator.IsValidChain(chainA[28], chain)
+
+		require.Equal(t, wantValid, valid, "chain %d", i)
+		require.Equal(t, wantErr, err, "chain %d", i)
+	}
+
+	// The ids of the frozen lock aren't aliased
+	require.True(t, s.LockMilestone(20, chainA[19].Hash(), "milestoneID2"))
+	require.Len(t, frozen.LockedMilestoneIDs, 1)
+}
+
+// TestSnapshotRestore checks the round trip of the milestone state through Snapshot and Restore
+func TestSnapshotRestore(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, milestone := newMockMilestone(db)
+
+	s.ProcessMilestone(10, common.Hash{10})
+
+	milestone.LockMutex(64)
+	milestone.UnlockMutex(true, "milestoneID1", 64, common.Hash{64})
+
+	s.ProcessFutureMilestone(32, common.Hash{32})
+	s.ProcessFutureMilestone(48, common.Hash{48})
+
+	snapshot := s.Snapshot()
+
+	require.True(t, snapshot.DoExist)
+	require.Equal(t, uint64(10), snapshot.Number)
+	require.Equal(t, common.Hash{10}, snapshot.Hash)
+	require.True(t, snapshot.Locked)
+	require.Equal(t, uint64(64), snapshot.LockedMilestoneNumber)
+	require.Equal(t, common.Hash{64}, snapshot.LockedMilestoneHash)
+	require.Equal(t, []string{"milestoneID1"}, sortedIDs(snapshot.LockedMilestoneIDs))
+	require.Equal(t, []uint64{32, 48}, snapshot.FutureMilestoneOrder)
+	require.Equal(t, map[uint64]common.Hash{32: {32}, 48: {48}}, snapshot.FutureMilestoneList)
+
+	// Mutating the live state doesn't alias the snapshot
+	milestone.LockMutex(64)
+	milestone.UnlockMutex(true, "milestoneID2", 64, common.Hash{64})
+
+	s.ProcessFutureMilestone(56, common.Hash{56})
+	s.ProcessMilestone(40, common.Hash{40})
+
+	require.Equal(t, []string{"milestoneID1"}, sortedIDs(snapshot.LockedMilestoneIDs))
+	require.Equal(t, []uint64{32, 48}, snapshot.FutureMilestoneOrder)
+	require.Equal(t, map[uint64]common.Hash{32: {32}, 48: {48}}, snapshot.FutureMilestoneList)
+
+	s.Restore(snapshot)
+
+	require.Equal(t, snapshot, s.Snapshot())
+
+	doExist, number, hash := s.GetWhitelistedMilestone()
+	require.True(t, doExist)
+	require.Equal(t, uint64(10), number)
+	require.Equal(t, common.Hash{10}, hash)
+
+	// Mutating the restored snapshot doesn't alias the live state
+	snapshot.LockedMilestoneIDs["milestoneID3"] = rawdb.MilestoneID{}
+	snapshot.FutureMilestoneList[80] = common.Hash{80}
+	snapshot.FutureMilestoneOrder[0] = 80
+
+	restored := s.Snapshot()
+	require.Equal(t, []string{"milestoneID1"}, sortedIDs(restored.LockedMilestoneIDs))
+	require.Equal(t, []uint64{32, 48}, restored.FutureMilestoneOrder)
+	require.Equal(t, map[uint64]common.Hash{32: {32}, 48: {48}}, restored.FutureMilestoneList)
+
+	// The lock field and the future milestones are persisted
+	locked, lockedNumber, lockedHash, lockedIDs, err := rawdb.ReadLockField(db)
+	require.NoError(t, err)
+	require.True(t, locked)
+	require.Equal(t, uint64(64), lockedNumber)
+	require.Equal(t, common.Hash{64}, lockedHash)
+	require.Equal(t, []string{"milestoneID1"}, sortedIDs(lockedIDs))
+
+	order, list, err := rawdb.ReadFutureMilestoneList(db)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{32, 48}, order)
+	require.Equal(t, map[uint64]common.Hash{32: {32}, 48: {48}}, list)
+
+	// A snapshot restores into a fresh service as well
+	other := NewMockService(rawdb.NewMemoryDatabase())
+	other.Restore(restored)
+	require.Equal(t, restored, other.Snapshot())
+}
+
+// frozenRuntimeFields are the fields of the milestone, and of its embedded finality,
+// which freeze doesn't copy on purpose
+var frozenRuntimeFields = map[string]bool{
+	"RWMutex":            true,
+	"db":                 true,
+	"publisher":          true,
+	"history":            true,
+	"events":             true,
+	"version":            true,
+	"feedGaps":           true,
+	"lag":                true,
+	"breaker":            true,
+	"pruner":             true,
+	"wal":                true,
+	"scores":             true,
+	"validationHooks":    true,
+	"milestoneCallbacks": true,
+	"chainCopyHook":      true,
+	"updateFeed":         true,
+	"lockFeed":           true,
+	"pendingEvents":      true,
+	"lastEvents":         true,
+}
+
+// fieldOf returns the field of the struct value, readable even if unexported
+func fieldOf(v reflect.Value, i int) reflect.Value {
+	f := v.Field(i)
+
+	return reflect.NewAt(f.Type(), unsafe.Pointer(f.UnsafeAddr())).Elem()
+}
+
+// forEachFrozenField calls fn with every field of the two milestones copied by
+// freeze, the fields of the embedded finality included
+func forEachFrozenField(a, b *milestone, fn func(name string, x, y reflect.Value)) {
+	var walk func(x, y reflect.Value)
+
+	walk = func(x, y reflect.Value) {
+		for i := 0; i < x.NumField(); i++ {
+			field := x.Type().Field(i)
+			if frozenRuntimeFields[field.Name] {
+				continue
+			}
+
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				walk(fieldOf(x, i), fieldOf(y, i))
+				continue
+			}
+
+			fn(field.Name, fieldOf(x, i), fieldOf(y, i))
+		}
+	}
+
+	walk(reflect.ValueOf(a).Elem(), reflect.ValueOf(b).Elem())
+}
+
+// newFrozenFixture returns a milestone with every field copied by freeze set
+func newFrozenFixture(t *testing.T) *milestone {
+	t.Helper()
+
+	_, m := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	now := time.Unix(1700000000, 0)
+
+	m.doExist = true
+	m.Number = 10
+	m.Hash = common.Hash{0x1}
+	m.interval = 256
+	m.FetchRetries = 3
+	m.FetchBackoff = time.Second
+
+	m.Locked = true
+	m.LockedMilestoneNumber = 20
+	m.LockedMilestoneHash = common.Hash{0x2}
+	m.LockedMilestoneIDs = map[string]rawdb.MilestoneID{"milestoneID1": {AddedAt: now}}
+	m.FutureMilestoneList = map[uint64]common.Hash{32: {0x3}}
+	m.FutureMilestoneOrder = []uint64{32}
+	m.MaxCapacity = 5
+	m.EvictLowestOnFull = true
+
+	m.enabled = true
+	m.Defensive = true
+	m.RequirePresentFutureMilestones = true
+	m.lockLifecycle = &LockLifecycle{Number: 20, Hash: common.Hash{0x2}, EngagedAt: now, IDs: []LockedMilestoneID{{ID: "milestoneID1", AddedAt: now}}}
+	m.latestSource = MilestoneSourceHeimdall
+	m.numberUnchangedSince = now
+	m.lastProcessedAt = now
+	m.lockHeldSince = now
+
+	m.UnrelatedChainPolicy = UnrelatedChainReject
+	m.LaggingHeadPolicy = LaggingHeadAdvanceOnly
+	m.SprintLength = 16
+	m.RejectMisalignedFutureMilestones = true
+	m.MilestoneIDTTL = time.Hour
+	m.StaleLockAge = time.Hour
+	m.LockTimeout = time.Minute
+	m.BlockTimeEstimator = func(number uint64) (time.Time, bool) { return now, true }
+	m.PreferMilestoneOverTd = true
+	m.RequireTipBeyondMilestone = true
+	m.MinChainLenForFutureCheck = 2
+	m.AllowLockedHashMismatch = true
+	m.ReorgGuardDepth = 4
+	m.MaxMilestoneIDs = 8
+	m.IDEvictionPolicy = IDRejectNew
+	m.ReorgPolicy = func([]*types.Header, LockState, MilestoneState) (bool, string) { return true, "" }
+	m.ConfidenceMaxDistance = 64
+	m.ConfidenceMaxAge = time.Minute
+	m.MaxReorgDepth = 12
+	m.PersistenceFailureThreshold = 3
+	m.InstanceID = "instance"
+	m.Clock = newFakeClock(now)
+
+	m.futurePaused.Store(true)
+	m.suspendedUntil.Store(40)
+
+	return m
+}
+
+// TestFreezeCopiesEveryField checks that freeze copies every field of the milestone
+// which isn't runtime state, without aliasing the maps, slices and pointers
+func TestFreezeCopiesEveryField(t *testing.T) {
+	t.Parallel()
+
+	m := newFrozenFixture(t)
+	frozen := m.freeze()
+
+	forEachFrozenField(m, frozen, func(name string, live, copied reflect.Value) {
+		// A new field must be set by the fixture, and copied by freeze unless it's
+		// listed in frozenRuntimeFields
+		require.False(t, live.IsZero(), "field %s isn't set by the fixture", name)
+
+		switch live.Kind() {
+		case reflect.Func:
+			require.Equal(t, live.Pointer(), copied.Pointer(), "field %s isn't copied", name)
+		case reflect.Map, reflect.Slice, reflect.Pointer:
+			require.True(t, reflect.DeepEqual(live.Interface(), copied.Interface()), "field %s isn't copied", name)
+
+			if name != "Clock" {
+				require.NotEqual(t, live.Pointer(), copied.Pointer(), "field %s is aliased", name)
+			}
+		default:
+			require.True(t, reflect.DeepEqual(live.Interface(), copied.Interface()), "field %s isn't copied", name)
+		}
+	})
+}
+
+// TestSnapshotRestoreEveryField checks that Restore brings back every field of the
+// snapshot, and only those, resetting the lag of the future milestones like ImportState
+func TestSnapshotRestoreEveryField(t *testing.T) {
+	t.Parallel()
+
+	m := newFrozenFixture(t)
+
+	snapshot := m.Snapshot()
+	before := m.freeze()
+
+	// Every field of the snapshot must be set by the fixture
+	sv := reflect.ValueOf(snapshot)
+	for i := 0; i < sv.NumField(); i++ {
+		require.False(t, sv.Field(i).IsZero(), "field %s isn't set by the fixture", sv.Type().Field(i).Name)
+	}
+
+	require.NoError(t, m.ImportState(MilestoneSnapshot{}))
+	m.recordFutureArrival(48)
+
+	m.Restore(snapshot)
+
+	require.Equal(t, snapshot, m.Snapshot())
+	require.Nil(t, m.lag.arrivals, "expected the arrivals to be reset like ImportState")
+
+	// The fields of the milestone behind the snapshot ones are restored
+	mv := reflect.ValueOf(m).Elem()
+
+	for i := 0; i < sv.NumField(); i++ {
+		name := sv.Type().Field(i).Name
+		if name == "DoExist" {
+			name = "doExist"
+		}
+
+		field := mv.FieldByName(name)
+		require.True(t, field.IsValid(), "no milestone field for the snapshot field %s", name)
+
+		restored := reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
+		require.True(t, reflect.DeepEqual(sv.Field(i).Interface(), restored.Interface()), "field %s isn't restored", name)
+	}
+
+	// The other fields aren't touched by the snapshot, only the lock lifecycle
+	// following the lock changes
+	forEachFrozenField(before, m.freeze(), func(name string, x, y reflect.Value) {
+		if name == "lockLifecycle" || name == "lockHeldSince" {
+			return
+		}
+
+		if x.Kind() == reflect.Func {
+			require.Equal(t, x.Pointer(), y.Pointer(), "field %s changed", name)
+			return
+		}
+
+		require.True(t, reflect.DeepEqual(x.Interface(), y.Interface()), "field %s changed", name)
+	})
+}
@@ -0,0 +1,74 @@
+package whitelist
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+)
+
+// FinalityStatus is the in-memory state of a whitelisted finality type. The block
+// numbers are hex encoded, like in the other bor RPC responses.
+type FinalityStatus struct {
+	DoExist bool           `json:"doExist"`
+	Number  hexutil.Uint64 `json:"number"`
+	Hash    common.Hash    `json:"hash"`
+}
+
+// MilestoneStatus is the in-memory state of the milestone service
+type MilestoneStatus struct {
+	FinalityStatus
+
+	Locked                bool                           `json:"locked"`
+	LockedMilestoneNumber hexutil.Uint64                 `json:"lockedMilestoneNumber"`
+	LockedMilestoneHash   common.Hash                    `json:"lockedMilestoneHash"`
+	FutureMilestoneOrder  []hexutil.Uint64               `json:"futureMilestoneOrder"`
+	FutureMilestoneList   map[hexutil.Uint64]common.Hash `json:"futureMilestoneList"`
+}
+
+// WhitelistStatus is the in-memory state of both the finality types, as returned
+// by the bor_getWhitelistStatus RPC
+type WhitelistStatus struct {
+	Milestone  MilestoneStatus `json:"milestone"`
+	Checkpoint FinalityStatus  `json:"checkpoint"`
+}
+
+// Status returns the in-memory whitelisted entry
+func (f *finality[T]) Status() FinalityStatus {
+	f.RLock()
+	defer f.RUnlock()
+
+	return FinalityStatus{DoExist: f.doExist, Number: hexutil.Uint64(f.Number), Hash: f.Hash}
+}
+
+// MilestoneStatus returns a copy of the in-memory milestone state, read under the
+// same lock as IsValidChain so that it's consistent with the validation
+func (m *milestone) MilestoneStatus() MilestoneStatus {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	order := make([]hexutil.Uint64, 0, len(m.FutureMilestoneOrder))
+	for _, number := range m.FutureMilestoneOrder {
+		order = append(order, hexutil.Uint64(number))
+	}
+
+	list := make(map[hexutil.Uint64]common.Hash, len(m.FutureMilestoneList))
+	for number, hash := range m.FutureMilestoneList {
+		list[hexutil.Uint64(number)] = hash
+	}
+
+	return MilestoneStatus{
+		FinalityStatus:        FinalityStatus{DoExist: m.doExist, Number: hexutil.Uint64(m.Number), Hash: m.Hash},
+		Locked:                m.Locked,
+		LockedMilestoneNumber: hexutil.Uint64(m.LockedMilestoneNumber),
+		LockedMilestoneHash:   m.LockedMilestoneHash,
+		FutureMilestoneOrder:  order,
+		FutureMilestoneList:   list,
+	}
+}
+
+// Status returns the in-memory state of the whitelisted milestone and checkpoint
+func (s *Service) Status() WhitelistStatus {
+	return WhitelistStatus{
+		Milestone:  s.milestoneService.MilestoneStatus(),
+		Checkpoint: s.checkpointService.Status(),
+	}
+}
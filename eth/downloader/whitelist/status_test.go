@@ -0,0 +1,64 @@
+package whitelist
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// TestWhitelistStatus checks the JSON shape of the whitelist status
+func TestWhitelistStatus(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	s.ProcessCheckpoint(8, common.Hash{0x8})
+	s.ProcessMilestone(10, common.Hash{0x1})
+
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID1", 20, common.Hash{0x2})
+
+	s.ProcessFutureMilestone(16, common.Hash{0x3})
+
+	enc, err := json.Marshal(s.Status())
+	require.NoError(t, err)
+
+	var decoded map[string]map[string]interface{}
+
+	require.NoError(t, json.Unmarshal(enc, &decoded))
+
+	require.Equal(t, map[string]interface{}{
+		"doExist":               true,
+		"number":                "0xa",
+		"hash":                  common.Hash{0x1}.Hex(),
+		"locked":                true,
+		"lockedMilestoneNumber": "0x14",
+		"lockedMilestoneHash":   common.Hash{0x2}.Hex(),
+		"futureMilestoneOrder":  []interface{}{"0x10"},
+		"futureMilestoneList":   map[string]interface{}{"0x10": common.Hash{0x3}.Hex()},
+	}, decoded["milestone"])
+
+	require.Equal(t, map[string]interface{}{
+		"doExist": true,
+		"number":  "0x8",
+		"hash":    common.Hash{0x8}.Hex(),
+	}, decoded["checkpoint"])
+
+	// The status decodes back, e.g. on the client side of the RPC
+	var roundTrip WhitelistStatus
+
+	require.NoError(t, json.Unmarshal(enc, &roundTrip))
+	require.Equal(t, s.Status(), roundTrip)
+
+	// The status is a copy of the state
+	status := s.Status()
+	status.Milestone.FutureMilestoneList[16] = common.Hash{0x4}
+	status.Milestone.FutureMilestoneOrder[0] = 17
+
+	require.Equal(t, common.Hash{0x3}, milestone.FutureMilestoneList[16])
+	require.Equal(t, []uint64{16}, milestone.FutureMilestoneOrder)
+}
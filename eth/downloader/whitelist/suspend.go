@@ -0,0 +1,45 @@
+package whitelist
+
+import (
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// SuspendReorgProtectionUntil lifts the whitelisted milestone and locked sprint checks
+// of the chain validation for the chains whose tip is at or below the given block,
+// e.g. during a coordinated network upgrade. The suspension expires on its own once
+// the head passes the block. A zero block re-engages the protection right away.
+func (m *milestone) SuspendReorgProtectionUntil(block uint64) {
+	if block == 0 {
+		m.logger().Warn("Re-engaging the reorg protection")
+	} else {
+		m.logger().Warn("Suspending the reorg protection", "until", block)
+	}
+
+	m.suspendedUntil.Store(block)
+}
+
+// reorgProtectionSuspended checks whether the reorg protection is suspended for the
+// chain, lifting the suspension once the head passed its target block. It's safe to
+// call with the finality read lock held.
+func (m *milestone) reorgProtectionSuspended(currentHeader *types.Header, chain []*types.Header) bool {
+	until := m.suspendedUntil.Load()
+	if until == 0 {
+		return false
+	}
+
+	if currentHeader != nil && currentHeader.Number.Uint64() > until {
+		if m.suspendedUntil.CompareAndSwap(until, 0) {
+			m.logger().Warn("Reorg protection suspension expired", "until", until, "head", currentHeader.Number.Uint64())
+		}
+
+		return false
+	}
+
+	if len(chain) == 0 || chain[len(chain)-1].Number.Uint64() > until {
+		return false
+	}
+
+	m.logger().Warn("Skipping the reorg protection while suspended", "until", until, "tip", chain[len(chain)-1].Number.Uint64())
+
+	return true
+}
@@ -0,0 +1,56 @@
+package whitelist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+)
+
+// TestSuspendReorgProtection checks the suspension of the reorg protection up to a block
+func TestSuspendReorgProtection(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 20)
+	chainB := createMockChain(1, 20)
+
+	s.ProcessMilestone(10, chainA[9].Hash())
+
+	milestone.LockMutex(12)
+	milestone.UnlockMutex(true, "milestoneID1", 12, chainA[11].Hash())
+
+	res, err := s.IsValidChain(chainA[11], chainB[:15])
+	require.ErrorIs(t, err, ErrFinalityMismatch)
+	require.False(t, res, "expected the reorg to be rejected before the suspension")
+
+	s.SuspendReorgProtectionUntil(15)
+
+	res, err = s.IsValidChain(chainA[11], chainB[:15])
+	require.NoError(t, err)
+	require.True(t, res, "expected the reorg to be allowed while suspended")
+
+	res, err = s.IsValidChain(chainA[11], chainB)
+	require.ErrorIs(t, err, ErrFinalityMismatch)
+	require.False(t, res, "expected the chain beyond the suspension target to be rejected")
+
+	// The suspension expires once the head passes the target
+	res, err = s.IsValidChain(chainA[15], chainB[:15])
+	require.ErrorIs(t, err, ErrFinalityMismatch)
+	require.False(t, res, "expected the protection to re-engage once the head passed the target")
+	require.Equal(t, uint64(0), milestone.suspendedUntil.Load())
+
+	res, err = s.IsValidChain(chainA[11], chainB[:15])
+	require.ErrorIs(t, err, ErrFinalityMismatch)
+	require.False(t, res, "expected the expired suspension to stay lifted")
+
+	// Re-engaging manually
+	s.SuspendReorgProtectionUntil(15)
+	s.SuspendReorgProtectionUntil(0)
+
+	res, err = s.IsValidChain(chainA[11], chainB[:15])
+	require.ErrorIs(t, err, ErrFinalityMismatch)
+	require.False(t, res)
+}
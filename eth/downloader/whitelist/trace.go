@@ -0,0 +1,106 @@
+package whitelist
+
+import (
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Names of the checks recorded in a DecisionTrace, in the order they're run
+const (
+	TraceCheckUnrelatedChain   = "unrelated chain"
+	TraceCheckReorgDepth       = "reorg depth"
+	TraceCheckLaggingHead      = "lagging head"
+	TraceCheckWhitelisted      = "whitelisted milestone"
+	TraceCheckLocked           = "locked sprint"
+	TraceCheckFutureMilestones = "future milestones"
+)
+
+// TraceStep is a single check run during a validation
+type TraceStep struct {
+	Check    string
+	Passed   bool
+	Err      error
+	Duration time.Duration
+}
+
+// DecisionTrace is the detailed record of a single chain validation, with its
+// inputs, the milestone state it ran against and every check it went through.
+// The checks after the first failing one aren't run, hence not recorded.
+type DecisionTrace struct {
+	// Inputs of the validation
+	CurrentNumber uint64
+	ChainFirst    uint64
+	ChainLast     uint64
+	ChainLength   int
+
+	// Milestone state at the time of the validation
+	Whitelisted      *MilestonePin
+	Locked           *MilestonePin
+	FutureMilestones []MilestonePin
+
+	Steps    []TraceStep
+	Pins     []CheckedPin
+	Reason   ReorgRejectReason
+	Duration time.Duration
+}
+
+// record appends a check to the trace, doing nothing on a nil trace
+func (t *DecisionTrace) record(check string, start time.Time, passed bool, err error) {
+	if t == nil {
+		return
+	}
+
+	t.Steps = append(t.Steps, TraceStep{Check: check, Passed: passed, Err: err, Duration: time.Since(start)})
+}
+
+// TraceValidation validates the chain like IsValidChain and returns the trace of the
+// validation along with its result and whether the total difficulty check could be
+// skipped (see ValidateChainDetailed). It doesn't update the validation metrics, which
+// makes it suited to reproduce a rejection offline from the exact inputs.
+func (m *milestone) TraceValidation(currentHeader *types.Header, chain []*types.Header) (DecisionTrace, bool, bool, error) {
+	var trace DecisionTrace
+
+	if !m.enabled {
+		return trace, true, false, nil
+	}
+
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	start := time.Now()
+
+	currentHeader, chain, release := m.prepareChain(currentHeader, chain)
+	defer release()
+
+	if currentHeader != nil {
+		trace.CurrentNumber = currentHeader.Number.Uint64()
+	}
+
+	if len(chain) > 0 {
+		trace.ChainFirst = chain[0].Number.Uint64()
+		trace.ChainLast = chain[len(chain)-1].Number.Uint64()
+	}
+
+	trace.ChainLength = len(chain)
+
+	if m.doExist {
+		trace.Whitelisted = &MilestonePin{Number: m.Number, Hash: m.Hash}
+	}
+
+	if m.Locked {
+		trace.Locked = &MilestonePin{Number: m.LockedMilestoneNumber, Hash: m.LockedMilestoneHash}
+	}
+
+	for _, number := range m.FutureMilestoneOrder {
+		trace.FutureMilestones = append(trace.FutureMilestones, MilestonePin{Number: number, Hash: m.FutureMilestoneList[number]})
+	}
+
+	valid, reason, err := m.validateChain(currentHeader, chain, &trace)
+
+	trace.Pins = m.checkedPins(chain)
+	trace.Reason = reason
+	trace.Duration = time.Since(start)
+
+	return trace, valid, valid && m.skipTdCheck(chain, trace.Pins), err
+}
@@ -0,0 +1,67 @@
+package whitelist
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TestTraceValidation checks the trace of a rejected validation
+func TestTraceValidation(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 30)
+	chainB := append(append([]*types.Header{}, chainA[:15]...), createMockChain(16, 30)...)
+
+	s.ProcessMilestone(10, chainA[9].Hash())
+
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID1", 20, chainA[19].Hash())
+
+	s.ProcessFutureMilestone(16, chainA[15].Hash())
+
+	// The future milestone at 16 released the lock, hence lock again above it
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID1", 20, chainA[19].Hash())
+
+	trace, valid, skipTd, err := s.TraceValidation(chainA[9], chainB)
+	require.ErrorIs(t, err, ErrReorgNotAllowed)
+	require.False(t, valid)
+	require.False(t, skipTd)
+
+	require.Equal(t, uint64(10), trace.CurrentNumber)
+	require.Equal(t, uint64(1), trace.ChainFirst)
+	require.Equal(t, uint64(30), trace.ChainLast)
+	require.Equal(t, 30, trace.ChainLength)
+	require.Equal(t, &MilestonePin{10, chainA[9].Hash()}, trace.Whitelisted)
+	require.Equal(t, &MilestonePin{20, chainA[19].Hash()}, trace.Locked)
+	require.Equal(t, []MilestonePin{{16, chainA[15].Hash()}}, trace.FutureMilestones)
+	require.Equal(t, ReorgRejectLocked, trace.Reason)
+
+	checks := make([]string, 0, len(trace.Steps))
+	for _, step := range trace.Steps {
+		checks = append(checks, step.Check)
+	}
+
+	require.Equal(t, []string{TraceCheckUnrelatedChain, TraceCheckReorgDepth, TraceCheckLaggingHead, TraceCheckWhitelisted, TraceCheckLocked}, checks,
+		"expected the checks to stop at the locked sprint")
+
+	for _, step := range trace.Steps[:4] {
+		require.True(t, step.Passed, "expected %s to pass", step.Check)
+		require.NoError(t, step.Err)
+	}
+
+	require.False(t, trace.Steps[4].Passed)
+	require.ErrorIs(t, trace.Steps[4].Err, ErrReorgNotAllowed)
+
+	require.Equal(t, []CheckedPin{
+		{MilestonePin{10, chainA[9].Hash()}, PinWhitelisted, true},
+		{MilestonePin{20, chainA[19].Hash()}, PinLocked, false},
+		{MilestonePin{16, chainA[15].Hash()}, PinFuture, false},
+	}, trace.Pins)
+}
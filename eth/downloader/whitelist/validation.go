@@ -0,0 +1,369 @@
+package whitelist
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ReorgRejectReason is the reason of the rejection of a chain by the milestone validation
+type ReorgRejectReason int
+
+const (
+	ReorgRejectNone            ReorgRejectReason = iota // Chain is valid
+	ReorgRejectUnrelatedChain                           // Chain isn't related to the current header
+	ReorgRejectTooDeep                                  // Chain reorgs deeper than allowed
+	ReorgRejectWhitelisted                              // Chain conflicts with the whitelisted milestone
+	ReorgRejectLocked                                   // Chain conflicts with the locked sprint
+	ReorgRejectFutureMilestone                          // Chain conflicts with a future milestone
+	ReorgRejectLaggingHead                              // Chain doesn't advance toward the whitelisted milestone
+)
+
+func (r ReorgRejectReason) String() string {
+	switch r {
+	case ReorgRejectNone:
+		return "none"
+	case ReorgRejectUnrelatedChain:
+		return "unrelated chain"
+	case ReorgRejectTooDeep:
+		return "reorg too deep"
+	case ReorgRejectWhitelisted:
+		return "whitelisted milestone mismatch"
+	case ReorgRejectLocked:
+		return "locked sprint mismatch"
+	case ReorgRejectFutureMilestone:
+		return "future milestone mismatch"
+	case ReorgRejectLaggingHead:
+		return "lagging head"
+	default:
+		return "unknown"
+	}
+}
+
+// SkipReason is the reason for which the total difficulty comparison of a valid chain
+// can be skipped
+type SkipReason int
+
+const (
+	SkipNone                   SkipReason = iota // Total difficulty must be compared
+	SkipFutureMilestoneMatch                     // Chain contains a matching future milestone
+	SkipFutureMilestoneSpanned                   // Chain spans a future milestone, with PreferMilestoneOverTd
+)
+
+func (r SkipReason) String() string {
+	switch r {
+	case SkipNone:
+		return "none"
+	case SkipFutureMilestoneMatch:
+		return "future milestone match"
+	case SkipFutureMilestoneSpanned:
+		return "future milestone spanned"
+	default:
+		return "unknown"
+	}
+}
+
+// ChainVerdict is the outcome of the validation of a chain by VerifyChain
+type ChainVerdict struct {
+	Valid  bool
+	SkipTd bool       // Whether the total difficulty comparison can be skipped
+	Reason SkipReason // Why the total difficulty comparison can be skipped, SkipNone if it can't
+}
+
+// PinKind is the kind of milestone a pinned block comes from
+type PinKind int
+
+const (
+	PinWhitelisted PinKind = iota
+	PinLocked
+	PinFuture
+)
+
+// CheckedPin is a milestone pin the chain was checked against
+type CheckedPin struct {
+	MilestonePin
+	Kind    PinKind
+	Matched bool // Whether the chain's block at the pinned number has the pinned hash
+}
+
+// ValidateChainDetailed validates the chain like IsValidChain and additionally reports
+// every milestone pin (whitelisted, locked and future) present in the chain along
+// with whether it matched, and the reason of the rejection. The skipTd result is true
+// when the valid chain is confirmed by a future milestone, in which case the total
+// difficulty comparison could be skipped. It doesn't update the validation metrics.
+func (m *milestone) ValidateChainDetailed(currentHeader *types.Header, chain []*types.Header) (valid bool, skipTd bool, matchedPins []CheckedPin, reason ReorgRejectReason, err error) {
+	if !m.enabled {
+		return true, false, nil, ReorgRejectNone, nil
+	}
+
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	currentHeader, chain, release := m.prepareChain(currentHeader, chain)
+	defer release()
+
+	valid, reason, err = m.validateChain(currentHeader, chain, nil)
+	matchedPins = m.checkedPins(chain)
+	skipTd = valid && m.skipTdCheck(chain, matchedPins)
+
+	return valid, skipTd, matchedPins, reason, err
+}
+
+// VerifyChain validates the chain like ValidateChainDetailed, reporting along with the
+// validity why the total difficulty comparison can be skipped, if it can. It doesn't
+// run the validation hooks nor update the validation metrics.
+func (m *milestone) VerifyChain(currentHeader *types.Header, chain []*types.Header) (ChainVerdict, error) {
+	if !m.enabled {
+		return ChainVerdict{Valid: true}, nil
+	}
+
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	currentHeader, chain, release := m.prepareChain(currentHeader, chain)
+	defer release()
+
+	valid, _, err := m.validateChain(currentHeader, chain, nil)
+	if !valid {
+		return ChainVerdict{}, err
+	}
+
+	reason := m.skipTdReason(chain, m.checkedPins(chain))
+
+	return ChainVerdict{Valid: true, SkipTd: reason != SkipNone, Reason: reason}, nil
+}
+
+// ValidateChainVerbose is a dry run of IsValidChain reporting every reason for which
+// the chain would be rejected, instead of stopping at the first failing check. The
+// returned error joins the errors of the failing checks, and is nil if the chain is
+// valid. It doesn't run the validation hooks nor update the validation metrics.
+func (m *milestone) ValidateChainVerbose(currentHeader *types.Header, chain []*types.Header) ([]string, error) {
+	if !m.enabled || len(chain) == 0 {
+		return nil, nil
+	}
+
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	currentHeader, chain, release := m.prepareChain(currentHeader, chain)
+	defer release()
+
+	var (
+		reasons []string
+		errs    []error
+	)
+
+	fail := func(reason ReorgRejectReason, err error) {
+		if err != nil {
+			reasons = append(reasons, fmt.Sprintf("%s: %v", reason, err))
+			errs = append(errs, err)
+		}
+	}
+
+	fail(ReorgRejectUnrelatedChain, m.checkUnrelatedChain(currentHeader, chain))
+	fail(ReorgRejectTooDeep, m.checkReorgDepth(currentHeader, chain))
+
+	if !m.reorgProtectionSuspended(currentHeader, chain) {
+		fail(ReorgRejectLaggingHead, m.checkLaggingHead(currentHeader, chain))
+
+		res, err := m.finality.IsValidChain(currentHeader, chain)
+		if !res && err == nil {
+			err = ErrFinalityMismatch
+		}
+
+		fail(ReorgRejectWhitelisted, err)
+		fail(ReorgRejectLocked, m.checkReorgAllowed(currentHeader, chain))
+	}
+
+	fail(ReorgRejectFutureMilestone, rejectionError(m.IsFutureMilestoneCompatible(chain), ErrFutureMilestoneMismatch))
+
+	return reasons, errors.Join(errs...)
+}
+
+// ProtectedBlocks returns, in ascending order, every block a candidate chain must
+// preserve to be valid, i.e. the whitelisted milestone, the locked sprint and the
+// future milestones. Pins shared by several of them are listed once.
+func (m *milestone) ProtectedBlocks() []MilestonePin {
+	m.finality.RLock()
+	defer m.finality.RUnlock()
+
+	pins := make([]MilestonePin, 0, len(m.FutureMilestoneOrder)+2)
+	seen := make(map[MilestonePin]struct{})
+
+	add := func(pin MilestonePin) {
+		if _, ok := seen[pin]; !ok {
+			seen[pin] = struct{}{}
+			pins = append(pins, pin)
+		}
+	}
+
+	if m.doExist {
+		add(MilestonePin{Number: m.Number, Hash: m.Hash})
+	}
+
+	if m.Locked {
+		add(MilestonePin{Number: m.LockedMilestoneNumber, Hash: m.LockedMilestoneHash})
+	}
+
+	for _, number := range m.FutureMilestoneOrder {
+		add(MilestonePin{Number: number, Hash: m.FutureMilestoneList[number]})
+	}
+
+	sort.Slice(pins, func(i, j int) bool { return pins[i].Number < pins[j].Number })
+
+	return pins
+}
+
+// checkedPins returns the milestone pins present in the chain, ordered by kind
+// and number. It should be called with the finality lock held.
+func (m *milestone) checkedPins(chain []*types.Header) []CheckedPin {
+	headers := make(map[uint64]*types.Header, len(chain))
+	for _, header := range chain {
+		headers[header.Number.Uint64()] = header
+	}
+
+	pins := make([]CheckedPin, 0)
+
+	check := func(kind PinKind, number uint64, hash common.Hash) {
+		if header, ok := headers[number]; ok {
+			pins = append(pins, CheckedPin{
+				MilestonePin: MilestonePin{Number: number, Hash: hash},
+				Kind:         kind,
+				Matched:      headerHash(header) == hash,
+			})
+		}
+	}
+
+	if m.doExist {
+		check(PinWhitelisted, m.Number, m.Hash)
+	}
+
+	if m.Locked {
+		check(PinLocked, m.LockedMilestoneNumber, m.LockedMilestoneHash)
+	}
+
+	for _, number := range m.FutureMilestoneOrder {
+		check(PinFuture, number, m.FutureMilestoneList[number])
+	}
+
+	return pins
+}
+
+// skipTdCheck checks whether the total difficulty comparison of the valid chain can be
+// skipped, see skipTdReason. It should be called with the finality lock held.
+func (m *milestone) skipTdCheck(chain []*types.Header, pins []CheckedPin) bool {
+	return m.skipTdReason(chain, pins) != SkipNone
+}
+
+// skipTdReason returns why the total difficulty comparison of the valid chain can be
+// skipped, which is when a future milestone in the chain matched or, with
+// PreferMilestoneOverTd, when the chain spans any future milestone. With
+// RequireTipBeyondMilestone, a milestone at the tip of the chain doesn't count.
+// It should be called with the finality lock held.
+func (m *milestone) skipTdReason(chain []*types.Header, pins []CheckedPin) SkipReason {
+	if len(chain) == 0 {
+		return SkipNone
+	}
+
+	first, last := chain[0].Number.Uint64(), chain[len(chain)-1].Number.Uint64()
+
+	confirms := func(number uint64) bool {
+		return number < last || (number == last && !m.RequireTipBeyondMilestone)
+	}
+
+	for _, pin := range pins {
+		if pin.Kind == PinFuture && pin.Matched && confirms(pin.Number) {
+			return SkipFutureMilestoneMatch
+		}
+	}
+
+	if !m.PreferMilestoneOverTd {
+		return SkipNone
+	}
+
+	for _, number := range m.FutureMilestoneOrder {
+		if number >= first && confirms(number) {
+			return SkipFutureMilestoneSpanned
+		}
+	}
+
+	return SkipNone
+}
+
+// VerifySegment checks every pin present in the chain segment against the hash of the
+// corresponding block, returning false along with the lowest mismatching number if any.
+// The pins outside of the segment are ignored, which allows verifying a long chain
+// segment by segment.
+func VerifySegment(chain []*types.Header, pins map[uint64]common.Hash) (bool, uint64) {
+	for _, header := range chain {
+		number := header.Number.Uint64()
+
+		if hash, ok := pins[number]; ok && header.Hash() != hash {
+			return false, number
+		}
+	}
+
+	return true, 0
+}
+
+// CheckFutureMilestoneChainConsistency checks the queued future milestones against the
+// locally known headers, returning in ascending order the numbers of the milestones
+// which are inconsistent with them. A milestone is inconsistent if the local header at
+// its number has another hash, or if the local chain between it and the previous known
+// milestone isn't linked by the parent hashes. The milestones without a local header
+// are skipped. It's meant for diagnostics.
+func (m *milestone) CheckFutureMilestoneChainConsistency(headerByNumber func(uint64) (*types.Header, bool)) []uint64 {
+	m.finality.RLock()
+
+	numbers := append([]uint64{}, m.FutureMilestoneOrder...)
+
+	hashes := make(map[uint64]common.Hash, len(numbers))
+	for _, number := range numbers {
+		hashes[number] = m.FutureMilestoneList[number]
+	}
+
+	m.finality.RUnlock()
+
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+
+	inconsistent := make([]uint64, 0)
+
+	var (
+		previous    uint64
+		hasPrevious bool
+	)
+
+	for _, number := range numbers {
+		header, ok := headerByNumber(number)
+		if !ok {
+			continue
+		}
+
+		if header.Hash() != hashes[number] || (hasPrevious && !isLinked(headerByNumber, header, previous)) {
+			inconsistent = append(inconsistent, number)
+			continue
+		}
+
+		previous, hasPrevious = number, true
+	}
+
+	return inconsistent
+}
+
+// isLinked checks whether the local headers link the header back to the given lower
+// number through their parent hashes
+func isLinked(headerByNumber func(uint64) (*types.Header, bool), header *types.Header, lower uint64) bool {
+	for header.Number.Uint64() > lower {
+		parent, ok := headerByNumber(header.Number.Uint64() - 1)
+		if !ok || parent.Hash() != header.ParentHash {
+			return false
+		}
+
+		header = parent
+	}
+
+	return true
+}
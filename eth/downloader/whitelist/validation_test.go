@@ -0,0 +1,1052 @@
+package whitelist
+
+import (
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/metrics"
+)
+
+// TestCheckHeadersAgainstFutureMilestones checks the sparse header check against
+// the queued future milestones
+func TestCheckHeadersAgainstFutureMilestones(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 30)
+
+	milestone.ProcessFutureMilestone(10, chainA[9].Hash())
+	milestone.ProcessFutureMilestone(20, chainA[19].Hash())
+	milestone.ProcessFutureMilestone(30, common.Hash{3})
+
+	// No headers, no conflicts
+	require.Empty(t, s.CheckHeadersAgainstFutureMilestones(nil))
+
+	// Matching headers only
+	conflicts := s.CheckHeadersAgainstFutureMilestones([]*types.Header{chainA[9], chainA[19]})
+	require.Empty(t, conflicts, "expected no conflicts for matching headers")
+
+	// Unrelated headers (no future milestone at their number)
+	conflicts = s.CheckHeadersAgainstFutureMilestones([]*types.Header{chainA[0], chainA[14], chainA[24]})
+	require.Empty(t, conflicts, "expected no conflicts for unrelated headers")
+
+	// Conflicting headers mixed with matching and unrelated ones
+	chainB := createMockChain(10, 20)
+	conflicts = s.CheckHeadersAgainstFutureMilestones([]*types.Header{chainA[5], chainB[0], chainA[19], chainA[29]})
+	require.Equal(t, []uint64{10, 30}, conflicts, "expected conflicts at future milestones 10 and 30")
+}
+
+// TestIsValidChainDefensive checks that the defensive mode makes the validation
+// immune to the caller mutating the chain during the call
+func TestIsValidChainDefensive(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 20)
+	chainA[9].Difficulty = big.NewInt(1)
+
+	s.ProcessMilestone(chainA[9].Number.Uint64(), chainA[9].Hash())
+
+	milestone.Defensive = true
+	milestone.chainCopyHook = func() {
+		// Mutate the header at the whitelisted milestone, changing its hash
+		chainA[9].Extra = []byte{1}
+		chainA[9].Number.SetUint64(0)
+	}
+
+	res, err := milestone.IsValidChain(chainA[len(chainA)-1], chainA)
+	require.Nil(t, err)
+	require.True(t, res, "expected chain to be valid as it was copied before the mutation")
+
+	// The mutation of the hash is visible in the subsequent calls
+	milestone.chainCopyHook = nil
+	chainA[9].Extra = nil
+	chainA[9].Number.SetUint64(10)
+
+	res, err = milestone.IsValidChain(chainA[len(chainA)-1], chainA)
+	require.Nil(t, err)
+	require.True(t, res, "expected chain to be valid once the mutation is reverted")
+
+	// A field shared with the copy mutated in place doesn't affect the pinned hash
+	milestone.chainCopyHook = func() {
+		chainA[9].Difficulty.SetUint64(2)
+	}
+
+	res, err = milestone.IsValidChain(chainA[len(chainA)-1], chainA)
+	require.Nil(t, err)
+	require.True(t, res, "expected chain to be valid as its hash was pinned before the mutation")
+
+	milestone.chainCopyHook = nil
+
+	res, err = milestone.IsValidChain(chainA[len(chainA)-1], chainA)
+	require.ErrorIs(t, err, ErrFinalityMismatch)
+	require.False(t, res, "expected chain to be invalid after the mutation")
+
+	// Every pinned hash is released after the validation
+	pinnedHashes.Range(func(key, _ any) bool {
+		t.Errorf("expected no pinned hash left, found one for the header %d", key.(*types.Header).Number)
+		return true
+	})
+}
+
+// TestIsValidChainLockOnly checks that the lock only validation agrees with the
+// full validation on chains unaffected by the future milestones
+func TestIsValidChainLockOnly(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 20)
+	chainB := createMockChain(1, 20)
+
+	compare := func(currentHeader *types.Header, chain []*types.Header, expected bool) {
+		t.Helper()
+
+		full, fullErr := milestone.IsValidChain(currentHeader, chain)
+		lockOnly, err := milestone.IsValidChainLockOnly(currentHeader, chain)
+
+		require.Equal(t, fullErr, err, "expected the lock only validation to agree with the full validation")
+		require.Equal(t, full, lockOnly, "expected the lock only validation to agree with the full validation")
+		require.Equal(t, expected, lockOnly)
+	}
+
+	// Nothing to validate against
+	compare(chainA[0], chainA, true)
+
+	// Whitelisted milestone
+	s.ProcessMilestone(5, chainA[4].Hash())
+	compare(chainA[4], chainA, true)
+	compare(chainB[4], chainB, false)
+
+	// Locked sprint
+	milestone.LockMutex(15)
+	milestone.UnlockMutex(true, "milestoneID1", 15, chainA[14].Hash())
+	compare(chainA[4], chainA, true)
+	compare(chainA[4], chainA[:15], false)
+
+	// Future milestone only affects the full validation
+	milestone.ProcessFutureMilestone(18, chainB[17].Hash())
+
+	res, err := milestone.IsValidChainLockOnly(chainA[4], chainA)
+	require.Nil(t, err)
+	require.True(t, res, "expected the future milestone to be skipped")
+
+	res, err = milestone.IsValidChain(chainA[4], chainA)
+	require.ErrorIs(t, err, ErrFutureMilestoneMismatch)
+	require.False(t, res, "expected the future milestone mismatch to be caught")
+}
+
+// TestUnrelatedChainPolicy checks the handling of chains starting beyond the
+// block right after the current header
+func TestUnrelatedChainPolicy(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 30)
+
+	contiguous := chainA[10:20] // starts at 11, right after the current header
+	reorg := chainA[5:20]       // starts below the current header
+	gapped := chainA[15:25]     // starts at 16, leaving a gap after the current header
+
+	current := chainA[9] // block 10
+
+	for _, policy := range []UnrelatedChainPolicy{UnrelatedChainAllow, UnrelatedChainReject, UnrelatedChainRequestMore} {
+		milestone.UnrelatedChainPolicy = policy
+
+		res, err := s.IsValidChain(current, contiguous)
+		require.Nil(t, err)
+		require.True(t, res, "expected contiguous chain to be valid")
+
+		res, err = s.IsValidChain(current, reorg)
+		require.Nil(t, err)
+		require.True(t, res, "expected reorg chain to be valid")
+	}
+
+	milestone.UnrelatedChainPolicy = UnrelatedChainAllow
+
+	res, err := s.IsValidChain(current, gapped)
+	require.Nil(t, err)
+	require.True(t, res, "expected gapped chain to be validated as usual")
+
+	milestone.UnrelatedChainPolicy = UnrelatedChainReject
+
+	res, err = s.IsValidChain(current, gapped)
+	require.ErrorIs(t, err, ErrUnrelatedChain)
+	require.False(t, res, "expected gapped chain to be rejected")
+
+	milestone.UnrelatedChainPolicy = UnrelatedChainRequestMore
+
+	res, err = s.IsValidChain(current, gapped)
+	require.ErrorIs(t, err, ErrMissingAncestors)
+	require.False(t, res, "expected gapped chain to be rejected until the ancestors are provided")
+}
+
+// TestMaxReorgDepth checks the cap on the reorg depth
+func TestMaxReorgDepth(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 200)
+	current := chainA[149] // block 150
+
+	// Fork point at block 49, reorging 101 blocks
+	deepReorg := createMockChain(50, 160)
+
+	res, err := s.IsValidChain(current, deepReorg)
+	require.NoError(t, err)
+	require.True(t, res, "expected no cap by default")
+
+	milestone.MaxReorgDepth = 100
+
+	res, err = s.IsValidChain(current, deepReorg)
+	require.ErrorIs(t, err, ErrReorgTooDeep)
+	require.False(t, res, "expected the reorg beyond the cap to be rejected")
+
+	// Fork point at block 50, reorging exactly 100 blocks
+	res, err = s.IsValidChain(current, createMockChain(51, 160))
+	require.NoError(t, err)
+	require.True(t, res, "expected the reorg just within the cap to be valid")
+
+	// Extension of the current chain
+	res, err = s.IsValidChain(current, chainA[150:])
+	require.NoError(t, err)
+	require.True(t, res, "expected the extension to be valid")
+}
+
+// TestValidateChainDetailed checks the pins and the reason reported by the detailed validation
+func TestValidateChainDetailed(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 40)
+
+	// Nothing to check against
+	valid, skipTd, pins, reason, err := s.ValidateChainDetailed(chainA[0], chainA)
+	require.NoError(t, err)
+	require.True(t, valid)
+	require.False(t, skipTd)
+	require.Empty(t, pins)
+	require.Equal(t, ReorgRejectNone, reason)
+
+	s.ProcessMilestone(10, chainA[9].Hash())
+
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID1", 20, chainA[19].Hash())
+
+	milestone.FutureMilestoneList[30] = chainA[29].Hash()
+	milestone.FutureMilestoneList[35] = chainA[34].Hash()
+	milestone.FutureMilestoneOrder = append(milestone.FutureMilestoneOrder, 30, 35)
+
+	// Chain spanning all the milestones
+	valid, skipTd, pins, reason, err = s.ValidateChainDetailed(chainA[9], chainA)
+	require.NoError(t, err)
+	require.True(t, valid)
+	require.True(t, skipTd, "expected the future milestone match to allow skipping the td check")
+	require.Equal(t, ReorgRejectNone, reason)
+	require.Equal(t, []CheckedPin{
+		{MilestonePin{10, chainA[9].Hash()}, PinWhitelisted, true},
+		{MilestonePin{20, chainA[19].Hash()}, PinLocked, true},
+		{MilestonePin{30, chainA[29].Hash()}, PinFuture, true},
+		{MilestonePin{35, chainA[34].Hash()}, PinFuture, true},
+	}, pins)
+
+	// Chain conflicting with the locked sprint and the future milestones
+	chainB := append(append([]*types.Header{}, chainA[:15]...), createMockChain(16, 40)...)
+
+	valid, skipTd, pins, reason, err = s.ValidateChainDetailed(chainA[9], chainB)
+	require.ErrorIs(t, err, ErrReorgNotAllowed)
+	require.False(t, valid)
+	require.False(t, skipTd)
+	require.Equal(t, ReorgRejectLocked, reason)
+	require.Equal(t, []CheckedPin{
+		{MilestonePin{10, chainA[9].Hash()}, PinWhitelisted, true},
+		{MilestonePin{20, chainA[19].Hash()}, PinLocked, false},
+		{MilestonePin{30, chainA[29].Hash()}, PinFuture, false},
+		{MilestonePin{35, chainA[34].Hash()}, PinFuture, false},
+	}, pins)
+
+	// Chain only spanning a part of the milestones
+	valid, _, pins, reason, err = s.ValidateChainDetailed(chainA[9], chainA[25:32])
+	require.NoError(t, err)
+	require.True(t, valid)
+	require.Equal(t, ReorgRejectNone, reason)
+	require.Equal(t, []CheckedPin{{MilestonePin{30, chainA[29].Hash()}, PinFuture, true}}, pins)
+}
+
+// TestExtendsFinalizedChain checks whether chains build on top of the whitelisted milestone
+func TestExtendsFinalizedChain(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	chainA := createMockChain(1, 20)
+	chainB := createMockChain(1, 20)
+
+	require.False(t, s.ExtendsFinalizedChain(chainA), "expected false without a whitelisted milestone")
+
+	s.ProcessMilestone(10, chainA[9].Hash())
+
+	require.True(t, s.ExtendsFinalizedChain(chainA))
+	require.True(t, s.ExtendsFinalizedChain(chainA[9:]), "expected true for a chain starting at the milestone")
+	require.False(t, s.ExtendsFinalizedChain(chainA[:10]), "expected false for a chain ending at the milestone")
+	require.False(t, s.ExtendsFinalizedChain(chainA[10:]), "expected false for a chain not containing the milestone")
+	require.False(t, s.ExtendsFinalizedChain(chainB), "expected false for a chain with a different milestone block")
+	require.False(t, s.ExtendsFinalizedChain(nil))
+}
+
+// TestMinChainLenForFutureCheck checks the skipping of the future milestone check for short chains
+func TestMinChainLenForFutureCheck(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+	milestone.MinChainLenForFutureCheck = 3
+
+	chainA := createMockChain(1, 20)
+	chainB := createMockChain(1, 20)
+
+	s.ProcessFutureMilestone(16, chainA[15].Hash())
+
+	// Just below the threshold, the conflicting chain isn't checked
+	require.True(t, milestone.IsFutureMilestoneCompatible(chainB[14:16]))
+
+	// At and above the threshold, it's rejected
+	require.False(t, milestone.IsFutureMilestoneCompatible(chainB[13:16]))
+	require.False(t, milestone.IsFutureMilestoneCompatible(chainB[12:16]))
+	require.True(t, milestone.IsFutureMilestoneCompatible(chainA[13:16]))
+
+	// Disabled by default
+	milestone.MinChainLenForFutureCheck = 0
+	require.False(t, milestone.IsFutureMilestoneCompatible(chainB[15:16]))
+}
+
+// TestVerifySegment checks the verification of chain segments against pins
+func TestVerifySegment(t *testing.T) {
+	t.Parallel()
+
+	chainA := createMockChain(1, 50)
+	chainB := createMockChain(1, 50)
+
+	pins := map[uint64]common.Hash{
+		10: chainA[9].Hash(),
+		20: chainA[19].Hash(),
+		40: chainA[39].Hash(),
+		60: common.Hash{0x1}, // Outside of the segment
+	}
+
+	ok, number := VerifySegment(chainA, pins)
+	require.True(t, ok)
+	require.Equal(t, uint64(0), number)
+
+	ok, _ = VerifySegment(chainB[20:30], pins)
+	require.True(t, ok, "expected a segment without pins to match")
+
+	// Mismatching from block 15 on
+	mixed := append(append([]*types.Header{}, chainA[:15]...), chainB[15:]...)
+
+	ok, number = VerifySegment(mixed, pins)
+	require.False(t, ok)
+	require.Equal(t, uint64(20), number, "expected the first mismatching pin")
+
+	ok, number = VerifySegment(mixed[30:], pins)
+	require.False(t, ok)
+	require.Equal(t, uint64(40), number)
+
+	ok, _ = VerifySegment(nil, pins)
+	require.True(t, ok)
+}
+
+// TestPreferMilestoneOverTd checks the skipping of the td check with and without PreferMilestoneOverTd
+func TestPreferMilestoneOverTd(t *testing.T) {
+	t.Parallel()
+
+	for _, prefer := range []bool{false, true} {
+		s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+		milestone.PreferMilestoneOverTd = prefer
+
+		chainA := createMockChain(1, 40)
+
+		milestone.FutureMilestoneList[30] = chainA[29].Hash()
+		milestone.FutureMilestoneOrder = append(milestone.FutureMilestoneOrder, 30)
+
+		// The milestone block is part of the chain
+		valid, skipTd, _, _, err := s.ValidateChainDetailed(chainA[0], chainA)
+		require.NoError(t, err)
+		require.True(t, valid)
+		require.True(t, skipTd, "prefer: %v", prefer)
+
+		// The chain spans the milestone without its block
+		sparse := append(append([]*types.Header{}, chainA[:29]...), chainA[30:]...)
+
+		valid, skipTd, _, _, err = s.ValidateChainDetailed(chainA[0], sparse)
+		require.NoError(t, err)
+		require.True(t, valid)
+		require.Equal(t, prefer, skipTd, "prefer: %v", prefer)
+
+		_, valid, skipTd, err = s.TraceValidation(chainA[0], sparse)
+		require.NoError(t, err)
+		require.True(t, valid)
+		require.Equal(t, prefer, skipTd, "prefer: %v", prefer)
+
+		// The chain ends before the milestone
+		valid, skipTd, _, _, err = s.ValidateChainDetailed(chainA[0], chainA[:20])
+		require.NoError(t, err)
+		require.True(t, valid)
+		require.False(t, skipTd, "prefer: %v", prefer)
+	}
+}
+
+// TestRequireTipBeyondMilestone checks the skipping of the td check for a chain ending at a future milestone
+func TestRequireTipBeyondMilestone(t *testing.T) {
+	t.Parallel()
+
+	for _, strict := range []bool{false, true} {
+		s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+		milestone.RequireTipBeyondMilestone = strict
+
+		chainA := createMockChain(1, 40)
+
+		milestone.FutureMilestoneList[30] = chainA[29].Hash()
+		milestone.FutureMilestoneOrder = append(milestone.FutureMilestoneOrder, 30)
+
+		// Tip equal to the milestone
+		valid, skipTd, _, _, err := s.ValidateChainDetailed(chainA[0], chainA[:30])
+		require.NoError(t, err)
+		require.True(t, valid)
+		require.Equal(t, !strict, skipTd, "strict: %v", strict)
+
+		// Tip beyond the milestone
+		valid, skipTd, _, _, err = s.ValidateChainDetailed(chainA[0], chainA[:31])
+		require.NoError(t, err)
+		require.True(t, valid)
+		require.True(t, skipTd, "strict: %v", strict)
+	}
+}
+
+// TestValidationHooks checks the rejection of chains by custom validation hooks
+func TestValidationHooks(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	chainA := createMockChain(1, 20)
+	chainB := createMockChain(1, 20)
+
+	errBanned := errors.New("banned chain")
+
+	var calls atomic.Int32
+
+	// Rejects the chains containing the banned block
+	banned := chainB[4].Hash()
+	s.RegisterValidationHook(func(currentHeader *types.Header, chain []*types.Header) (bool, error) {
+		calls.Add(1)
+
+		for _, header := range chain {
+			if header.Hash() == banned {
+				return false, errBanned
+			}
+		}
+
+		return true, nil
+	})
+
+	// Calls back into the service, which would deadlock while holding the lock
+	s.RegisterValidationHook(func(currentHeader *types.Header, chain []*types.Header) (bool, error) {
+		s.ProcessFutureMilestone(64, common.Hash{0x1})
+		return len(chain) > 1, nil
+	})
+
+	res, err := s.IsValidChain(chainA[0], chainA)
+	require.NoError(t, err)
+	require.True(t, res)
+
+	res, err = s.IsValidChain(chainA[0], chainB)
+	require.ErrorIs(t, err, errBanned)
+	require.False(t, res)
+
+	res, err = s.IsValidChain(chainA[0], chainA[:1])
+	require.NoError(t, err)
+	require.False(t, res, "expected the second hook to reject the chain")
+
+	// The hooks don't run for the chains rejected by the built-in checks
+	s.ProcessMilestone(10, chainA[9].Hash())
+	calls.Store(0)
+
+	res, err = s.IsValidChain(chainA[9], chainB)
+	require.ErrorIs(t, err, ErrFinalityMismatch)
+	require.False(t, res)
+	require.Equal(t, int32(0), calls.Load())
+}
+
+// TestCheckFutureMilestoneChainConsistency checks the future milestones against the local headers
+func TestCheckFutureMilestoneChainConsistency(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewMockService(db)
+
+	chain := createLinkedMockChain(1, 40)
+	other := createLinkedMockChain(1, 40)
+
+	local := make(map[uint64]*types.Header)
+	for _, header := range chain {
+		local[header.Number.Uint64()] = header
+	}
+
+	headerByNumber := func(number uint64) (*types.Header, bool) {
+		header, ok := local[number]
+		return header, ok
+	}
+
+	s.ProcessFutureMilestone(24, chain[23].Hash())
+	s.ProcessFutureMilestone(8, chain[7].Hash())
+	s.ProcessFutureMilestone(16, chain[15].Hash())
+
+	require.Empty(t, s.CheckFutureMilestoneChainConsistency(headerByNumber))
+
+	// Milestone on another chain
+	s.ProcessFutureMilestone(32, other[31].Hash())
+	require.Equal(t, []uint64{32}, s.CheckFutureMilestoneChainConsistency(headerByNumber))
+
+	// Local chain not linking the milestones
+	local[20] = other[19]
+	require.Equal(t, []uint64{24, 32}, s.CheckFutureMilestoneChainConsistency(headerByNumber))
+
+	// Milestones without a local header are skipped
+	delete(local, 24)
+	delete(local, 32)
+	require.Empty(t, s.CheckFutureMilestoneChainConsistency(headerByNumber))
+}
+
+// TestProtectedBlocks checks the protected blocks gathered from every pin
+func TestProtectedBlocks(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	require.Empty(t, s.ProtectedBlocks())
+
+	s.ProcessMilestone(10, common.Hash{0x1})
+
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID1", 20, common.Hash{0x2})
+
+	milestone.FutureMilestoneList[32] = common.Hash{0x4}
+	milestone.FutureMilestoneList[16] = common.Hash{0x3}
+	milestone.FutureMilestoneList[20] = common.Hash{0x2} // Same as the lock
+	milestone.FutureMilestoneOrder = append(milestone.FutureMilestoneOrder, 16, 20, 32)
+
+	require.Equal(t, []MilestonePin{
+		{10, common.Hash{0x1}},
+		{16, common.Hash{0x3}},
+		{20, common.Hash{0x2}},
+		{32, common.Hash{0x4}},
+	}, s.ProtectedBlocks())
+
+	// Releasing the lock leaves its pin to the future milestone
+	s.RemoveMilestoneID("milestoneID1")
+
+	require.Equal(t, []MilestonePin{
+		{10, common.Hash{0x1}},
+		{16, common.Hash{0x3}},
+		{20, common.Hash{0x2}},
+		{32, common.Hash{0x4}},
+	}, s.ProtectedBlocks())
+
+	s.ProcessMilestone(20, common.Hash{0x2})
+
+	require.Equal(t, []MilestonePin{
+		{20, common.Hash{0x2}},
+		{32, common.Hash{0x4}},
+	}, s.ProtectedBlocks())
+}
+
+// TestLaggingHead checks the validation of the chains while the current header is
+// behind the whitelisted milestone
+func TestLaggingHead(t *testing.T) {
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chain := createMockChain(1, 30)
+	s.ProcessMilestone(20, chain[19].Hash())
+
+	counter := LaggingHeadCounter
+	LaggingHeadCounter = metrics.NewCounterForced()
+
+	defer func() { LaggingHeadCounter = counter }()
+
+	currentHeader := &types.Header{Number: big.NewInt(10)}
+
+	// A chain diverging at the whitelisted milestone, not checked by default
+	diverging := createMockChain(11, 25)
+
+	res, err := s.IsValidChain(currentHeader, diverging)
+	require.True(t, res)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), LaggingHeadCounter.Snapshot().Count())
+
+	milestone.LaggingHeadPolicy = LaggingHeadAdvanceOnly
+
+	res, err = s.IsValidChain(currentHeader, diverging)
+	require.False(t, res)
+	require.ErrorIs(t, err, ErrLaggingHead)
+
+	// A chain moving toward the whitelisted milestone
+	res, err = s.IsValidChain(currentHeader, chain[10:15])
+	require.True(t, res)
+	require.NoError(t, err)
+
+	// A chain reaching the whitelisted milestone with its hash
+	res, err = s.IsValidChain(currentHeader, chain[10:25])
+	require.True(t, res)
+	require.NoError(t, err)
+
+	// A chain not going beyond the current header
+	res, err = s.IsValidChain(currentHeader, chain[4:10])
+	require.False(t, res)
+	require.ErrorIs(t, err, ErrLaggingHead)
+
+	_, _, _, reason, _ := milestone.ValidateChainDetailed(currentHeader, chain[4:10])
+	require.Equal(t, ReorgRejectLaggingHead, reason)
+
+	// A head at the whitelisted milestone isn't lagging
+	res, err = s.IsValidChain(&types.Header{Number: big.NewInt(20)}, chain[20:25])
+	require.True(t, res)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), LaggingHeadCounter.Snapshot().Count())
+}
+
+// TestIsValidChainRejectionErrors checks that each rejection path of IsValidChain
+// returns its matching sentinel error
+func TestIsValidChainRejectionErrors(t *testing.T) {
+	t.Parallel()
+
+	chainA := createMockChain(1, 30)
+	chainB := createMockChain(1, 30)
+
+	tests := []struct {
+		name  string
+		setup func(s *Service, m *milestone)
+		chain []*types.Header
+		err   error
+	}{
+		{
+			name:  "valid chain",
+			setup: func(s *Service, m *milestone) { s.ProcessMilestone(10, chainA[9].Hash()) },
+			chain: chainA,
+			err:   nil,
+		},
+		{
+			name:  "whitelisted milestone mismatch",
+			setup: func(s *Service, m *milestone) { s.ProcessMilestone(10, chainA[9].Hash()) },
+			chain: chainB,
+			err:   ErrFinalityMismatch,
+		},
+		{
+			name: "locked sprint mismatch",
+			setup: func(s *Service, m *milestone) {
+				m.LockMutex(20)
+				m.UnlockMutex(true, "milestoneID1", 20, chainA[19].Hash())
+			},
+			chain: chainB,
+			err:   ErrReorgNotAllowed,
+		},
+		{
+			name:  "future milestone mismatch",
+			setup: func(s *Service, m *milestone) { s.ProcessFutureMilestone(16, chainA[15].Hash()) },
+			chain: chainB,
+			err:   ErrFutureMilestoneMismatch,
+		},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewMockService(rawdb.NewMemoryDatabase())
+			test.setup(s, s.milestoneService.(*milestone))
+
+			res, err := s.IsValidChain(chainA[9], test.chain)
+			require.Equal(t, test.err == nil, res)
+
+			if test.err == nil {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, test.err)
+			}
+		})
+	}
+}
+
+// TestReorgPolicy checks the override of the locked sprint check by a reorg policy
+func TestReorgPolicy(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 20)
+	chainB := createMockChain(11, 30)
+
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID1", 20, chainA[19].Hash())
+
+	// The chain ends before the locked sprint, so it's blocked by the built-in check
+	res, err := s.IsValidChain(chainA[9], chainB[:5])
+	require.ErrorIs(t, err, ErrReorgNotAllowed)
+	require.False(t, res)
+
+	var (
+		gotLocked      LockState
+		gotWhitelisted MilestoneState
+	)
+
+	milestone.ReorgPolicy = func(chain []*types.Header, locked LockState, whitelisted MilestoneState) (bool, string) {
+		gotLocked, gotWhitelisted = locked, whitelisted
+
+		// The policy works on copies
+		chain[0].Number = big.NewInt(0)
+		locked.IDs[0] = "tampered"
+
+		return true, ""
+	}
+
+	res, err = s.IsValidChain(chainA[9], chainB[:5])
+	require.NoError(t, err)
+	require.True(t, res)
+
+	res, err = s.IsValidChainLockOnly(chainA[9], chainB[:5])
+	require.NoError(t, err)
+	require.True(t, res)
+
+	require.Equal(t, LockState{Locked: true, Number: 20, Hash: chainA[19].Hash(), IDs: []string{"tampered"}}, gotLocked)
+	require.Equal(t, MilestoneState{}, gotWhitelisted)
+	require.Equal(t, uint64(11), chainB[0].Number.Uint64())
+	require.Equal(t, []string{"milestoneID1"}, s.GetMilestoneIDsList())
+
+	// The policy can reject a chain allowed by the built-in check
+	milestone.ReorgPolicy = func(chain []*types.Header, locked LockState, whitelisted MilestoneState) (bool, string) {
+		return false, "custom rule"
+	}
+
+	res, err = s.IsValidChain(chainA[9], chainA[10:])
+	require.ErrorIs(t, err, ErrReorgNotAllowed)
+	require.ErrorContains(t, err, "custom rule")
+	require.False(t, res)
+
+	// The whitelisted milestone check still applies before the policy
+	milestone.ReorgPolicy = func(chain []*types.Header, locked LockState, whitelisted MilestoneState) (bool, string) {
+		return true, ""
+	}
+
+	s.ProcessMilestone(15, chainA[14].Hash())
+
+	res, err = s.IsValidChain(chainA[19], chainB)
+	require.ErrorIs(t, err, ErrFinalityMismatch)
+	require.False(t, res)
+}
+
+// TestEmptyChainValidation checks that an empty chain is trivially valid and doesn't panic
+func TestEmptyChainValidation(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 20)
+
+	s.ProcessMilestone(10, chainA[9].Hash())
+	s.ProcessFutureMilestone(16, chainA[15].Hash())
+
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID1", 20, chainA[19].Hash())
+
+	for _, chain := range [][]*types.Header{nil, {}} {
+		require.NotPanics(t, func() {
+			res, err := milestone.IsValidChain(chainA[11], chain)
+			require.NoError(t, err)
+			require.True(t, res)
+
+			res, err = milestone.IsValidChainLockOnly(chainA[11], chain)
+			require.NoError(t, err)
+			require.True(t, res)
+
+			valid, skipTd, pins, reason, err := milestone.ValidateChainDetailed(chainA[11], chain)
+			require.NoError(t, err)
+			require.True(t, valid)
+			require.False(t, skipTd)
+			require.Empty(t, pins)
+			require.Equal(t, ReorgRejectNone, reason)
+
+			_, valid, skipTd, err = milestone.TraceValidation(chainA[11], chain)
+			require.NoError(t, err)
+			require.True(t, valid)
+			require.False(t, skipTd)
+
+			require.True(t, milestone.IsFutureMilestoneCompatible(chain))
+		})
+	}
+}
+
+// TestValidateChainVerbose checks the report of every reason of a chain rejection
+func TestValidateChainVerbose(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 40)
+	chainB := createMockChain(11, 40)
+
+	milestone.LockMutex(36)
+	milestone.UnlockMutex(true, "milestoneID1", 36, chainA[35].Hash())
+
+	s.ProcessFutureMilestone(32, chainA[31].Hash())
+
+	// A valid chain has no reason
+	reasons, err := s.ValidateChainVerbose(chainA[9], chainA[10:])
+	require.NoError(t, err)
+	require.Empty(t, reasons)
+
+	// The chain violates both the locked sprint and the future milestone
+	reasons, err = s.ValidateChainVerbose(chainA[9], chainB)
+	require.ErrorIs(t, err, ErrReorgNotAllowed)
+	require.ErrorIs(t, err, ErrFutureMilestoneMismatch)
+	require.Len(t, reasons, 2)
+	require.Contains(t, reasons[0], ReorgRejectLocked.String())
+	require.Contains(t, reasons[1], ReorgRejectFutureMilestone.String())
+
+	// IsValidChain stops at the first failing check
+	res, err := s.IsValidChain(chainA[9], chainB)
+	require.ErrorIs(t, err, ErrReorgNotAllowed)
+	require.NotErrorIs(t, err, ErrFutureMilestoneMismatch)
+	require.False(t, res)
+
+	// The state isn't mutated
+	require.True(t, milestone.Locked)
+	require.Equal(t, []uint64{32}, milestone.FutureMilestoneOrder)
+
+	// All the checks are reported, including the whitelisted milestone
+	milestone.MaxReorgDepth = 5
+	s.ProcessMilestone(15, chainA[14].Hash())
+
+	reasons, err = s.ValidateChainVerbose(chainA[19], chainB)
+	require.ErrorIs(t, err, ErrReorgTooDeep)
+	require.ErrorIs(t, err, ErrFinalityMismatch)
+	require.ErrorIs(t, err, ErrReorgNotAllowed)
+	require.ErrorIs(t, err, ErrFutureMilestoneMismatch)
+	require.Len(t, reasons, 4)
+
+	// An empty chain is trivially valid
+	reasons, err = s.ValidateChainVerbose(chainA[9], nil)
+	require.NoError(t, err)
+	require.Empty(t, reasons)
+}
+
+// TestIsReorgAllowedSparseChain checks the locked sprint check of contiguous and sparse chains
+func TestIsReorgAllowedSparseChain(t *testing.T) {
+	t.Parallel()
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	chainA := createMockChain(1, 30)
+	chainB := createMockChain(1, 30)
+
+	lockedHash := chainA[19].Hash()
+
+	// Contiguous chain matching the locked sprint
+	require.True(t, milestone.IsReorgAllowed(chainA[10:], 20, lockedHash))
+
+	// Contiguous chain mismatching the locked sprint
+	require.False(t, milestone.IsReorgAllowed(chainB[10:], 20, lockedHash))
+
+	// Sparse chain spanning the locked sprint without its block
+	sparse := append(append([]*types.Header{}, chainA[10:19]...), chainA[20:]...)
+	require.False(t, milestone.IsReorgAllowed(sparse, 20, lockedHash))
+
+	// Sparse chain skipping other blocks, but containing the locked one
+	sparse = append(append([]*types.Header{}, chainA[10:15]...), chainA[16:]...)
+	require.True(t, milestone.IsReorgAllowed(sparse, 20, lockedHash))
+
+	// Chain entirely beyond the locked sprint
+	require.True(t, milestone.IsReorgAllowed(chainB[20:], 20, lockedHash))
+
+	// Chain ending at or before the locked sprint
+	require.False(t, milestone.IsReorgAllowed(chainA[10:20], 20, lockedHash))
+
+	// Through the chain validation
+	milestone.LockMutex(20)
+	milestone.UnlockMutex(true, "milestoneID1", 20, lockedHash)
+
+	sparse = append(append([]*types.Header{}, chainA[10:19]...), chainA[20:]...)
+
+	res, err := s.IsValidChain(chainA[9], sparse)
+	require.ErrorIs(t, err, ErrReorgNotAllowed)
+	require.False(t, res)
+}
+
+// TestIsValidChainWithSkipTd checks the combined checkpoint and milestone validation
+func TestIsValidChainWithSkipTd(t *testing.T) {
+	t.Parallel()
+
+	chainA := createMockChain(1, 40)
+	chainB := createMockChain(1, 40)
+
+	// Checkpoint rejects while the milestone accepts
+	s := NewMockService(rawdb.NewMemoryDatabase())
+
+	s.ProcessCheckpoint(10, chainB[9].Hash())
+	s.ProcessFutureMilestone(32, chainA[31].Hash())
+
+	valid, _, _, _, err := s.ValidateChainDetailed(chainA[19], chainA)
+	require.NoError(t, err)
+	require.True(t, valid)
+
+	valid, skipTd, err := s.IsValidChainWithSkipTd(chainA[19], chainA)
+	require.NoError(t, err)
+	require.False(t, valid)
+	require.False(t, skipTd, "expected the skip to require the checkpoint acceptance")
+
+	// Milestone rejects while the checkpoint accepts
+	s = NewMockService(rawdb.NewMemoryDatabase())
+
+	s.ProcessCheckpoint(10, chainA[9].Hash())
+	s.ProcessMilestone(15, chainB[14].Hash())
+
+	res, err := s.checkpointService.IsValidChain(chainA[19], chainA)
+	require.NoError(t, err)
+	require.True(t, res)
+
+	valid, skipTd, err = s.IsValidChainWithSkipTd(chainA[19], chainA)
+	require.ErrorIs(t, err, ErrFinalityMismatch)
+	require.False(t, valid)
+	require.False(t, skipTd)
+
+	// Both accept, the matched future milestone permits the skip
+	s = NewMockService(rawdb.NewMemoryDatabase())
+
+	s.ProcessCheckpoint(10, chainA[9].Hash())
+	s.ProcessMilestone(15, chainA[14].Hash())
+	s.ProcessFutureMilestone(32, chainA[31].Hash())
+
+	valid, skipTd, err = s.IsValidChainWithSkipTd(chainA[19], chainA)
+	require.NoError(t, err)
+	require.True(t, valid)
+	require.True(t, skipTd)
+
+	// Both accept, without a future milestone in the chain there's no skip
+	valid, skipTd, err = s.IsValidChainWithSkipTd(chainA[19], chainA[:25])
+	require.NoError(t, err)
+	require.True(t, valid)
+	require.False(t, skipTd)
+}
+
+// TestReorgGuardDepth checks the refusal of the chains starting right below the locked sprint
+func TestReorgGuardDepth(t *testing.T) {
+	t.Parallel()
+
+	chainA := createMockChain(1, 30)
+	lockedHash := chainA[19].Hash()
+	current := chainA[len(chainA)-1]
+
+	// No margin by default
+	s, m := newMockMilestone(rawdb.NewMemoryDatabase())
+	require.True(t, s.LockMilestone(20, lockedHash, "milestoneID1"))
+
+	m.AllowLockedHashMismatch = true
+
+	require.Zero(t, m.ReorgGuardDepth)
+	require.NoError(t, m.checkReorgAllowed(current, createMockChain(17, 30)))
+
+	s = NewService(rawdb.NewMemoryDatabase(), WithReorgGuardDepth(4))
+	m = s.milestoneService.(*milestone)
+	require.True(t, s.LockMilestone(20, lockedHash, "milestoneID1"))
+
+	m.AllowLockedHashMismatch = true
+
+	require.Equal(t, uint64(4), m.ReorgGuardDepth)
+
+	// Reorgs forking within the margin are refused, even if the mismatch is allowed
+	require.ErrorIs(t, m.checkReorgAllowed(current, createMockChain(17, 30)), ErrReorgNotAllowed)
+	require.ErrorIs(t, m.checkReorgAllowed(current, createMockChain(20, 30)), ErrReorgNotAllowed)
+
+	// Reorgs forking deeper or above the locked sprint aren't affected
+	require.NoError(t, m.checkReorgAllowed(current, createMockChain(16, 30)))
+	require.NoError(t, m.checkReorgAllowed(current, createMockChain(21, 30)))
+
+	// Chains containing the locked hash aren't affected
+	require.NoError(t, m.checkReorgAllowed(current, chainA[15:]))
+	require.NoError(t, m.checkReorgAllowed(current, chainA[19:]))
+
+	// Chains extending the current chain, like the segments of the sync, aren't affected
+	require.NoError(t, m.checkReorgAllowed(chainA[15], createMockChain(17, 30)))
+
+	// The guard depth is measured against the fork point of the current chain
+	require.ErrorIs(t, m.checkReorgAllowed(chainA[16], createMockChain(17, 30)), ErrReorgNotAllowed)
+}
+
+// TestVerifyChain checks that the verdict reports a future milestone match as the
+// reason of the total difficulty skip only when a future milestone matched
+func TestVerifyChain(t *testing.T) {
+	t.Parallel()
+
+	chainA := createMockChain(1, 40)
+	chainB := createMockChain(1, 40)
+
+	s, milestone := newMockMilestone(rawdb.NewMemoryDatabase())
+
+	s.ProcessCheckpoint(10, chainA[9].Hash())
+	s.ProcessMilestone(15, chainA[14].Hash())
+
+	// No future milestone
+	verdict, err := s.VerifyChain(chainA[19], chainA)
+	require.NoError(t, err)
+	require.Equal(t, ChainVerdict{Valid: true, Reason: SkipNone}, verdict)
+
+	// Matching future milestone
+	s.ProcessFutureMilestone(32, chainA[31].Hash())
+
+	verdict, err = s.VerifyChain(chainA[19], chainA)
+	require.NoError(t, err)
+	require.Equal(t, ChainVerdict{Valid: true, SkipTd: true, Reason: SkipFutureMilestoneMatch}, verdict)
+	require.Equal(t, "future milestone match", verdict.Reason.String())
+
+	valid, skipTd, err := s.IsValidChainWithSkipTd(chainA[19], chainA)
+	require.NoError(t, err)
+	require.True(t, valid)
+	require.True(t, skipTd)
+
+	// Future milestone beyond the chain
+	verdict, err = s.VerifyChain(chainA[19], chainA[:25])
+	require.NoError(t, err)
+	require.Equal(t, ChainVerdict{Valid: true, Reason: SkipNone}, verdict)
+
+	// Spanned without being part of the chain, with PreferMilestoneOverTd
+	sparse := append(append([]*types.Header{}, chainA[15:31]...), chainA[32:]...)
+
+	verdict, err = s.VerifyChain(chainA[14], sparse)
+	require.NoError(t, err)
+	require.Equal(t, ChainVerdict{Valid: true, Reason: SkipNone}, verdict)
+
+	milestone.PreferMilestoneOverTd = true
+
+	verdict, err = s.VerifyChain(chainA[14], sparse)
+	require.NoError(t, err)
+	require.Equal(t, ChainVerdict{Valid: true, SkipTd: true, Reason: SkipFutureMilestoneSpanned}, verdict)
+
+	// Mismatching future milestone
+	verdict, err = s.VerifyChain(chainB[19], chainB[20:])
+	require.ErrorIs(t, err, ErrFutureMilestoneMismatch)
+	require.Equal(t, ChainVerdict{}, verdict)
+}
@@ -0,0 +1,282 @@
+package whitelist
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/log"
+)
+
+// walRetryBackoff is the delay before the background writer retries a record which
+// failed to apply
+const walRetryBackoff = time.Second
+
+// WithWriteAheadLog makes the milestone persistence go through a write-ahead log, see
+// EnableWriteAheadLog
+func WithWriteAheadLog() ServiceOption {
+	return func(m *milestone) {
+		m.wal = newMilestoneWAL(m.db, m.reportWALApply)
+	}
+}
+
+// milestoneWAL is a write-ahead log of the milestone persistence. The intent records
+// are stored synchronously, while a background writer applies them to the main keys
+// in order, so that the finality decisions don't wait for the main writes. A record
+// is removed from the log once applied. A record failing to apply stays at the head
+// of the log and is retried, so that no newer record overtakes it. The records left
+// unapplied by a crash are replayed by NewService.
+type milestoneWAL struct {
+	db     ethdb.Database
+	report func(err error) // Reports the outcome of each apply
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	seq     uint64 // Sequence number of the next record
+	pending []walPending
+	closed  bool
+
+	quit      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+type walPending struct {
+	seq    uint64
+	record stateRecord
+}
+
+// newMilestoneWAL creates the log, queuing the records left in the db, if any, ahead
+// of the new ones
+func newMilestoneWAL(db ethdb.Database, report func(err error)) *milestoneWAL {
+	w := &milestoneWAL{db: db, report: report, quit: make(chan struct{})}
+	w.cond = sync.NewCond(&w.mu)
+
+	pending, err := readMilestoneWAL(db)
+	if err != nil {
+		log.Error("Error in reading the milestone WAL", "err", err)
+	}
+
+	w.pending = pending
+
+	if len(pending) > 0 {
+		w.seq = pending[len(pending)-1].seq + 1
+	}
+
+	return w
+}
+
+// start launches the background writer
+func (w *milestoneWAL) start() {
+	w.wg.Add(1)
+
+	go w.loop()
+}
+
+// append stores the intent record and queues it for the background writer. The
+// record must not share its maps and slices with the live state.
+func (w *milestoneWAL) append(record stateRecord) error {
+	enc, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := rawdb.WriteMilestoneWALEntry(w.db, w.seq, enc); err != nil {
+		return err
+	}
+
+	w.pending = append(w.pending, walPending{seq: w.seq, record: record})
+	w.seq++
+
+	w.cond.Broadcast()
+
+	return nil
+}
+
+// loop applies the queued records in order, until the log is closed. The records
+// still queued on close are attempted once, the failing ones being left for replay.
+func (w *milestoneWAL) loop() {
+	defer w.wg.Done()
+
+	for {
+		w.mu.Lock()
+
+		for len(w.pending) == 0 && !w.closed {
+			w.cond.Wait()
+		}
+
+		if len(w.pending) == 0 {
+			w.mu.Unlock()
+			return
+		}
+
+		next, closed := w.pending[0], w.closed
+
+		w.mu.Unlock()
+
+		err := next.record.apply(w.db)
+		if err == nil {
+			err = rawdb.DeleteMilestoneWALEntry(w.db, next.seq)
+		}
+
+		if w.report != nil {
+			w.report(err)
+		}
+
+		if err != nil {
+			log.Error("Error in applying the milestone WAL record", "seq", next.seq, "err", err)
+
+			if closed {
+				return
+			}
+
+			select {
+			case <-time.After(walRetryBackoff):
+			case <-w.quit:
+			}
+
+			continue
+		}
+
+		w.mu.Lock()
+		w.pending = w.pending[1:]
+		w.cond.Broadcast()
+		w.mu.Unlock()
+	}
+}
+
+// sync waits until the queued records are applied
+func (w *milestoneWAL) sync() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for len(w.pending) > 0 && !w.closed {
+		w.cond.Wait()
+	}
+}
+
+// close applies the queued records and stops the background writer
+func (w *milestoneWAL) close() {
+	w.closeOnce.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		w.cond.Broadcast()
+		w.mu.Unlock()
+
+		close(w.quit)
+	})
+
+	w.wg.Wait()
+}
+
+// readMilestoneWAL decodes the intent records stored in the log, in order
+func readMilestoneWAL(db ethdb.Database) ([]walPending, error) {
+	seqs, entries, err := rawdb.ReadMilestoneWALEntries(db)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]walPending, 0, len(seqs))
+
+	for i, entry := range entries {
+		var record stateRecord
+
+		if err := json.Unmarshal(entry, &record); err != nil {
+			return pending, fmt.Errorf("decoding milestone WAL record %d: %w", seqs[i], err)
+		}
+
+		pending = append(pending, walPending{seq: seqs[i], record: record})
+	}
+
+	return pending, nil
+}
+
+// replayMilestoneWAL applies the intent records left in the log by a crash and
+// removes them. It stops at the first record which can't be applied, to keep the
+// records in order.
+func replayMilestoneWAL(db ethdb.Database) error {
+	pending, err := readMilestoneWAL(db)
+	if err != nil {
+		return err
+	}
+
+	if len(pending) > 0 {
+		log.Info("Replaying the milestone WAL", "records", len(pending))
+	}
+
+	for _, p := range pending {
+		if err := p.record.apply(db); err != nil {
+			return fmt.Errorf("applying milestone WAL record %d: %w", p.seq, err)
+		}
+
+		if err := rawdb.DeleteMilestoneWALEntry(db, p.seq); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// reportWALApply records the outcome of a write-ahead log record applied in the
+// background in the persistence breaker
+func (m *milestone) reportWALApply(err error) {
+	m.finality.Lock()
+	defer m.finality.Unlock()
+
+	m.recordPersistence(err)
+}
+
+// EnableWriteAheadLog makes the milestone persistence go through a write-ahead log,
+// applied to the main keys in the background. DisableWriteAheadLog must be called
+// before shutting down, to apply the remaining records.
+func (m *milestone) EnableWriteAheadLog() {
+	m.finality.Lock()
+	defer m.finality.Unlock()
+
+	if m.wal != nil {
+		return
+	}
+
+	m.wal = newMilestoneWAL(m.db, m.reportWALApply)
+	m.wal.start()
+}
+
+// DisableWriteAheadLog applies the remaining records of the write-ahead log and goes
+// back to writing the main keys directly
+func (m *milestone) DisableWriteAheadLog() {
+	m.finality.RLock()
+	wal := m.wal
+	m.finality.RUnlock()
+
+	if wal == nil {
+		return
+	}
+
+	// The writer reports to the breaker under the finality lock, hence it's drained
+	// without holding it
+	wal.close()
+
+	m.finality.Lock()
+	defer m.finality.Unlock()
+
+	if m.wal != wal {
+		return
+	}
+
+	m.wal = nil
+
+	// The records appended while closing, or left by a failing apply, would otherwise
+	// be replayed over the direct writes on the next start
+	err := replayMilestoneWAL(m.db)
+	if err != nil {
+		m.logger().Error("Error in applying the remaining milestone WAL records", "err", err)
+	}
+
+	m.recordPersistence(err)
+}
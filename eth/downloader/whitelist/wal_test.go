@@ -0,0 +1,206 @@
+package whitelist
+
+import (
+	"bytes"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/maps"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/ethdb"
+)
+
+// mainKeyFailingDB is a database whose writes to the main milestone keys fail while
+// fail is set, the write-ahead log entries being stored
+type mainKeyFailingDB struct {
+	ethdb.Database
+	fail atomic.Bool
+}
+
+func (db *mainKeyFailingDB) Put(key []byte, value []byte) error {
+	if db.fail.Load() && !bytes.HasPrefix(key, []byte("MilestoneWAL-")) {
+		return errors.New("disk failure")
+	}
+
+	return db.Database.Put(key, value)
+}
+
+// TestMilestoneWALReplay checks the replay of the write-ahead log after a crash, i.e.
+// with the intent records stored but never applied to the main keys
+func TestMilestoneWALReplay(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s, m := newMockMilestone(db)
+
+	// The background writer isn't started, simulating a crash before any apply
+	m.wal = newMilestoneWAL(db, nil)
+
+	s.ProcessMilestone(10, common.Hash{0x1})
+	m.LockMutex(20)
+	m.UnlockMutex(true, "milestoneID1", 20, common.Hash{0x2})
+	s.ProcessFutureMilestone(16, common.Hash{0x3})
+
+	_, _, err := rawdb.ReadFinality[*rawdb.Milestone](db)
+	require.Error(t, err)
+
+	_, _, _, _, err = rawdb.ReadLockField(db)
+	require.Error(t, err)
+
+	seqs, _, err := rawdb.ReadMilestoneWALEntries(db)
+	require.NoError(t, err)
+	require.NotEmpty(t, seqs)
+
+	// Restarting replays the log
+	s = NewService(db)
+	m = s.milestoneService.(*milestone)
+
+	doExist, number, hash := s.milestoneService.Get()
+	require.True(t, doExist)
+	require.Equal(t, uint64(10), number)
+	require.Equal(t, common.Hash{0x1}, hash)
+
+	require.True(t, m.Locked)
+	require.Equal(t, uint64(20), m.LockedMilestoneNumber)
+	require.Equal(t, common.Hash{0x2}, m.LockedMilestoneHash)
+	require.Equal(t, []string{"milestoneID1"}, maps.Keys(m.LockedMilestoneIDs))
+
+	require.Equal(t, []uint64{16}, m.FutureMilestoneOrder)
+	require.Equal(t, common.Hash{0x3}, m.FutureMilestoneList[16])
+
+	// The log is truncated once applied
+	seqs, _, err = rawdb.ReadMilestoneWALEntries(db)
+	require.NoError(t, err)
+	require.Empty(t, seqs)
+}
+
+// TestMilestoneWALWriter checks that the background writer applies the intent records
+// in order and truncates the log
+func TestMilestoneWALWriter(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewService(db, WithWriteAheadLog())
+
+	for i := uint64(1); i <= 20; i++ {
+		s.ProcessMilestone(i*10, common.Hash{byte(i)})
+	}
+
+	s.ProcessFutureMilestone(300, common.Hash{0x30})
+
+	s.DisableWriteAheadLog()
+
+	number, hash, err := rawdb.ReadFinality[*rawdb.Milestone](db)
+	require.NoError(t, err)
+	require.Equal(t, uint64(200), number)
+	require.Equal(t, common.Hash{20}, hash)
+
+	order, list, err := rawdb.ReadFutureMilestoneList(db)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{300}, order)
+	require.Equal(t, common.Hash{0x30}, list[300])
+
+	seqs, _, err := rawdb.ReadMilestoneWALEntries(db)
+	require.NoError(t, err)
+	require.Empty(t, seqs)
+
+	// Writing directly again
+	s.ProcessMilestone(210, common.Hash{21})
+
+	number, _, err = rawdb.ReadFinality[*rawdb.Milestone](db)
+	require.NoError(t, err)
+	require.Equal(t, uint64(210), number)
+}
+
+// TestMilestoneWALApplyFailure checks that a record failing to apply is kept and
+// retried in order, its failures being reported to the persistence breaker
+func TestMilestoneWALApplyFailure(t *testing.T) {
+	t.Parallel()
+
+	db := &mainKeyFailingDB{Database: rawdb.NewMemoryDatabase()}
+	s, m := newMockMilestone(db)
+	m.PersistenceFailureThreshold = 1
+
+	s.EnableWriteAheadLog()
+
+	db.fail.Store(true)
+
+	s.ProcessMilestone(10, common.Hash{0x1})
+	s.ProcessMilestone(20, common.Hash{0x2})
+
+	require.Eventually(t, s.IsPersistenceDegraded, time.Second, 10*time.Millisecond)
+
+	seqs, _, err := rawdb.ReadMilestoneWALEntries(db)
+	require.NoError(t, err)
+	require.Len(t, seqs, 2, "expected the failing records to be kept")
+
+	// The records are applied in order once the db recovers
+	db.fail.Store(false)
+
+	m.wal.sync()
+
+	number, hash, err := rawdb.ReadFinality[*rawdb.Milestone](db)
+	require.NoError(t, err)
+	require.Equal(t, uint64(20), number)
+	require.Equal(t, common.Hash{0x2}, hash)
+
+	seqs, _, err = rawdb.ReadMilestoneWALEntries(db)
+	require.NoError(t, err)
+	require.Empty(t, seqs)
+
+	s.DisableWriteAheadLog()
+}
+
+// TestMilestoneWALCloseFailure checks that the records failing to apply on close are
+// left in the log and replayed on the next start
+func TestMilestoneWALCloseFailure(t *testing.T) {
+	t.Parallel()
+
+	db := &mainKeyFailingDB{Database: rawdb.NewMemoryDatabase()}
+	s, _ := newMockMilestone(db)
+
+	s.EnableWriteAheadLog()
+
+	db.fail.Store(true)
+	s.ProcessMilestone(10, common.Hash{0x1})
+	s.DisableWriteAheadLog()
+
+	seqs, _, err := rawdb.ReadMilestoneWALEntries(db)
+	require.NoError(t, err)
+	require.Len(t, seqs, 1)
+
+	db.fail.Store(false)
+
+	s = NewService(db)
+
+	doExist, number, hash := s.milestoneService.Get()
+	require.True(t, doExist)
+	require.Equal(t, uint64(10), number)
+	require.Equal(t, common.Hash{0x1}, hash)
+}
+
+// TestPurgeAllWriteAheadLog checks that the queued writes don't survive the purge
+func TestPurgeAllWriteAheadLog(t *testing.T) {
+	t.Parallel()
+
+	db := rawdb.NewMemoryDatabase()
+	s := NewService(db, WithWriteAheadLog())
+
+	s.ProcessMilestone(16, common.Hash{0x1})
+	s.ProcessFutureMilestone(64, common.Hash{0x4})
+
+	require.NoError(t, s.PurgeAll())
+
+	s.DisableWriteAheadLog()
+
+	_, _, err := rawdb.ReadFinality[*rawdb.Milestone](db)
+	require.Error(t, err)
+
+	_, _, err = rawdb.ReadFutureMilestoneList(db)
+	require.Error(t, err)
+}
@@ -37,6 +37,7 @@ import (
 	"github.com/ethereum/go-ethereum/core/txpool/blobpool"
 	"github.com/ethereum/go-ethereum/core/txpool/legacypool"
 	"github.com/ethereum/go-ethereum/eth/downloader"
+	"github.com/ethereum/go-ethereum/eth/downloader/whitelist"
 	"github.com/ethereum/go-ethereum/eth/gasprice"
 	"github.com/ethereum/go-ethereum/ethdb"
 	"github.com/ethereum/go-ethereum/internal/ethapi"
@@ -84,6 +85,8 @@ var Defaults = Config{
 	RPCEVMTimeout:      5 * time.Second,
 	GPO:                FullNodeGPO,
 	RPCTxFeeCap:        1, // 1 ether
+
+	FutureMilestoneMaxCapacity: whitelist.DefaultFutureMilestoneCapacity,
 }
 
 //go:generate go run github.com/fjl/gencodec -type Config -formats toml -out gen_config.go
@@ -196,6 +199,12 @@ type Config struct {
 	// Bor logs flag
 	BorLogs bool
 
+	// Capacity of the future milestone list
+	FutureMilestoneMaxCapacity int
+
+	// Evict the lowest future milestone in favor of a higher one once the list is full
+	FutureMilestoneEvictLowest bool
+
 	// Parallel EVM (Block-STM) related config
 	ParallelEVM core.ParallelEVMConfig `toml:",omitempty"`
 
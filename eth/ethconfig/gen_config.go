@@ -66,6 +66,8 @@ func (c Config) MarshalTOML() (interface{}, error) {
 		RunHeimdallArgs                      string
 		UseHeimdallApp                       bool
 		BorLogs                              bool
+		FutureMilestoneMaxCapacity           int
+		FutureMilestoneEvictLowest           bool
 		ParallelEVM                          core.ParallelEVMConfig `toml:",omitempty"`
 		DevFakeAuthor                        bool                   `hcl:"devfakeauthor,optional" toml:"devfakeauthor,optional"`
 		OverrideVerkle                       *big.Int               `toml:",omitempty"`
@@ -119,6 +121,8 @@ func (c Config) MarshalTOML() (interface{}, error) {
 	enc.RunHeimdallArgs = c.RunHeimdallArgs
 	enc.UseHeimdallApp = c.UseHeimdallApp
 	enc.BorLogs = c.BorLogs
+	enc.FutureMilestoneMaxCapacity = c.FutureMilestoneMaxCapacity
+	enc.FutureMilestoneEvictLowest = c.FutureMilestoneEvictLowest
 	enc.ParallelEVM = c.ParallelEVM
 	enc.DevFakeAuthor = c.DevFakeAuthor
 	enc.OverrideVerkle = c.OverrideVerkle
@@ -176,6 +180,8 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 		RunHeimdallArgs                      *string
 		UseHeimdallApp                       *bool
 		BorLogs                              *bool
+		FutureMilestoneMaxCapacity           *int
+		FutureMilestoneEvictLowest           *bool
 		ParallelEVM                          *core.ParallelEVMConfig `toml:",omitempty"`
 		DevFakeAuthor                        *bool                   `hcl:"devfakeauthor,optional" toml:"devfakeauthor,optional"`
 		OverrideVerkle                       *big.Int                `toml:",omitempty"`
@@ -328,6 +334,12 @@ func (c *Config) UnmarshalTOML(unmarshal func(interface{}) error) error {
 	if dec.BorLogs != nil {
 		c.BorLogs = *dec.BorLogs
 	}
+	if dec.FutureMilestoneMaxCapacity != nil {
+		c.FutureMilestoneMaxCapacity = *dec.FutureMilestoneMaxCapacity
+	}
+	if dec.FutureMilestoneEvictLowest != nil {
+		c.FutureMilestoneEvictLowest = *dec.FutureMilestoneEvictLowest
+	}
 	if dec.ParallelEVM != nil {
 		c.ParallelEVM = *dec.ParallelEVM
 	}
@@ -45,7 +45,10 @@ func (h *ethHandler) fetchWhitelistCheckpoint(ctx context.Context, bor *bor.Bor,
 	hash, err := verifier.verify(ctx, eth, h, checkpoint.StartBlock.Uint64(), checkpoint.EndBlock.Uint64(), checkpoint.RootHash.String()[2:], true)
 	if err != nil {
 		log.Warn("Failed to whitelist checkpoint", "err", err)
-		return blockNum, blockHash, err
+
+		// The end block is returned along with the error, e.g. to buffer the checkpoint
+		// ending beyond the current chain
+		return checkpoint.EndBlock.Uint64(), blockHash, err
 	}
 
 	blockNum = checkpoint.EndBlock.Uint64()
@@ -255,14 +255,16 @@ type ChainValidator interface {
 	GetWhitelistedCheckpoint() (bool, uint64, common.Hash)
 	GetWhitelistedMilestone() (bool, uint64, common.Hash)
 	ProcessCheckpoint(endBlockNum uint64, endBlockHash common.Hash)
+	ProcessFutureCheckpoint(num uint64, hash common.Hash)
 	ProcessMilestone(endBlockNum uint64, endBlockHash common.Hash)
 	ProcessFutureMilestone(num uint64, hash common.Hash)
+	GetFutureMilestone(number uint64) (common.Hash, bool)
 	PurgeWhitelistedCheckpoint()
 	PurgeWhitelistedMilestone()
 
 	LockMutex(endBlockNum uint64) bool
 	UnlockMutex(doLock bool, milestoneId string, endBlockNum uint64, endBlockHash common.Hash)
-	UnlockSprint(endBlockNum uint64)
+	UnlockSprint(endBlockNum uint64) bool
 	RemoveMilestoneID(milestoneId string)
 	GetMilestoneIDsList() []string
 }
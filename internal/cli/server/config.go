@@ -26,6 +26,7 @@ import (
 	"github.com/ethereum/go-ethereum/common/fdlimit"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/eth/downloader"
+	"github.com/ethereum/go-ethereum/eth/downloader/whitelist"
 	"github.com/ethereum/go-ethereum/eth/ethconfig"
 	"github.com/ethereum/go-ethereum/eth/gasprice"
 	"github.com/ethereum/go-ethereum/internal/cli/server/chains"
@@ -90,6 +91,13 @@ type Config struct {
 	// BorLogs enables bor log retrieval
 	BorLogs bool `hcl:"bor.logs,optional" toml:"bor.logs,optional"`
 
+	// FutureMilestoneMaxCapacity is the maximum number of future milestones kept in memory
+	FutureMilestoneMaxCapacity int `hcl:"bor.futuremilestone.maxcapacity,optional" toml:"bor.futuremilestone.maxcapacity,optional"`
+
+	// FutureMilestoneEvictLowest evicts the lowest future milestone in favor of a higher one
+	// once the capacity is reached, instead of dropping the new one
+	FutureMilestoneEvictLowest bool `hcl:"bor.futuremilestone.evictlowest,optional" toml:"bor.futuremilestone.evictlowest,optional"`
+
 	// Ethstats is the address of the ethstats server to send telemetry
 	Ethstats string `hcl:"ethstats,optional" toml:"ethstats,optional"`
 
@@ -642,6 +650,8 @@ func DefaultConfig() *Config {
 		GcMode:   "full",
 		Snapshot: true,
 		BorLogs:  false,
+
+		FutureMilestoneMaxCapacity: whitelist.DefaultFutureMilestoneCapacity,
 		TxPool: &TxPoolConfig{
 			Locals:       []string{},
 			NoLocals:     false,
@@ -1176,6 +1186,13 @@ func (c *Config) buildEth(stack *node.Node, accountManager *accounts.Manager) (*
 	}
 
 	n.BorLogs = c.BorLogs
+
+	if c.FutureMilestoneMaxCapacity < 1 {
+		return nil, fmt.Errorf("bor.futuremilestone.maxcapacity must be at least 1, got %d", c.FutureMilestoneMaxCapacity)
+	}
+
+	n.FutureMilestoneMaxCapacity = c.FutureMilestoneMaxCapacity
+	n.FutureMilestoneEvictLowest = c.FutureMilestoneEvictLowest
 	n.DatabaseHandles = dbHandles
 
 	n.ParallelEVM.Enable = c.ParallelEVM.Enable
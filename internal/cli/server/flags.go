@@ -116,6 +116,18 @@ func (c *Command) Flags(config *Config) *flagset.Flagset {
 		Value:   &c.cliConfig.BorLogs,
 		Default: c.cliConfig.BorLogs,
 	})
+	f.IntFlag(&flagset.IntFlag{
+		Name:    "bor.futuremilestone.maxcapacity",
+		Usage:   "Maximum number of future milestones kept in memory, the new ones are dropped beyond it unless bor.futuremilestone.evictlowest is set",
+		Value:   &c.cliConfig.FutureMilestoneMaxCapacity,
+		Default: c.cliConfig.FutureMilestoneMaxCapacity,
+	})
+	f.BoolFlag(&flagset.BoolFlag{
+		Name:    "bor.futuremilestone.evictlowest",
+		Usage:   "Evict the lowest future milestone in favor of a higher new one once bor.futuremilestone.maxcapacity is reached",
+		Value:   &c.cliConfig.FutureMilestoneEvictLowest,
+		Default: c.cliConfig.FutureMilestoneEvictLowest,
+	})
 
 	// logging related flags (log-level and verbosity is present above, it will be removed soon)
 	f.StringFlag(&flagset.StringFlag{